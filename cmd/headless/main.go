@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/geotry/stago/compute"
+	"github.com/geotry/stago/examples"
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+	"github.com/geotry/stago/simulation"
+)
+
+var ticks = flag.Int("ticks", 600, "Number of ticks to run")
+var tickRate = flag.Int("tick-rate", simulation.TICKS_PER_SEC, "Number of ticks per second of simulated time")
+var sceneName = flag.String("scene", "demo", "Name of the registered scene to run")
+var seed = flag.Uint64("seed", 1, "Seed of the randomness of the scene")
+var input = flag.String("input", "", "Replay input events recorded in this file (see server -record)")
+var out = flag.String("out", "", "Write the report in this file instead of stdout")
+var export = flag.String("export", "", "Export textures and meshes of scenes in this folder once all ticks ran")
+
+// Report written once all ticks ran
+type report struct {
+	TickRate int                    `json:"tick_rate"`
+	Seed     uint64                 `json:"seed"`
+	Ticks    []simulation.TickStats `json:"ticks"`
+	Scenes   []sceneState           `json:"scenes"`
+}
+
+type sceneState struct {
+	Name  string      `json:"name"`
+	Nodes []nodeState `json:"nodes"`
+}
+
+type nodeState struct {
+	Id       uint32             `json:"id"`
+	Object   string             `json:"object"`
+	Parent   uint32             `json:"parent,omitempty"`
+	Hidden   bool               `json:"hidden,omitempty"`
+	Position compute.Vector3    `json:"position"`
+	Rotation compute.Quaternion `json:"rotation"`
+	Scale    compute.Vector3    `json:"scale"`
+}
+
+func main() {
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registry := scene.NewRegistry()
+	examples.Register(registry)
+
+	rm := rendering.NewResourceManager()
+	scn, err := registry.New(*sceneName, rm)
+	if err != nil {
+		log.Fatalf("failed to create scene: %v (registered scenes: %s)", err, strings.Join(registry.Names(), ", "))
+	}
+	scn.Reseed(*seed)
+
+	// Ticks are only run by RunTicks(), the clock never moves
+	sim := simulation.NewSimulation(rm, simulation.SimulationOptions{
+		TickRate: *tickRate,
+		Clock:    scene.NewManualClock(time.Unix(0, 0)),
+	})
+	sim.AddScene(scn)
+	sim.Start(ctx)
+
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			log.Fatalf("failed to open input: %v", err)
+		}
+		if err := sim.Replay(f); err != nil {
+			log.Fatalf("failed to replay %s: %v", *input, err)
+		}
+		f.Close()
+	}
+
+	r := report{TickRate: sim.TickRate(), Seed: *seed}
+	r.Ticks = sim.RunTicks(*ticks)
+	final, err := state(scn)
+	if err != nil {
+		log.Fatalf("failed to read state of scene %s: %v", scn.Name, err)
+	}
+	r.Scenes = []sceneState{final}
+
+	if *export != "" {
+		if err := sim.ExportAssets(*export); err != nil {
+			log.Fatalf("failed to export assets: %v", err)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("failed to create report: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+}
+
+// Return the nodes of the scene, read from a snapshot taken under the
+// lock of the scene
+func state(scn *scene.Scene) (sceneState, error) {
+	snap, err := scn.Snapshot()
+	if err != nil {
+		return sceneState{}, err
+	}
+
+	s := sceneState{Name: snap.Name, Nodes: make([]nodeState, 0, len(snap.Nodes))}
+	for _, node := range snap.Nodes {
+		s.Nodes = append(s.Nodes, nodeState{
+			Id:       node.Id,
+			Object:   node.Object,
+			Parent:   node.Parent,
+			Hidden:   node.Hidden,
+			Position: node.Position,
+			Rotation: node.Rotation,
+			Scale:    node.Scale,
+		})
+	}
+	return s, nil
+}
@@ -2,36 +2,136 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 
 	"net/http"
-	_ "net/http/pprof"
+	"net/http/pprof"
 
+	"github.com/geotry/stago/examples"
+	"github.com/geotry/stago/scene"
 	"github.com/geotry/stago/server"
+	"github.com/geotry/stago/simulation"
 	"github.com/gorilla/websocket"
 )
 
 var port = flag.Int("port", 9090, "The websocket server port")
+var addr = flag.String("addr", "", "Address of the interface to listen on, all interfaces if empty")
+var origins = flag.String("origins", "http://localhost:*", "Comma separated origins allowed to connect, exact (https://example.com) or with wildcards (https://*.example.com), or * for any")
+var tlsCert = flag.String("tls-cert", "", "Certificate file to serve over TLS, with -tls-key")
+var tlsKey = flag.String("tls-key", "", "Private key file of the TLS certificate")
+var record = flag.String("record", "", "Record input events of sessions in this file")
+var replay = flag.String("replay", "", "Replay input events recorded in this file")
+var debug = flag.Bool("debug", false, "Export textures and meshes of scenes at /debug/assets")
+var pprofAddr = flag.String("pprof", "", "Serve profiles of net/http/pprof at this address (e.g. localhost:6060), disabled if empty")
+var scenes = flag.String("scenes", "", "Comma separated names of the scenes to load, all registered scenes if empty")
+var users = flag.String("users", ".out/users", "Save the state of users in this folder")
+var secret = flag.String("secret", os.Getenv("STAGO_SECRET"), "Secret signing session tokens, a random secret if empty (default $STAGO_SECRET)")
+var adminKey = flag.String("admin-key", os.Getenv("STAGO_ADMIN_KEY"), "Key of clients requesting admin tokens, no admin if empty (default $STAGO_ADMIN_KEY)")
 
 var upgrader = websocket.Upgrader{
-	Subprotocols: []string{"render", "input"},
-	CheckOrigin: func(r *http.Request) bool {
-		return r.Host == fmt.Sprintf("localhost:%d", *port)
-	},
+	Subprotocols: []string{server.PROTOCOL_STAGO_PROTO, server.PROTOCOL_STAGO, server.PROTOCOL_RENDER, server.PROTOCOL_INPUT},
 }
 
 func main() {
 	flag.Parse()
 
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatalf("-tls-cert and -tls-key must be set together")
+	}
+
+	allowed := server.ParseOrigins(*origins)
+	upgrader.CheckOrigin = allowed.CheckOrigin
+
+	registry := scene.NewRegistry()
+	examples.Register(registry)
+
+	opts := server.Options{
+		Registry: registry,
+		Secret:   []byte(*secret),
+		AdminKey: *adminKey,
+		SimulationOptions: simulation.SimulationOptions{
+			Users: simulation.NewFileUserStore(*users),
+		},
+	}
+	if *scenes != "" {
+		opts.Scenes = strings.Split(*scenes, ",")
+	}
+
+	s, err := server.New(opts)
+	if err != nil {
+		log.Fatalf("failed to create server: %v (registered scenes: %s)", err, strings.Join(registry.Names(), ", "))
+	}
+	defer s.Close()
+
+	if *replay != "" {
+		f, err := os.Open(*replay)
+		if err != nil {
+			log.Fatalf("failed to open recording: %v", err)
+		}
+		if err := s.Replay(f); err != nil {
+			log.Fatalf("failed to replay %s: %v", *replay, err)
+		}
+		f.Close()
+		log.Printf("Replaying %s", *replay)
+	}
+
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			log.Fatalf("failed to create recording: %v", err)
+		}
+		defer f.Close()
+		if err := s.Record(f); err != nil {
+			log.Fatalf("failed to record: %v", err)
+		}
+		log.Printf("Recording input events in %s", *record)
+	}
+
+	// Profiles are served apart from the public address
+	if *pprofAddr != "" {
+		go servePprof(*pprofAddr)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/token", allowed.CORS(s.Tokens()))
+	mux.Handle("/metrics", s.Metrics())
+	if *debug {
+		mux.Handle("/debug/assets", s.DebugAssets())
+		mux.Handle("/debug/assets/", s.DebugAssets())
+	}
+
 	// Websocket server
-	http.HandleFunc("/", wsHandler(server.NewWebsocketServer()))
-	log.Printf("Websocket server listening at %v", fmt.Sprintf(":%d", *port))
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
+	mux.HandleFunc("/", wsHandler(s))
+	listen := net.JoinHostPort(*addr, strconv.Itoa(*port))
+	if *tlsCert != "" {
+		log.Printf("Websocket server listening at %v over TLS", listen)
+		err = http.ListenAndServeTLS(listen, *tlsCert, *tlsKey, mux)
+	} else {
+		log.Printf("Websocket server listening at %v", listen)
+		err = http.ListenAndServe(listen, mux)
+	}
+	if err != nil {
 		log.Fatalf("failed to serve websocket server: %v", err)
 	}
 }
 
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	log.Printf("Profiles served at http://%v/debug/pprof/", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("failed to serve profiles: %v", err)
+	}
+}
+
 func wsHandler(s *server.WebsocketServer) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		c, err := upgrader.Upgrade(w, r, nil)
@@ -41,7 +141,8 @@ func wsHandler(s *server.WebsocketServer) func(w http.ResponseWriter, r *http.Re
 		}
 		defer c.Close()
 
-		if err := s.Handle(c); err != nil {
+		// Browsers cannot set headers of websocket requests
+		if err := s.Handle(c, r.URL.Query().Get("token")); err != nil {
 			log.Printf("%v", err)
 			return
 		}
@@ -1,9 +1,12 @@
 package encoding
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
+	"slices"
 
 	"github.com/geotry/stago/compute"
 )
@@ -17,61 +20,105 @@ type WritableBlock interface {
 	PutUint16(v uint16) int
 	PutUint32(v uint32) int
 	PutFloat32(v float32) int
+	PutFloat64(v float64) int
 	PutVector2Float32(x, y float32) int
 	PutVector3Float32(x, y, z float32) int
 	PutMatrix(m compute.Matrix) int
 	NewArray()
 	EndArray()
+	Err() error
 }
 
+var (
+	// Returned when a block cannot be written because the buffer reached its maximum capacity
+	ErrBufferFull = errors.New("block buffer is full")
+	// Returned when a block must be relocated while another block is written in buffer
+	ErrBufferBusy = errors.New("block buffer is busy")
+)
+
 // BlockBuffer wraps a buffer to write contiguous blocks of data.
+//
+// The buffer grows on demand up to its maximum capacity. When a block does not
+// fit, writes are discarded, EndBlock() returns nil and Err() returns ErrBufferFull.
+//
+// Space of freed blocks is kept in a free-list and reused by the next blocks
+// that fit in. Compact() moves live blocks to the start of the buffer to
+// reclaim the remaining holes.
 type BlockBuffer struct {
 	buf                []byte
-	capacity           int
+	maxCapacity        int
 	offset             int
-	blocks             map[int]uint8
+	err                error
+	blocks             map[*Block]struct{}
+	free               []freeSpan
+	freeBytes          int
+	inBlock            bool
 	currentBlockOffset int
 	currentBlockSize   int
 	currentArrayOffset int
 	currentArraySize   int
 }
 
-// Block is a fixed-space in buffer that can be updated independantly
+// Block is a space in buffer that can be updated independantly.
+//
+// A block is rewritten in place. When its new content does not fit, it is
+// relocated at the end of the buffer and its previous space is freed, so
+// references to the block remain valid.
 type Block struct {
 	kind        uint8
 	startOffset int
 	offset      int
 	size        int
+	freed       bool
 	buf         *BlockBuffer
+	err         error
+	// Offset of the current array length in block, or -1
+	arrayOffset int
+	// Block is being rewritten at the end of the buffer
+	relocating bool
+	// Content changed during the last rewrite
+	changed bool
+}
+
+// A free region of the buffer, including block prefix
+type freeSpan struct {
+	offset int
+	size   int
 }
 
 // 1 uint8 for type
 // 1 uint32 for byte size
 const BlockPrefixBytes = 5
 
+// Create a buffer with an initial capacity and no maximum capacity
 func NewBlockBuffer(cap int) *BlockBuffer {
 	return &BlockBuffer{
 		buf:                make([]byte, cap),
-		capacity:           cap,
+		maxCapacity:        0,
 		offset:             0,
-		blocks:             make(map[int]uint8),
+		blocks:             make(map[*Block]struct{}),
 		currentBlockOffset: 0,
 	}
 }
 
 func (b *BlockBuffer) PutUint8(v uint8) int {
+	if !b.grow(1) {
+		return b.offset
+	}
 	b.buf[b.offset] = v
 	b.moveOffset(1)
 	return b.offset
 }
 
 func (b *Block) PutUint8(v uint8) int {
-	b.buf.buf[b.startOffset+b.offset] = v
-	b.moveOffset(1)
+	b.write([]byte{v})
 	return b.offset
 }
 
 func (b *BlockBuffer) PutBool(v bool) int {
+	if !b.grow(1) {
+		return b.offset
+	}
 	if v {
 		b.buf[b.offset] = 1
 	} else {
@@ -83,51 +130,76 @@ func (b *BlockBuffer) PutBool(v bool) int {
 
 func (b *Block) PutBool(v bool) int {
 	if v {
-		b.buf.buf[b.startOffset+b.offset] = 1
-	} else {
-		b.buf.buf[b.startOffset+b.offset] = 0
+		return b.PutUint8(1)
 	}
-	b.moveOffset(1)
-	return b.offset
+	return b.PutUint8(0)
 }
 
 func (b *BlockBuffer) PutUint16(v uint16) int {
+	if !b.grow(2) {
+		return b.offset
+	}
 	binary.BigEndian.PutUint16(b.buf[b.offset:], v)
 	b.moveOffset(2)
 	return b.offset
 }
 
 func (b *Block) PutUint16(v uint16) int {
-	binary.BigEndian.PutUint16(b.buf.buf[b.startOffset+b.offset:], v)
-	b.moveOffset(2)
+	var p [2]byte
+	binary.BigEndian.PutUint16(p[:], v)
+	b.write(p[:])
 	return b.offset
 }
 
 func (b *BlockBuffer) PutUint32(v uint32) int {
+	if !b.grow(4) {
+		return b.offset
+	}
 	binary.BigEndian.PutUint32(b.buf[b.offset:], v)
 	b.moveOffset(4)
 	return b.offset
 }
 
 func (b *Block) PutUint32(v uint32) int {
-	binary.BigEndian.PutUint32(b.buf.buf[b.startOffset+b.offset:], v)
-	b.moveOffset(4)
+	var p [4]byte
+	binary.BigEndian.PutUint32(p[:], v)
+	b.write(p[:])
 	return b.offset
 }
 
 func (b *BlockBuffer) PutFloat32(v float32) int {
+	if !b.grow(4) {
+		return b.offset
+	}
 	binary.BigEndian.PutUint32(b.buf[b.offset:], math.Float32bits(v))
 	b.moveOffset(4)
 	return b.offset
 }
 
 func (b *Block) PutFloat32(v float32) int {
-	binary.BigEndian.PutUint32(b.buf.buf[b.startOffset+b.offset:], math.Float32bits(v))
-	b.moveOffset(4)
+	return b.PutUint32(math.Float32bits(v))
+}
+
+func (b *BlockBuffer) PutFloat64(v float64) int {
+	if !b.grow(8) {
+		return b.offset
+	}
+	binary.BigEndian.PutUint64(b.buf[b.offset:], math.Float64bits(v))
+	b.moveOffset(8)
+	return b.offset
+}
+
+func (b *Block) PutFloat64(v float64) int {
+	var p [8]byte
+	binary.BigEndian.PutUint64(p[:], math.Float64bits(v))
+	b.write(p[:])
 	return b.offset
 }
 
 func (b *BlockBuffer) PutVector2Float32(x, y float32) int {
+	if !b.grow(8) {
+		return b.offset
+	}
 	binary.BigEndian.PutUint32(b.buf[b.offset:], math.Float32bits(x))
 	binary.BigEndian.PutUint32(b.buf[b.offset+4:], math.Float32bits(y))
 	b.moveOffset(8)
@@ -135,13 +207,14 @@ func (b *BlockBuffer) PutVector2Float32(x, y float32) int {
 }
 
 func (b *Block) PutVector2Float32(x, y float32) int {
-	binary.BigEndian.PutUint32(b.buf.buf[b.startOffset+b.offset:], math.Float32bits(x))
-	binary.BigEndian.PutUint32(b.buf.buf[b.startOffset+b.offset+4:], math.Float32bits(y))
-	b.moveOffset(8)
-	return b.offset
+	b.PutFloat32(x)
+	return b.PutFloat32(y)
 }
 
 func (b *BlockBuffer) PutVector3Float32(x, y, z float32) int {
+	if !b.grow(12) {
+		return b.offset
+	}
 	binary.BigEndian.PutUint32(b.buf[b.offset:], math.Float32bits(x))
 	binary.BigEndian.PutUint32(b.buf[b.offset+4:], math.Float32bits(y))
 	binary.BigEndian.PutUint32(b.buf[b.offset+8:], math.Float32bits(z))
@@ -150,11 +223,9 @@ func (b *BlockBuffer) PutVector3Float32(x, y, z float32) int {
 }
 
 func (b *Block) PutVector3Float32(x, y, z float32) int {
-	binary.BigEndian.PutUint32(b.buf.buf[b.startOffset+b.offset:], math.Float32bits(x))
-	binary.BigEndian.PutUint32(b.buf.buf[b.startOffset+b.offset+4:], math.Float32bits(y))
-	binary.BigEndian.PutUint32(b.buf.buf[b.startOffset+b.offset+8:], math.Float32bits(z))
-	b.moveOffset(12)
-	return b.offset
+	b.PutFloat32(x)
+	b.PutFloat32(y)
+	return b.PutFloat32(z)
 }
 
 func (b *BlockBuffer) NewArray() {
@@ -165,13 +236,17 @@ func (b *BlockBuffer) NewArray() {
 }
 
 func (b *Block) NewArray() {
-	// Does nothing, skip offset
-	// Blocks cannot modify size of arrays
-	b.moveOffset(4)
+	b.EndArray()
+	offset := b.offset
+	// Length is written and compared by EndArray()
+	if b.next(4) == nil {
+		return
+	}
+	b.arrayOffset = offset
 }
 
 func (b *BlockBuffer) EndArray() {
-	if b.currentArrayOffset > 0 {
+	if b.currentArrayOffset > 0 && b.err == nil {
 		binary.BigEndian.PutUint32(b.buf[b.currentArrayOffset:], uint32(b.currentArraySize))
 	}
 	b.currentArrayOffset = 0
@@ -179,12 +254,21 @@ func (b *BlockBuffer) EndArray() {
 }
 
 func (b *Block) EndArray() {
-	// Does nothing, skip offset
-	// Blocks cannot modify size of arrays
+	if b.arrayOffset >= 0 && b.err == nil {
+		var p [4]byte
+		binary.BigEndian.PutUint32(p[:], uint32(b.offset-b.arrayOffset-4))
+		dst := b.buf.buf[b.writeOffset()+b.arrayOffset:][:4]
+		b.changed = b.changed || !bytes.Equal(dst, p[:])
+		copy(dst, p[:])
+	}
+	b.arrayOffset = -1
 }
 
 func (b *BlockBuffer) PutMatrix(m compute.Matrix) int {
 	b.NewArray()
+	if !b.grow(4 * len(m)) {
+		return b.offset
+	}
 	for i := range m {
 		binary.BigEndian.PutUint32(b.buf[b.offset:], math.Float32bits(float32(m[i])))
 		b.moveOffset(4)
@@ -196,8 +280,7 @@ func (b *BlockBuffer) PutMatrix(m compute.Matrix) int {
 func (b *Block) PutMatrix(m compute.Matrix) int {
 	b.NewArray()
 	for i := range m {
-		binary.BigEndian.PutUint32(b.buf.buf[b.startOffset+b.offset:], math.Float32bits(float32(m[i])))
-		b.moveOffset(4)
+		b.PutFloat32(float32(m[i]))
 	}
 	b.EndArray()
 	return b.offset
@@ -205,11 +288,16 @@ func (b *Block) PutMatrix(m compute.Matrix) int {
 
 func (b *BlockBuffer) Reset() {
 	b.offset = 0
+	b.err = nil
+	b.free = nil
+	b.freeBytes = 0
+	b.inBlock = false
 	b.currentBlockOffset = 0
 	b.currentBlockSize = 0
 	b.currentArrayOffset = 0
 	b.currentArraySize = 0
 	for k := range b.blocks {
+		k.freed = true
 		delete(b.blocks, k)
 	}
 }
@@ -218,37 +306,69 @@ func (b *Block) Reset() {
 	b.offset = 0
 }
 
+// Copy the blocks written in buf and return the number of bytes copied,
+// less than Offset() if buf is too small
 func (b *BlockBuffer) Copy(buf []byte) int {
-	copy(buf, b.buf[0:b.offset])
-	return b.offset
+	return copy(buf, b.buf[0:b.offset])
 }
 
+// Copy the block in buf and return the number of bytes copied, less than
+// the size of the block if buf is too small
 func (b *Block) Copy(buf []byte) int {
-	copy(buf, b.buf.buf[b.startOffset-BlockPrefixBytes:b.startOffset+b.size])
-	return b.size
+	if b.freed {
+		return 0
+	}
+	start := b.startOffset - BlockPrefixBytes
+	return copy(buf, b.buf.buf[start:start+b.size])
 }
 
+// Release the space of the block in buffer. The block must not be used after.
 func (b *Block) Free() {
-	// Todo: instead shift all blocks after this block in the buffer
-	b.Reset()
-	for range b.size {
-		b.PutUint8(0)
+	if b.freed {
+		return
 	}
-	b.Reset()
+	b.freed = true
+	b.offset = 0
+	b.buf.release(b)
+}
+
+func (b *Block) IsFreed() bool {
+	return b.freed
 }
 
 func (b *BlockBuffer) Offset() int {
 	return b.offset
 }
 
+// Current size of the underlying buffer
 func (b *BlockBuffer) Capacity() int {
-	return b.capacity
+	return len(b.buf)
+}
+
+// Maximum size the buffer can grow to, 0 if unlimited
+func (b *BlockBuffer) MaxCapacity() int {
+	return b.maxCapacity
+}
+
+func (b *BlockBuffer) SetMaxCapacity(max int) {
+	b.maxCapacity = max
+}
+
+// Error of the block being written, if any
+func (b *BlockBuffer) Err() error {
+	return b.err
+}
+
+// Number of bytes held by freed blocks and not yet reclaimed
+func (b *BlockBuffer) FreeBytes() int {
+	return b.freeBytes
 }
 
 // Declare a new block in buffer
 func (b *BlockBuffer) NewBlock(kind uint8) int {
 	b.EndArray()
-	b.blocks[b.offset] = kind
+	b.err = nil
+	b.inBlock = true
 	b.currentBlockOffset = b.offset
 	b.currentBlockSize = 0
 	b.PutUint8(kind)
@@ -256,13 +376,26 @@ func (b *BlockBuffer) NewBlock(kind uint8) int {
 	return b.offset
 }
 
+// Start rewriting the block. Its size may change, see EndBlock().
 func (b *Block) NewBlock(kind uint8) int {
 	b.offset = 0
+	b.err = nil
+	b.arrayOffset = -1
+	b.relocating = false
+	b.changed = false
+	if b.freed {
+		b.err = fmt.Errorf("write freed block %d", b.kind)
+		return b.offset
+	}
+	b.changed = kind != b.kind
+	b.kind = kind
+	b.buf.buf[b.startOffset-BlockPrefixBytes] = kind
 	return b.offset
 }
 
 // End current block and returns a reference to it.
-// The block can be updated but its size cannot change.
+// The block can be rewritten with a different size, see Block.EndBlock().
+// Returns nil if the block could not be written, see Err().
 func (b *BlockBuffer) EndBlock() *Block {
 	b.EndArray()
 
@@ -270,24 +403,92 @@ func (b *BlockBuffer) EndBlock() *Block {
 	blockSize := b.currentBlockSize
 	b.currentBlockOffset = 0
 	b.currentBlockSize = 0
+	b.inBlock = false
+
+	// Discard partially written block
+	if b.err != nil {
+		clear(b.buf[blockOffset:b.offset])
+		b.offset = blockOffset
+		b.trimTail()
+		return nil
+	}
 
 	binary.BigEndian.PutUint32(b.buf[blockOffset+1:], uint32(blockSize))
 
-	return &Block{
+	// Move block in the first free space large enough to hold it
+	if i := b.findFree(blockSize); i != -1 {
+		blockOffset = b.moveTailBlock(blockOffset, blockSize, i)
+	}
+
+	block := &Block{
 		kind:        b.buf[blockOffset],
 		offset:      0,
 		startOffset: blockOffset + BlockPrefixBytes,
 		size:        blockSize,
 		buf:         b,
+		arrayOffset: -1,
+		changed:     true,
 	}
+	b.blocks[block] = struct{}{}
+
+	return block
+}
+
+func (b *Block) Err() error {
+	return b.err
 }
 
+// End the rewrite of the block and returns it.
+//
+// A block that shrinks releases its remaining space, a block that grows
+// takes the space written at the end of the buffer. If the block could not be
+// written, it is freed and nil is returned, see Err().
 func (b *Block) EndBlock() *Block {
-	b.offset = 0
+	b.EndArray()
+	defer func() {
+		b.offset = 0
+		b.relocating = false
+	}()
+
+	buf := b.buf
+
+	if b.relocating {
+		moved := buf.EndBlock()
+		if moved == nil {
+			b.err = buf.Err()
+		} else {
+			delete(buf.blocks, moved)
+			buf.release(b)
+			b.startOffset = moved.startOffset
+			b.size = moved.size
+			buf.blocks[b] = struct{}{}
+			return b
+		}
+	}
+
+	if b.err != nil {
+		if !b.freed {
+			b.Free()
+		}
+		return nil
+	}
+
+	// Release the space left by a smaller content
+	if size := BlockPrefixBytes + b.offset; size < b.size {
+		start := b.startOffset - BlockPrefixBytes
+		binary.BigEndian.PutUint32(buf.buf[start+1:], uint32(size))
+		buf.releaseSpan(start+size, b.size-size)
+		b.size = size
+		b.changed = true
+	}
+
 	return b
 }
 
 func (b *BlockBuffer) AppendBlock(block *Block) {
+	if !b.grow(block.Size()) {
+		return
+	}
 	b.offset += block.Copy(b.buf[b.offset:])
 }
 
@@ -299,10 +500,154 @@ func (b *BlockBuffer) BlockCount() int {
 	return len(b.blocks)
 }
 
+// Returns true if the content of the block changed during its last rewrite
+func (b *Block) Changed() bool {
+	return b.changed
+}
+
 func (b *Block) Size() int {
 	return b.size
 }
 
+func (b *Block) Kind() uint8 {
+	return b.kind
+}
+
+// Move all blocks at the start of the buffer to reclaim space of freed blocks.
+// References to blocks remain valid. Returns the number of bytes reclaimed.
+func (b *BlockBuffer) Compact() int {
+	if b.inBlock || b.freeBytes == 0 {
+		return 0
+	}
+
+	blocks := make([]*Block, 0, len(b.blocks))
+	for block := range b.blocks {
+		blocks = append(blocks, block)
+	}
+	slices.SortFunc(blocks, func(x, y *Block) int { return x.startOffset - y.startOffset })
+
+	offset := 0
+	for _, block := range blocks {
+		start := block.startOffset - BlockPrefixBytes
+		size := block.size
+		if start != offset {
+			copy(b.buf[offset:], b.buf[start:start+size])
+			block.startOffset = offset + BlockPrefixBytes
+		}
+		offset += size
+	}
+
+	reclaimed := b.offset - offset
+	clear(b.buf[offset:b.offset])
+	b.offset = offset
+	b.free = nil
+	b.freeBytes = 0
+
+	return reclaimed
+}
+
+// Ensure n bytes can be written at offset, growing the buffer if needed.
+// Returns false if the buffer cannot grow enough.
+func (b *BlockBuffer) grow(n int) bool {
+	if b.err != nil {
+		return false
+	}
+	if b.offset+n <= len(b.buf) {
+		return true
+	}
+
+	size := max(2*len(b.buf), b.offset+n)
+	if b.maxCapacity > 0 {
+		size = min(size, b.maxCapacity)
+	}
+	if b.offset+n > size {
+		b.err = ErrBufferFull
+		return false
+	}
+
+	buf := make([]byte, size)
+	copy(buf, b.buf[:b.offset])
+	b.buf = buf
+
+	return true
+}
+
+// Remove block from buffer and add its space to the free-list
+func (b *BlockBuffer) release(block *Block) {
+	delete(b.blocks, block)
+	b.releaseSpan(block.startOffset-BlockPrefixBytes, block.size)
+}
+
+// Add the region of size bytes at start to the free-list
+func (b *BlockBuffer) releaseSpan(start int, size int) {
+	clear(b.buf[start : start+size])
+
+	// Block is at the end of buffer, move offset back
+	if start+size == b.offset && !b.inBlock {
+		b.offset = start
+		b.trimTail()
+		return
+	}
+
+	b.freeBytes += size
+
+	// Insert span sorted by offset and merge it with adjacent spans
+	i, _ := slices.BinarySearchFunc(b.free, start, func(s freeSpan, offset int) int { return s.offset - offset })
+	b.free = slices.Insert(b.free, i, freeSpan{offset: start, size: size})
+	if i+1 < len(b.free) && b.free[i].offset+b.free[i].size == b.free[i+1].offset {
+		b.free[i].size += b.free[i+1].size
+		b.free = slices.Delete(b.free, i+1, i+2)
+	}
+	if i > 0 && b.free[i-1].offset+b.free[i-1].size == b.free[i].offset {
+		b.free[i-1].size += b.free[i].size
+		b.free = slices.Delete(b.free, i, i+1)
+	}
+}
+
+// Return index of the first free span that can hold size bytes, or -1
+func (b *BlockBuffer) findFree(size int) int {
+	for i, s := range b.free {
+		if s.size >= size {
+			return i
+		}
+	}
+	return -1
+}
+
+// Move the block written at the end of buffer into free span i,
+// and returns its new offset
+func (b *BlockBuffer) moveTailBlock(offset int, size int, i int) int {
+	span := &b.free[i]
+	dst := span.offset
+
+	copy(b.buf[dst:], b.buf[offset:offset+size])
+	clear(b.buf[offset : offset+size])
+	b.offset = offset
+
+	span.offset += size
+	span.size -= size
+	b.freeBytes -= size
+	if span.size == 0 {
+		b.free = slices.Delete(b.free, i, i+1)
+	}
+	b.trimTail()
+
+	return dst
+}
+
+// Remove free spans located at the end of buffer
+func (b *BlockBuffer) trimTail() {
+	for len(b.free) > 0 {
+		last := b.free[len(b.free)-1]
+		if last.offset+last.size != b.offset {
+			break
+		}
+		b.offset = last.offset
+		b.freeBytes -= last.size
+		b.free = b.free[:len(b.free)-1]
+	}
+}
+
 func (b *Block) Uint8At(offset int) uint8 {
 	return b.buf.buf[b.startOffset+offset]
 }
@@ -358,3 +703,62 @@ func (b *BlockBuffer) moveOffset(amount int) {
 func (b *Block) moveOffset(amount int) {
 	b.offset += amount
 }
+
+// Offset in buffer where the content of the block is written
+func (b *Block) writeOffset() int {
+	if b.relocating {
+		return b.buf.currentBlockOffset + BlockPrefixBytes
+	}
+	return b.startOffset
+}
+
+// Reserve n bytes in block and returns the slice to write them,
+// or nil if the block cannot hold them.
+func (b *Block) next(n int) []byte {
+	if b.err != nil {
+		return nil
+	}
+	if !b.relocating && BlockPrefixBytes+b.offset+n > b.size {
+		b.relocate()
+	}
+	if b.relocating {
+		if !b.buf.grow(n) {
+			b.err = b.buf.Err()
+			return nil
+		}
+		b.buf.moveOffset(n)
+	}
+	p := b.buf.buf[b.writeOffset()+b.offset:][:n]
+	b.offset += n
+	return p
+}
+
+// Copy the content written so far at the end of buffer,
+// where the block continues to be written
+func (b *Block) relocate() {
+	buf := b.buf
+	if buf.inBlock {
+		b.err = ErrBufferBusy
+		return
+	}
+	buf.NewBlock(b.kind)
+	if !buf.grow(b.offset) {
+		b.err = buf.Err()
+		buf.EndBlock()
+		return
+	}
+	copy(buf.buf[buf.offset:], buf.buf[b.startOffset:b.startOffset+b.offset])
+	buf.moveOffset(b.offset)
+	b.relocating = true
+	b.changed = true
+}
+
+// Write p in block and keep track of changes
+func (b *Block) write(p []byte) {
+	dst := b.next(len(p))
+	if dst == nil {
+		return
+	}
+	b.changed = b.changed || !bytes.Equal(dst, p)
+	copy(dst, p)
+}
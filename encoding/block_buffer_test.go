@@ -1,6 +1,7 @@
 package encoding
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -127,3 +128,294 @@ func TestUpdateBlock(t *testing.T) {
 		}
 	}
 }
+
+func TestFreeBlock(t *testing.T) {
+	buf := NewBlockBuffer(255)
+
+	buf.NewBlock(1)
+	buf.PutUint32(1)
+	a := buf.EndBlock()
+
+	buf.NewBlock(2)
+	buf.PutUint32(2)
+	buf.EndBlock()
+
+	a.Free()
+
+	if buf.FreeBytes() != a.Size() {
+		t.Errorf("expected %v free bytes, got %v", a.Size(), buf.FreeBytes())
+	}
+
+	// New block fits in space of the freed block
+	offset := buf.Offset()
+	buf.NewBlock(3)
+	buf.PutUint16(3)
+	c := buf.EndBlock()
+
+	if c.startOffset != BlockPrefixBytes {
+		t.Errorf("expected block to reuse freed space at %v, got %v", BlockPrefixBytes, c.startOffset)
+	}
+	if buf.Offset() != offset {
+		t.Errorf("expected buffer offset to be %v, got %v", offset, buf.Offset())
+	}
+	if buf.FreeBytes() != 2 {
+		t.Errorf("expected 2 free bytes, got %v", buf.FreeBytes())
+	}
+	if buf.BlockCount() != 2 {
+		t.Errorf("expected 2 blocks, got %v", buf.BlockCount())
+	}
+
+	// Freeing last block moves offset back
+	c.Free()
+	buf.NewBlock(4)
+	d := buf.EndBlock()
+	d.Free()
+	if buf.Offset() != offset {
+		t.Errorf("expected buffer offset to be %v, got %v", offset, buf.Offset())
+	}
+}
+
+func TestCompact(t *testing.T) {
+	buf := NewBlockBuffer(255)
+
+	blocks := make([]*Block, 4)
+	for i := range blocks {
+		buf.NewBlock(uint8(i))
+		buf.PutUint8(uint8(i))
+		buf.PutUint16(uint16(i * 100))
+		blocks[i] = buf.EndBlock()
+	}
+
+	blocks[0].Free()
+	blocks[2].Free()
+
+	blockSize := BlockPrefixBytes + 3
+
+	reclaimed := buf.Compact()
+	if reclaimed != 2*blockSize {
+		t.Errorf("expected %v bytes reclaimed, got %v", 2*blockSize, reclaimed)
+	}
+	if buf.Offset() != 2*blockSize {
+		t.Errorf("expected buffer offset to be %v, got %v", 2*blockSize, buf.Offset())
+	}
+	if buf.FreeBytes() != 0 {
+		t.Errorf("expected no free bytes, got %v", buf.FreeBytes())
+	}
+
+	for _, i := range []int{1, 3} {
+		if blocks[i].Uint8At(0) != uint8(i) || blocks[i].Uint16At(1) != uint16(i*100) {
+			t.Errorf("expected block %v to keep its content after compaction, got %v", i, blocks[i])
+		}
+	}
+
+	tmp := make([]byte, buf.Offset())
+	n := blocks[1].Copy(tmp)
+	blocks[3].Copy(tmp[n:])
+	expected := []byte{1, 0, 0, 0, 8, 1, 0, 100, 3, 0, 0, 0, 8, 3, 1, 44}
+	for i := range tmp {
+		if tmp[i] != expected[i] {
+			t.Errorf("expected byte at index %v to be %v, got %v (%v)", i, expected[i], tmp[i], tmp)
+		}
+	}
+}
+
+func TestGrow(t *testing.T) {
+	buf := NewBlockBuffer(8)
+
+	buf.NewBlock(1)
+	buf.PutUint32(1)
+	a := buf.EndBlock()
+
+	buf.NewBlock(2)
+	buf.PutMatrix(compute.NewMatrix4().Out)
+	b := buf.EndBlock()
+
+	if b == nil {
+		t.Fatalf("expected block to be written, got error %v", buf.Err())
+	}
+	if buf.Capacity() < buf.Offset() {
+		t.Errorf("expected buffer to grow to at least %v, got %v", buf.Offset(), buf.Capacity())
+	}
+	if a.Uint32At(0) != 1 {
+		t.Errorf("expected block to be valid after growth, got %v", a.Uint32At(0))
+	}
+}
+
+func TestBufferFull(t *testing.T) {
+	buf := NewBlockBuffer(8)
+	buf.SetMaxCapacity(16)
+
+	buf.NewBlock(1)
+	buf.PutUint32(1)
+	buf.EndBlock()
+	offset := buf.Offset()
+
+	buf.NewBlock(2)
+	buf.PutMatrix(compute.NewMatrix4().Out)
+	block := buf.EndBlock()
+
+	if block != nil {
+		t.Errorf("expected block to not be written")
+	}
+	if !errors.Is(buf.Err(), ErrBufferFull) {
+		t.Errorf("expected error to be %v, got %v", ErrBufferFull, buf.Err())
+	}
+	if buf.Offset() != offset {
+		t.Errorf("expected buffer offset to be %v, got %v", offset, buf.Offset())
+	}
+	if buf.Capacity() > 16 {
+		t.Errorf("expected buffer capacity to not exceed 16, got %v", buf.Capacity())
+	}
+
+	// Next block that fits can be written
+	buf.NewBlock(3)
+	buf.PutUint8(3)
+	if buf.EndBlock() == nil {
+		t.Errorf("expected block to be written, got error %v", buf.Err())
+	}
+}
+
+func TestResizeBlock(t *testing.T) {
+	buf := NewBlockBuffer(255)
+
+	textures := make([]*Block, 3)
+	for i := range textures {
+		texture := &Texture{Id: uint8(i), Width: 1, Height: 1, Depth: 1, Pixels: []uint8{uint8(i)}}
+		texture.Write(buf)
+		textures[i] = buf.EndBlock()
+	}
+	size := textures[0].Size()
+
+	// Grow first texture, it is moved at the end of buffer
+	grown := &Texture{Id: 0, Width: 1, Height: 1, Depth: 3, Pixels: []uint8{1, 2, 3}}
+	grown.Write(textures[0])
+	if textures[0].EndBlock() == nil {
+		t.Fatalf("expected block to be resized, got error %v", textures[0].Err())
+	}
+	if textures[0].Size() != size+2 {
+		t.Errorf("expected block size to be %v, got %v", size+2, textures[0].Size())
+	}
+	if buf.FreeBytes() != size {
+		t.Errorf("expected %v free bytes, got %v", size, buf.FreeBytes())
+	}
+
+	// Shrink second texture
+	shrunk := &Texture{Id: 1, Width: 1, Height: 1}
+	shrunk.Write(textures[1])
+	textures[1].EndBlock()
+	if textures[1].Size() != size-1 {
+		t.Errorf("expected block size to be %v, got %v", size-1, textures[1].Size())
+	}
+	if buf.FreeBytes() != size+1 {
+		t.Errorf("expected %v free bytes, got %v", size+1, buf.FreeBytes())
+	}
+
+	buf.Compact()
+	if buf.BlockCount() != 3 {
+		t.Errorf("expected 3 blocks, got %v", buf.BlockCount())
+	}
+
+	frame := make([]byte, buf.Offset())
+	buf.Copy(frame)
+
+	decoded := make(map[uint8]*Texture)
+	r := NewBlockReader(frame)
+	for r.Next() {
+		texture := r.Block().(*Texture)
+		decoded[texture.Id] = texture
+	}
+	if r.Err() != nil {
+		t.Fatalf("expected no error, got %v", r.Err())
+	}
+	if texture := decoded[0]; texture == nil || texture.Depth != 3 || len(texture.Pixels) != 3 || texture.Pixels[2] != 3 {
+		t.Errorf("expected grown texture, got %+v", texture)
+	}
+	if texture := decoded[1]; texture == nil || len(texture.Pixels) != 0 {
+		t.Errorf("expected shrunk texture, got %+v", texture)
+	}
+	if texture := decoded[2]; texture == nil || len(texture.Pixels) != 1 || texture.Pixels[0] != 2 {
+		t.Errorf("expected unchanged texture, got %+v", texture)
+	}
+}
+
+func TestResizeBlockBufferFull(t *testing.T) {
+	buf := NewBlockBuffer(16)
+	buf.SetMaxCapacity(16)
+
+	buf.NewBlock(0)
+	buf.PutUint8(1)
+	block := buf.EndBlock()
+
+	block.NewBlock(0)
+	for range 16 {
+		block.PutUint8(1)
+	}
+	if block.EndBlock() != nil {
+		t.Errorf("expected block to not be resized")
+	}
+	if !errors.Is(block.Err(), ErrBufferFull) {
+		t.Errorf("expected error to be %v, got %v", ErrBufferFull, block.Err())
+	}
+	if !block.IsFreed() || buf.Offset() != 0 {
+		t.Errorf("expected block to be freed, got offset %v", buf.Offset())
+	}
+}
+
+func TestBlockChanged(t *testing.T) {
+	buf := NewBlockBuffer(255)
+
+	light := &Light{Id: 1, PosX: 10}
+	light.Write(buf)
+	block := buf.EndBlock()
+	if !block.Changed() {
+		t.Errorf("expected new block to be changed")
+	}
+
+	light.Write(block)
+	block.EndBlock()
+	if block.Changed() {
+		t.Errorf("expected block to not be changed when rewritten with the same content")
+	}
+
+	light.PosX = 20
+	light.Write(block)
+	block.EndBlock()
+	if !block.Changed() {
+		t.Errorf("expected block to be changed")
+	}
+
+	texture := &Texture{Id: 1, Width: 1, Height: 1, Depth: 1, Pixels: []uint8{1}}
+	texture.Write(buf)
+	block = buf.EndBlock()
+	texture.Write(block)
+	block.EndBlock()
+	if block.Changed() {
+		t.Errorf("expected block with array to not be changed when rewritten with the same content")
+	}
+	texture.Pixels = []uint8{}
+	texture.Depth = 0
+	texture.Write(block)
+	block.EndBlock()
+	if !block.Changed() {
+		t.Errorf("expected resized block to be changed")
+	}
+}
+
+func TestCopyTruncated(t *testing.T) {
+	buf := NewBlockBuffer(255)
+
+	light := &Light{Id: 1, PosX: 10}
+	light.Write(buf)
+	block := buf.EndBlock()
+
+	out := make([]byte, block.size)
+	if n := block.Copy(out); n != block.size {
+		t.Errorf("expected %v bytes copied, got %v", block.size, n)
+	}
+	if n := block.Copy(out[:4]); n != 4 {
+		t.Errorf("expected copy to be truncated to 4 bytes, got %v", n)
+	}
+	if n := buf.Copy(out[:4]); n != 4 {
+		t.Errorf("expected buffer copy to be truncated to 4 bytes, got %v", n)
+	}
+}
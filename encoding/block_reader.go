@@ -0,0 +1,225 @@
+package encoding
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/geotry/stago/compute"
+)
+
+var (
+	ErrUnknownBlock    = errors.New("unknown block type")
+	ErrMalformedBlock  = errors.New("malformed block")
+	ErrVersionMismatch = errors.New("protocol version mismatch")
+)
+
+// BlockReader decodes blocks from a frame written with a BlockBuffer.
+//
+//	r := NewBlockReader(frame)
+//	for r.Next() {
+//		switch b := r.Block().(type) {
+//		case *Camera:
+//		}
+//	}
+//	if err := r.Err(); err != nil {}
+type BlockReader struct {
+	buf    []byte
+	offset int
+	kind   BlockType
+	block  any
+	header *FrameHeader
+	err    error
+}
+
+func NewBlockReader(buf []byte) *BlockReader {
+	return &BlockReader{buf: buf}
+}
+
+// Decode the next block. Returns false at the end of frame or on error.
+func (r *BlockReader) Next() bool {
+	r.block = nil
+	if r.err != nil || r.offset >= len(r.buf) {
+		return false
+	}
+
+	offset := r.offset
+	if len(r.buf)-offset < BlockPrefixBytes {
+		r.err = fmt.Errorf("block prefix at offset %d: %w", offset, ErrMalformedBlock)
+		return false
+	}
+
+	kind := BlockType(r.buf[offset])
+	size := int(binary.BigEndian.Uint32(r.buf[offset+1:]))
+	if size < BlockPrefixBytes || size > len(r.buf)-offset {
+		r.err = fmt.Errorf("block %d at offset %d has invalid size %d: %w", kind, offset, size, ErrMalformedBlock)
+		return false
+	}
+
+	d := &blockDecoder{buf: r.buf[offset+BlockPrefixBytes : offset+size]}
+
+	block := newBlock(kind)
+	if block == nil {
+		r.err = fmt.Errorf("block %d at offset %d: %w", kind, offset, ErrUnknownBlock)
+		return false
+	}
+	block.decode(d)
+
+	if v, ok := block.(interface{ validate() error }); ok && d.err == nil {
+		d.err = v.validate()
+	}
+	if d.err == nil && d.offset != len(d.buf) {
+		d.err = fmt.Errorf("%d trailing bytes", len(d.buf)-d.offset)
+	}
+	if d.err != nil {
+		r.err = fmt.Errorf("block %d at offset %d: %w: %v", kind, offset, ErrMalformedBlock, d.err)
+		return false
+	}
+
+	if header, ok := block.(*FrameHeader); ok {
+		if header.Version != ProtocolVersion {
+			r.err = fmt.Errorf("frame at offset %d has version %d, expected %d: %w", offset, header.Version, ProtocolVersion, ErrVersionMismatch)
+			return false
+		}
+		r.header = header
+	}
+
+	r.offset += size
+	r.kind = kind
+	r.block = block
+
+	return true
+}
+
+// Return the last decoded block, as a pointer to one of the block structs
+func (r *BlockReader) Block() any {
+	return r.block
+}
+
+// Return the header of the current frame, or nil if no header was read
+func (r *BlockReader) Header() *FrameHeader {
+	return r.header
+}
+
+// Return the type of the last decoded block
+func (r *BlockReader) Kind() BlockType {
+	return r.kind
+}
+
+func (r *BlockReader) Offset() int {
+	return r.offset
+}
+
+func (r *BlockReader) Err() error {
+	return r.err
+}
+
+// Read fields of a single block
+type blockDecoder struct {
+	buf    []byte
+	offset int
+	err    error
+}
+
+func (d *blockDecoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if d.offset+n > len(d.buf) {
+		d.err = fmt.Errorf("read %d bytes at offset %d beyond block size %d", n, d.offset, len(d.buf))
+		return nil
+	}
+	b := d.buf[d.offset : d.offset+n]
+	d.offset += n
+	return b
+}
+
+func (d *blockDecoder) uint8() uint8 {
+	if b := d.read(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *blockDecoder) bool() bool {
+	return d.uint8() != 0
+}
+
+func (d *blockDecoder) uint16() uint16 {
+	if b := d.read(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *blockDecoder) uint32() uint32 {
+	if b := d.read(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *blockDecoder) float32() float32 {
+	return math.Float32frombits(d.uint32())
+}
+
+func (d *blockDecoder) float64() float64 {
+	if b := d.read(8); b != nil {
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *blockDecoder) uint8Array() []uint8 {
+	size := int(d.uint32())
+	b := d.read(size)
+	if b == nil {
+		return nil
+	}
+	return append([]uint8(nil), b...)
+}
+
+func (d *blockDecoder) float32Array() []float32 {
+	size := int(d.uint32())
+	if d.err == nil && size%4 != 0 {
+		d.err = fmt.Errorf("float32 array byte size %d is not a multiple of 4", size)
+	}
+	b := d.read(size)
+	if b == nil {
+		return nil
+	}
+	values := make([]float32, size/4)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.BigEndian.Uint32(b[i*4:]))
+	}
+	return values
+}
+
+// Read a 4x4 matrix
+func (d *blockDecoder) matrix() compute.Matrix {
+	values := d.float32Array()
+	if d.err == nil && len(values) != 16 {
+		d.err = fmt.Errorf("matrix has %d values, expected 16", len(values))
+	}
+	m := make(compute.Matrix, len(values))
+	for i, v := range values {
+		m[i] = float64(v)
+	}
+	return m
+}
+
+func (t *Texture) validate() error {
+	n := int(t.Width) * int(t.Height) * int(t.Depth)
+	if n == 0 && len(t.Pixels) > 0 || n > 0 && len(t.Pixels)%n != 0 {
+		return fmt.Errorf("texture has %d bytes, expected a multiple of %dx%dx%d", len(t.Pixels), t.Width, t.Height, t.Depth)
+	}
+	return nil
+}
+
+func (o *SceneObject) validate() error {
+	if len(o.Vertices)%3 != 0 || len(o.Normals)%3 != 0 || len(o.UV)%2 != 0 {
+		return fmt.Errorf("scene object has %d vertices, %d normals and %d uv values", len(o.Vertices), len(o.Normals), len(o.UV))
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package encoding
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/geotry/stago/compute"
+)
+
+func TestReadFrame(t *testing.T) {
+	buf := NewBlockBuffer(1024)
+
+	buf.NewBlock(uint8(CameraBlock))
+	buf.PutUint16(7)
+	buf.PutMatrix(compute.NewMatrix4().Out)
+	buf.PutMatrix(compute.NewMatrix4().Out)
+	buf.EndBlock()
+
+	buf.NewBlock(uint8(SceneObjectInstanceBlock))
+	buf.PutUint16(12)
+	buf.PutUint32(42)
+	buf.PutMatrix(compute.NewMatrix4().Out)
+	buf.PutVector3Float32(1, .5, .25)
+	buf.EndBlock()
+
+	buf.NewBlock(uint8(LightDeletedBlock))
+	buf.PutUint16(3)
+	buf.PutUint8(2)
+	buf.EndBlock()
+
+	frame := make([]byte, buf.Offset())
+	buf.Copy(frame)
+
+	r := NewBlockReader(frame)
+	blocks := make([]any, 0)
+	for r.Next() {
+		blocks = append(blocks, r.Block())
+	}
+	if err := r.Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks, got %v", len(blocks))
+	}
+
+	camera, ok := blocks[0].(*Camera)
+	if !ok || camera.Id != 7 || len(camera.ViewMatrix) != 16 || camera.ProjectionMatrix[15] != 1 {
+		t.Errorf("expected camera block with id 7, got %+v", blocks[0])
+	}
+	instance, ok := blocks[1].(*SceneObjectInstance)
+	if !ok || instance.Id != 12 || instance.ObjectId != 42 || instance.TintR != 1 || instance.TintG != .5 || instance.TintB != .25 {
+		t.Errorf("expected scene object instance block with id 12, got %+v", blocks[1])
+	}
+	deleted, ok := blocks[2].(*LightDeleted)
+	if !ok || deleted.Id != 3 || deleted.Type != 2 {
+		t.Errorf("expected light deleted block with id 3, got %+v", blocks[2])
+	}
+}
+
+func TestReadMalformedFrame(t *testing.T) {
+	buf := NewBlockBuffer(255)
+
+	// Camera block with a missing matrix
+	buf.NewBlock(uint8(CameraBlock))
+	buf.PutUint16(1)
+	buf.PutMatrix(compute.NewMatrix4().Out)
+	buf.EndBlock()
+
+	frame := make([]byte, buf.Offset())
+	buf.Copy(frame)
+
+	r := NewBlockReader(frame)
+	if r.Next() {
+		t.Errorf("expected block to not be decoded")
+	}
+	if !errors.Is(r.Err(), ErrMalformedBlock) {
+		t.Errorf("expected error to be %v, got %v", ErrMalformedBlock, r.Err())
+	}
+
+	r = NewBlockReader([]byte{255, 0, 0, 0, 5})
+	if r.Next() || !errors.Is(r.Err(), ErrUnknownBlock) {
+		t.Errorf("expected error to be %v, got %v", ErrUnknownBlock, r.Err())
+	}
+
+	r = NewBlockReader(frame[:len(frame)-1])
+	if r.Next() || !errors.Is(r.Err(), ErrMalformedBlock) {
+		t.Errorf("expected error to be %v, got %v", ErrMalformedBlock, r.Err())
+	}
+}
+
+func TestReadFrameHeader(t *testing.T) {
+	buf := NewBlockBuffer(255)
+
+	header := &FrameHeader{Version: ProtocolVersion, Tick: 120, Sequence: 3, Timestamp: 1.5e12}
+	header.Write(buf)
+	buf.EndBlock()
+
+	frame := make([]byte, buf.Offset())
+	buf.Copy(frame)
+
+	r := NewBlockReader(frame)
+	if r.Header() != nil {
+		t.Errorf("expected no header before first block")
+	}
+	if !r.Next() {
+		t.Fatalf("expected frame header, got error %v", r.Err())
+	}
+	if h := r.Header(); h == nil || *h != *header {
+		t.Errorf("expected header to be %+v, got %+v", header, h)
+	}
+
+	buf.Reset()
+	header.Version = ProtocolVersion + 1
+	header.Write(buf)
+	buf.EndBlock()
+	buf.Copy(frame)
+
+	r = NewBlockReader(frame)
+	if r.Next() || !errors.Is(r.Err(), ErrVersionMismatch) {
+		t.Errorf("expected error to be %v, got %v", ErrVersionMismatch, r.Err())
+	}
+}
@@ -0,0 +1,369 @@
+// Code generated by schemagen from schema.Blocks. DO NOT EDIT.
+
+package encoding
+
+import "github.com/geotry/stago/compute"
+
+// Hash of the binary layout of blocks, see schema.Hash()
+const SchemaHash uint32 = 0x0e096898
+
+// Version of the frame format, see schema.ProtocolVersion
+const ProtocolVersion = 1
+
+const (
+	TextureBlock                    BlockType = 0
+	CameraBlock                     BlockType = 1
+	SceneObjectBlock                BlockType = 2
+	SceneObjectInstanceBlock        BlockType = 3
+	LightBlock                      BlockType = 4
+	LightDeletedBlock               BlockType = 5
+	SceneObjectInstanceDeletedBlock BlockType = 6
+	FrameHeaderBlock                BlockType = 7
+	SceneObjectInstanceLeftBlock    BlockType = 8
+)
+
+type Texture struct {
+	Id     uint8
+	Width  uint16
+	Height uint16
+	Depth  uint8
+	Format uint8
+	Role   uint8
+	Pixels []uint8
+}
+
+func (b *Texture) BlockType() BlockType {
+	return TextureBlock
+}
+
+// Write the block in w. The block must then be ended with EndBlock().
+func (b *Texture) Write(w WritableBlock) {
+	w.NewBlock(uint8(TextureBlock))
+	w.PutUint8(b.Id)
+	w.PutUint16(b.Width)
+	w.PutUint16(b.Height)
+	w.PutUint8(b.Depth)
+	w.PutUint8(b.Format)
+	w.PutUint8(b.Role)
+	w.NewArray()
+	for _, v := range b.Pixels {
+		w.PutUint8(v)
+	}
+	w.EndArray()
+}
+
+func (b *Texture) decode(d *blockDecoder) {
+	b.Id = d.uint8()
+	b.Width = d.uint16()
+	b.Height = d.uint16()
+	b.Depth = d.uint8()
+	b.Format = d.uint8()
+	b.Role = d.uint8()
+	b.Pixels = d.uint8Array()
+}
+
+type Camera struct {
+	Id               uint16
+	ViewMatrix       compute.Matrix
+	ProjectionMatrix compute.Matrix
+}
+
+func (b *Camera) BlockType() BlockType {
+	return CameraBlock
+}
+
+// Write the block in w. The block must then be ended with EndBlock().
+func (b *Camera) Write(w WritableBlock) {
+	w.NewBlock(uint8(CameraBlock))
+	w.PutUint16(b.Id)
+	w.PutMatrix(b.ViewMatrix)
+	w.PutMatrix(b.ProjectionMatrix)
+}
+
+func (b *Camera) decode(d *blockDecoder) {
+	b.Id = d.uint16()
+	b.ViewMatrix = d.matrix()
+	b.ProjectionMatrix = d.matrix()
+}
+
+type SceneObject struct {
+	Id            uint32
+	DiffuseIndex  uint8
+	SpecularIndex uint8
+	Shininess     float32
+	Opaque        bool
+	// 0: World, 1: Screen
+	Space    uint8
+	Vertices []float32
+	UV       []float32
+	Normals  []float32
+}
+
+func (b *SceneObject) BlockType() BlockType {
+	return SceneObjectBlock
+}
+
+// Write the block in w. The block must then be ended with EndBlock().
+func (b *SceneObject) Write(w WritableBlock) {
+	w.NewBlock(uint8(SceneObjectBlock))
+	w.PutUint32(b.Id)
+	w.PutUint8(b.DiffuseIndex)
+	w.PutUint8(b.SpecularIndex)
+	w.PutFloat32(b.Shininess)
+	w.PutBool(b.Opaque)
+	w.PutUint8(b.Space)
+	w.NewArray()
+	for _, v := range b.Vertices {
+		w.PutFloat32(v)
+	}
+	w.EndArray()
+	w.NewArray()
+	for _, v := range b.UV {
+		w.PutFloat32(v)
+	}
+	w.EndArray()
+	w.NewArray()
+	for _, v := range b.Normals {
+		w.PutFloat32(v)
+	}
+	w.EndArray()
+}
+
+func (b *SceneObject) decode(d *blockDecoder) {
+	b.Id = d.uint32()
+	b.DiffuseIndex = d.uint8()
+	b.SpecularIndex = d.uint8()
+	b.Shininess = d.float32()
+	b.Opaque = d.bool()
+	b.Space = d.uint8()
+	b.Vertices = d.float32Array()
+	b.UV = d.float32Array()
+	b.Normals = d.float32Array()
+}
+
+type SceneObjectInstance struct {
+	Id       uint16
+	ObjectId uint32
+	Model    compute.Matrix
+	TintR    float32
+	TintG    float32
+	TintB    float32
+}
+
+func (b *SceneObjectInstance) BlockType() BlockType {
+	return SceneObjectInstanceBlock
+}
+
+// Write the block in w. The block must then be ended with EndBlock().
+func (b *SceneObjectInstance) Write(w WritableBlock) {
+	w.NewBlock(uint8(SceneObjectInstanceBlock))
+	w.PutUint16(b.Id)
+	w.PutUint32(b.ObjectId)
+	w.PutMatrix(b.Model)
+	w.PutFloat32(b.TintR)
+	w.PutFloat32(b.TintG)
+	w.PutFloat32(b.TintB)
+}
+
+func (b *SceneObjectInstance) decode(d *blockDecoder) {
+	b.Id = d.uint16()
+	b.ObjectId = d.uint32()
+	b.Model = d.matrix()
+	b.TintR = d.float32()
+	b.TintG = d.float32()
+	b.TintB = d.float32()
+}
+
+type Light struct {
+	Id uint16
+	// 0: directional, 1: point, 2: spot
+	Type       uint8
+	AmbientR   float32
+	AmbientG   float32
+	AmbientB   float32
+	DiffuseR   float32
+	DiffuseG   float32
+	DiffuseB   float32
+	SpecularR  float32
+	SpecularG  float32
+	SpecularB  float32
+	PosX       float32
+	PosY       float32
+	PosZ       float32
+	DirectionX float32
+	DirectionY float32
+	DirectionZ float32
+	// Radius of point lights, cut-off of spot lights
+	Radius      float32
+	OuterCutOff float32
+}
+
+func (b *Light) BlockType() BlockType {
+	return LightBlock
+}
+
+// Write the block in w. The block must then be ended with EndBlock().
+func (b *Light) Write(w WritableBlock) {
+	w.NewBlock(uint8(LightBlock))
+	w.PutUint16(b.Id)
+	w.PutUint8(b.Type)
+	w.PutFloat32(b.AmbientR)
+	w.PutFloat32(b.AmbientG)
+	w.PutFloat32(b.AmbientB)
+	w.PutFloat32(b.DiffuseR)
+	w.PutFloat32(b.DiffuseG)
+	w.PutFloat32(b.DiffuseB)
+	w.PutFloat32(b.SpecularR)
+	w.PutFloat32(b.SpecularG)
+	w.PutFloat32(b.SpecularB)
+	w.PutFloat32(b.PosX)
+	w.PutFloat32(b.PosY)
+	w.PutFloat32(b.PosZ)
+	w.PutFloat32(b.DirectionX)
+	w.PutFloat32(b.DirectionY)
+	w.PutFloat32(b.DirectionZ)
+	w.PutFloat32(b.Radius)
+	w.PutFloat32(b.OuterCutOff)
+}
+
+func (b *Light) decode(d *blockDecoder) {
+	b.Id = d.uint16()
+	b.Type = d.uint8()
+	b.AmbientR = d.float32()
+	b.AmbientG = d.float32()
+	b.AmbientB = d.float32()
+	b.DiffuseR = d.float32()
+	b.DiffuseG = d.float32()
+	b.DiffuseB = d.float32()
+	b.SpecularR = d.float32()
+	b.SpecularG = d.float32()
+	b.SpecularB = d.float32()
+	b.PosX = d.float32()
+	b.PosY = d.float32()
+	b.PosZ = d.float32()
+	b.DirectionX = d.float32()
+	b.DirectionY = d.float32()
+	b.DirectionZ = d.float32()
+	b.Radius = d.float32()
+	b.OuterCutOff = d.float32()
+}
+
+type LightDeleted struct {
+	Id   uint16
+	Type uint8
+}
+
+func (b *LightDeleted) BlockType() BlockType {
+	return LightDeletedBlock
+}
+
+// Write the block in w. The block must then be ended with EndBlock().
+func (b *LightDeleted) Write(w WritableBlock) {
+	w.NewBlock(uint8(LightDeletedBlock))
+	w.PutUint16(b.Id)
+	w.PutUint8(b.Type)
+}
+
+func (b *LightDeleted) decode(d *blockDecoder) {
+	b.Id = d.uint16()
+	b.Type = d.uint8()
+}
+
+type SceneObjectInstanceDeleted struct {
+	Id       uint16
+	ObjectId uint32
+}
+
+func (b *SceneObjectInstanceDeleted) BlockType() BlockType {
+	return SceneObjectInstanceDeletedBlock
+}
+
+// Write the block in w. The block must then be ended with EndBlock().
+func (b *SceneObjectInstanceDeleted) Write(w WritableBlock) {
+	w.NewBlock(uint8(SceneObjectInstanceDeletedBlock))
+	w.PutUint16(b.Id)
+	w.PutUint32(b.ObjectId)
+}
+
+func (b *SceneObjectInstanceDeleted) decode(d *blockDecoder) {
+	b.Id = d.uint16()
+	b.ObjectId = d.uint32()
+}
+
+type FrameHeader struct {
+	Version uint16
+	// Tick of the simulation when the frame was rendered
+	Tick uint32
+	// Sequence number of the frame in the session, starting at 1
+	Sequence uint32
+	// Server time in milliseconds since epoch
+	Timestamp float64
+	// Frame contains all blocks, previous state of the client must be discarded
+	Keyframe bool
+}
+
+func (b *FrameHeader) BlockType() BlockType {
+	return FrameHeaderBlock
+}
+
+// Write the block in w. The block must then be ended with EndBlock().
+func (b *FrameHeader) Write(w WritableBlock) {
+	w.NewBlock(uint8(FrameHeaderBlock))
+	w.PutUint16(b.Version)
+	w.PutUint32(b.Tick)
+	w.PutUint32(b.Sequence)
+	w.PutFloat64(b.Timestamp)
+	w.PutBool(b.Keyframe)
+}
+
+func (b *FrameHeader) decode(d *blockDecoder) {
+	b.Version = d.uint16()
+	b.Tick = d.uint32()
+	b.Sequence = d.uint32()
+	b.Timestamp = d.float64()
+	b.Keyframe = d.bool()
+}
+
+type SceneObjectInstanceLeft struct {
+	// Instance is not relevant to the session anymore, it is sent again when it enters
+	Id uint16
+}
+
+func (b *SceneObjectInstanceLeft) BlockType() BlockType {
+	return SceneObjectInstanceLeftBlock
+}
+
+// Write the block in w. The block must then be ended with EndBlock().
+func (b *SceneObjectInstanceLeft) Write(w WritableBlock) {
+	w.NewBlock(uint8(SceneObjectInstanceLeftBlock))
+	w.PutUint16(b.Id)
+}
+
+func (b *SceneObjectInstanceLeft) decode(d *blockDecoder) {
+	b.Id = d.uint16()
+}
+
+// Create an empty block of type kind, or nil if kind is unknown
+func newBlock(kind BlockType) interface{ decode(d *blockDecoder) } {
+	switch kind {
+	case TextureBlock:
+		return &Texture{}
+	case CameraBlock:
+		return &Camera{}
+	case SceneObjectBlock:
+		return &SceneObject{}
+	case SceneObjectInstanceBlock:
+		return &SceneObjectInstance{}
+	case LightBlock:
+		return &Light{}
+	case LightDeletedBlock:
+		return &LightDeleted{}
+	case SceneObjectInstanceDeletedBlock:
+		return &SceneObjectInstanceDeleted{}
+	case FrameHeaderBlock:
+		return &FrameHeader{}
+	case SceneObjectInstanceLeftBlock:
+		return &SceneObjectInstanceLeft{}
+	}
+	return nil
+}
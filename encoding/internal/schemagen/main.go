@@ -0,0 +1,216 @@
+// Command schemagen generates the Go block structs and the js schema module
+// from schema.Blocks.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/geotry/stago/encoding/schema"
+)
+
+var (
+	goOut = flag.String("go", "", "Output path of the Go file")
+	jsOut = flag.String("js", "", "Output path of the js module")
+)
+
+const header = "Code generated by schemagen from schema.Blocks. DO NOT EDIT."
+
+func main() {
+	flag.Parse()
+
+	hash := schema.Hash()
+
+	if *goOut != "" {
+		src, err := format.Source(generateGo(hash))
+		if err != nil {
+			log.Fatalf("format go source: %v", err)
+		}
+		if err := os.WriteFile(*goOut, src, 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *jsOut != "" {
+		if err := os.WriteFile(*jsOut, generateJs(hash), 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func generateGo(hash uint32) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "// %s\n\npackage encoding\n\n", header)
+	if usesMatrix() {
+		fmt.Fprintf(&b, "import \"github.com/geotry/stago/compute\"\n\n")
+	}
+	fmt.Fprintf(&b, "// Hash of the binary layout of blocks, see schema.Hash()\nconst SchemaHash uint32 = 0x%08x\n\n", hash)
+	fmt.Fprintf(&b, "// Version of the frame format, see schema.ProtocolVersion\nconst ProtocolVersion = %d\n\n", schema.ProtocolVersion)
+
+	fmt.Fprintf(&b, "const (\n")
+	for _, block := range schema.Blocks {
+		fmt.Fprintf(&b, "%s BlockType = %d\n", blockTypeName(block.Type), block.Type)
+	}
+	fmt.Fprintf(&b, ")\n\n")
+
+	for _, block := range schema.Blocks {
+		fmt.Fprintf(&b, "type %s struct {\n", block.Name)
+		for _, field := range block.Fields {
+			if field.Comment != "" {
+				fmt.Fprintf(&b, "// %s\n", field.Comment)
+			}
+			fmt.Fprintf(&b, "%s %s\n", goName(field), goType(field.Type))
+		}
+		fmt.Fprintf(&b, "}\n\n")
+
+		fmt.Fprintf(&b, "func (b *%s) BlockType() BlockType {\nreturn %s\n}\n\n", block.Name, blockTypeName(block.Type))
+
+		fmt.Fprintf(&b, "// Write the block in w. The block must then be ended with EndBlock().\n")
+		fmt.Fprintf(&b, "func (b *%s) Write(w WritableBlock) {\n", block.Name)
+		fmt.Fprintf(&b, "w.NewBlock(uint8(%s))\n", blockTypeName(block.Type))
+		for _, field := range block.Fields {
+			name := goName(field)
+			switch field.Type {
+			case schema.Uint8:
+				fmt.Fprintf(&b, "w.PutUint8(b.%s)\n", name)
+			case schema.Bool:
+				fmt.Fprintf(&b, "w.PutBool(b.%s)\n", name)
+			case schema.Uint16:
+				fmt.Fprintf(&b, "w.PutUint16(b.%s)\n", name)
+			case schema.Uint32:
+				fmt.Fprintf(&b, "w.PutUint32(b.%s)\n", name)
+			case schema.Float32:
+				fmt.Fprintf(&b, "w.PutFloat32(b.%s)\n", name)
+			case schema.Float64:
+				fmt.Fprintf(&b, "w.PutFloat64(b.%s)\n", name)
+			case schema.Uint8Array:
+				fmt.Fprintf(&b, "w.NewArray()\nfor _, v := range b.%s {\nw.PutUint8(v)\n}\nw.EndArray()\n", name)
+			case schema.Float32Array:
+				fmt.Fprintf(&b, "w.NewArray()\nfor _, v := range b.%s {\nw.PutFloat32(v)\n}\nw.EndArray()\n", name)
+			case schema.Matrix:
+				fmt.Fprintf(&b, "w.PutMatrix(b.%s)\n", name)
+			}
+		}
+		fmt.Fprintf(&b, "}\n\n")
+
+		fmt.Fprintf(&b, "func (b *%s) decode(d *blockDecoder) {\n", block.Name)
+		for _, field := range block.Fields {
+			fmt.Fprintf(&b, "b.%s = d.%s()\n", goName(field), decoderMethod(field.Type))
+		}
+		fmt.Fprintf(&b, "}\n\n")
+	}
+
+	fmt.Fprintf(&b, "// Create an empty block of type kind, or nil if kind is unknown\n")
+	fmt.Fprintf(&b, "func newBlock(kind BlockType) interface{ decode(d *blockDecoder) } {\nswitch kind {\n")
+	for _, block := range schema.Blocks {
+		fmt.Fprintf(&b, "case %s:\nreturn &%s{}\n", blockTypeName(block.Type), block.Name)
+	}
+	fmt.Fprintf(&b, "}\nreturn nil\n}\n")
+
+	return b.Bytes()
+}
+
+func generateJs(hash uint32) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "// %s\n\n", header)
+	fmt.Fprintf(&b, "/**\n * Hash of the binary layout of blocks, must match the server hash.\n */\n")
+	fmt.Fprintf(&b, "export const SchemaHash = 0x%08x;\n\n", hash)
+	fmt.Fprintf(&b, "/**\n * Version of the frame format, see FrameHeader block.\n */\n")
+	fmt.Fprintf(&b, "export const ProtocolVersion = %d;\n\n", schema.ProtocolVersion)
+
+	fmt.Fprintf(&b, "export const Block = Object.freeze({\n")
+	for _, block := range schema.Blocks {
+		fmt.Fprintf(&b, "  %s: %d,\n", constantName(block.Name), block.Type)
+	}
+	fmt.Fprintf(&b, "});\n\n")
+
+	fmt.Fprintf(&b, "export const schema = {\n")
+	for _, block := range schema.Blocks {
+		fmt.Fprintf(&b, "  [Block.%s]: {\n", constantName(block.Name))
+		for _, field := range block.Fields {
+			if field.Comment != "" {
+				fmt.Fprintf(&b, "    // %s\n", field.Comment)
+			}
+			fmt.Fprintf(&b, "    %s: %q,\n", field.Name, field.Type.WireType())
+		}
+		fmt.Fprintf(&b, "  },\n")
+	}
+	fmt.Fprintf(&b, "};\n")
+
+	return b.Bytes()
+}
+
+func usesMatrix() bool {
+	for _, block := range schema.Blocks {
+		for _, field := range block.Fields {
+			if field.Type == schema.Matrix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func goName(f schema.Field) string {
+	if f.GoName != "" {
+		return f.GoName
+	}
+	r := []rune(f.Name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func goType(t schema.FieldType) string {
+	switch t {
+	case schema.Bool:
+		return "bool"
+	case schema.Uint8Array:
+		return "[]uint8"
+	case schema.Float32Array:
+		return "[]float32"
+	case schema.Matrix:
+		return "compute.Matrix"
+	}
+	return string(t)
+}
+
+func decoderMethod(t schema.FieldType) string {
+	switch t {
+	case schema.Uint8Array:
+		return "uint8Array"
+	case schema.Float32Array:
+		return "float32Array"
+	case schema.Matrix:
+		return "matrix"
+	}
+	return goType(t)
+}
+
+func blockTypeName(t uint8) string {
+	for _, block := range schema.Blocks {
+		if block.Type == t {
+			return block.Name + "Block"
+		}
+	}
+	return fmt.Sprintf("BlockType(%d)", t)
+}
+
+// Convert SceneObjectInstance to SCENE_OBJECT_INSTANCE
+func constantName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,6 @@
+package encoding
+
+//go:generate go run ./internal/schemagen -go blocks_gen.go -js ../web/src/schema.js
+
+// List of blocks, see schema.Blocks
+type BlockType uint8
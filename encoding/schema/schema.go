@@ -0,0 +1,186 @@
+// Package schema declares the binary layout of blocks sent to clients.
+//
+// Go structs of the encoding package and the js decoder schema are generated
+// from Blocks with go generate, any change must be followed by make gen_schema.
+package schema
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+)
+
+// Version of the frame format, sent in FrameHeader.
+// Increment it when the framing of blocks changes.
+const ProtocolVersion = 1
+
+// Type of a block field, named after its type in the js decoder
+type FieldType string
+
+const (
+	Uint8        FieldType = "uint8"
+	Bool         FieldType = "boolean"
+	Uint16       FieldType = "uint16"
+	Uint32       FieldType = "uint32"
+	Float32      FieldType = "float32"
+	Float64      FieldType = "float64"
+	Uint8Array   FieldType = "uint8[]"
+	Float32Array FieldType = "float32[]"
+	// A compute.Matrix encoded as a float32 array
+	Matrix FieldType = "matrix"
+)
+
+// Type of the field in the binary format
+func (t FieldType) WireType() FieldType {
+	if t == Matrix {
+		return Float32Array
+	}
+	return t
+}
+
+type Field struct {
+	// Name of the field in the js decoder
+	Name string
+	Type FieldType
+	// Name of the field in the Go struct, defaults to the capitalized Name
+	GoName  string
+	Comment string
+}
+
+// Layout of a block. Fields are written in order.
+type Block struct {
+	// Block type written in block prefix
+	Type uint8
+	// Name of the Go struct
+	Name   string
+	Fields []Field
+}
+
+// Registry of all blocks
+var Blocks = []Block{
+	{
+		Type: 0,
+		Name: "Texture",
+		Fields: []Field{
+			{Name: "id", Type: Uint8},
+			{Name: "width", Type: Uint16},
+			{Name: "height", Type: Uint16},
+			{Name: "depth", Type: Uint8},
+			{Name: "format", Type: Uint8},
+			{Name: "role", Type: Uint8},
+			{Name: "pixels", Type: Uint8Array},
+		},
+	},
+	{
+		Type: 1,
+		Name: "Camera",
+		Fields: []Field{
+			{Name: "id", Type: Uint16},
+			{Name: "viewMatrix", Type: Matrix},
+			{Name: "projectionMatrix", Type: Matrix},
+		},
+	},
+	{
+		Type: 2,
+		Name: "SceneObject",
+		Fields: []Field{
+			{Name: "id", Type: Uint32},
+			{Name: "diffuseIndex", Type: Uint8},
+			{Name: "specularIndex", Type: Uint8},
+			{Name: "shininess", Type: Float32},
+			{Name: "opaque", Type: Bool},
+			{Name: "space", Type: Uint8, Comment: "0: World, 1: Screen"},
+			{Name: "vertices", Type: Float32Array},
+			{Name: "uv", Type: Float32Array, GoName: "UV"},
+			{Name: "normals", Type: Float32Array},
+		},
+	},
+	{
+		Type: 3,
+		Name: "SceneObjectInstance",
+		Fields: []Field{
+			{Name: "id", Type: Uint16},
+			{Name: "objectId", Type: Uint32},
+			{Name: "model", Type: Matrix},
+			{Name: "tintR", Type: Float32},
+			{Name: "tintG", Type: Float32},
+			{Name: "tintB", Type: Float32},
+		},
+	},
+	{
+		Type: 4,
+		Name: "Light",
+		Fields: []Field{
+			{Name: "id", Type: Uint16},
+			{Name: "type", Type: Uint8, Comment: "0: directional, 1: point, 2: spot"},
+			{Name: "ambientR", Type: Float32},
+			{Name: "ambientG", Type: Float32},
+			{Name: "ambientB", Type: Float32},
+			{Name: "diffuseR", Type: Float32},
+			{Name: "diffuseG", Type: Float32},
+			{Name: "diffuseB", Type: Float32},
+			{Name: "specularR", Type: Float32},
+			{Name: "specularG", Type: Float32},
+			{Name: "specularB", Type: Float32},
+			{Name: "posX", Type: Float32},
+			{Name: "posY", Type: Float32},
+			{Name: "posZ", Type: Float32},
+			{Name: "directionX", Type: Float32},
+			{Name: "directionY", Type: Float32},
+			{Name: "directionZ", Type: Float32},
+			{Name: "radius", Type: Float32, Comment: "Radius of point lights, cut-off of spot lights"},
+			{Name: "outerCutOff", Type: Float32},
+		},
+	},
+	{
+		Type: 5,
+		Name: "LightDeleted",
+		Fields: []Field{
+			{Name: "id", Type: Uint16},
+			{Name: "type", Type: Uint8},
+		},
+	},
+	{
+		Type: 6,
+		Name: "SceneObjectInstanceDeleted",
+		Fields: []Field{
+			{Name: "id", Type: Uint16},
+			{Name: "objectId", Type: Uint32},
+		},
+	},
+	{
+		Type: 7,
+		Name: "FrameHeader",
+		Fields: []Field{
+			{Name: "version", Type: Uint16},
+			{Name: "tick", Type: Uint32, Comment: "Tick of the simulation when the frame was rendered"},
+			{Name: "sequence", Type: Uint32, Comment: "Sequence number of the frame in the session, starting at 1"},
+			{Name: "timestamp", Type: Float64, Comment: "Server time in milliseconds since epoch"},
+			{Name: "keyframe", Type: Bool, Comment: "Frame contains all blocks, previous state of the client must be discarded"},
+		},
+	},
+	{
+		Type: 8,
+		Name: "SceneObjectInstanceLeft",
+		Fields: []Field{
+			{Name: "id", Type: Uint16, Comment: "Instance is not relevant to the session anymore, it is sent again when it enters"},
+		},
+	},
+}
+
+// Compute the hash of the binary layout of blocks.
+// Clients must use the same hash to decode frames.
+func Hash() uint32 {
+	blocks := slices.Clone(Blocks)
+	slices.SortFunc(blocks, func(a, b Block) int { return int(a.Type) - int(b.Type) })
+
+	h := fnv.New32a()
+	for _, block := range blocks {
+		fmt.Fprintf(h, "%d{", block.Type)
+		for _, field := range block.Fields {
+			fmt.Fprintf(h, "%s:%s;", field.Name, field.Type.WireType())
+		}
+		fmt.Fprint(h, "}")
+	}
+	return h.Sum32()
+}
@@ -0,0 +1,46 @@
+package encoding
+
+import (
+	"testing"
+
+	"github.com/geotry/stago/compute"
+	"github.com/geotry/stago/encoding/schema"
+)
+
+func TestSchemaHash(t *testing.T) {
+	if hash := schema.Hash(); hash != SchemaHash {
+		t.Errorf("expected generated schema hash to be %#08x, got %#08x (run go generate ./encoding)", hash, SchemaHash)
+	}
+}
+
+func TestWriteBlock(t *testing.T) {
+	buf := NewBlockBuffer(255)
+
+	light := &Light{Id: 4, Type: 2, PosY: 20, Radius: .5}
+	light.Write(buf)
+	buf.EndBlock()
+
+	instance := &SceneObjectInstance{Id: 5, ObjectId: 10, Model: compute.NewMatrix4().Out, TintG: 1}
+	instance.Write(buf)
+	buf.EndBlock()
+
+	frame := make([]byte, buf.Offset())
+	buf.Copy(frame)
+
+	r := NewBlockReader(frame)
+	if !r.Next() {
+		t.Fatalf("expected light block, got error %v", r.Err())
+	}
+	if b, ok := r.Block().(*Light); !ok || *b != *light {
+		t.Errorf("expected block to be %+v, got %+v", light, r.Block())
+	}
+	if !r.Next() {
+		t.Fatalf("expected scene object instance block, got error %v", r.Err())
+	}
+	if b, ok := r.Block().(*SceneObjectInstance); !ok || b.Id != 5 || b.ObjectId != 10 || b.TintG != 1 || b.Model[0] != 1 {
+		t.Errorf("expected block to be %+v, got %+v", instance, r.Block())
+	}
+	if r.Next() {
+		t.Errorf("expected end of frame")
+	}
+}
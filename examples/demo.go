@@ -3,7 +3,6 @@ package examples
 import (
 	"image/color"
 	"math"
-	"math/rand/v2"
 	"time"
 
 	"github.com/geotry/stago/compute"
@@ -36,14 +35,19 @@ var Palette = []string{
 	"EFFEFF", // #EFFEFF
 }
 
-func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
-	rm := rendering.NewResourceManager()
+// Register the scenes of the examples
+func Register(r *scene.Registry) {
+	r.Register("demo", NewDemo)
+}
+
+func NewDemo(rm *rendering.ResourceManager) *scene.Scene {
 	rm.UseRGBPalette(Palette)
 
 	showAABBs := false
 	showCollisions := false
 
 	aabb := scene.NewObject(scene.SceneObjectArgs{
+		Name: "aabb",
 		Material: &rendering.Material{
 			Diffuse:   rm.NewTextureFromAtlas("assets/Environment_64x64.png", rendering.Diffuse, 176, 128, 32, 32),
 			Specular:  rm.NewTextureFromAtlas("assets/Environment_64x64.png", rendering.Specular, 176, 128, 32, 32),
@@ -77,6 +81,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 	})
 
 	ground := scene.NewObject(scene.SceneObjectArgs{
+		Name: "ground",
 		Material: &rendering.Material{
 			Diffuse:   rm.NewTextureFromAtlas("assets/Environment_64x64.png", rendering.Diffuse, 96, 96, 64, 64),
 			Specular:  rm.NewTextureFromAtlas("assets/Environment_64x64_specular.png", rendering.Specular, 96, 96, 64, 64),
@@ -103,6 +108,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 	})
 
 	cube := scene.NewObject(scene.SceneObjectArgs{
+		Name: "cube",
 		Material: &rendering.Material{
 			Diffuse:   rm.NewTextureFromAtlas("assets/Environment_64x64.png", rendering.Diffuse, 176, 96, 32, 32),
 			Specular:  rm.NewTextureFromAtlas("assets/Environment_64x64.png", rendering.Specular, 176, 96, 32, 32),
@@ -134,6 +140,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 	})
 
 	ball := scene.NewObject(scene.SceneObjectArgs{
+		Name: "ball",
 		Material: rm.NewMaterialPalette(6, []uint8{
 			ballBorderColor, ballBorderColor, ballBorderColor, ballBorderColor, ballBorderColor, ballBorderColor,
 			ballBorderColor, ballBorderColor, ballFillColor, ballFillColor + 1, ballBorderColor, ballBorderColor,
@@ -153,7 +160,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 		Init: func(self *scene.Node) {
 			self.IsKinematic = true
 			self.Data["velocity"] = 1.0
-			self.Data["rotateSpeedX"] = 1 + (rand.Float64() * 2)
+			self.Data["rotateSpeedX"] = 1 + (self.Scene.Rand().Float64() * 2)
 		},
 		Physics: &scene.Physics{Mass: 1},
 		Update: func(self *scene.Node, deltaTime time.Duration) {
@@ -169,11 +176,11 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 			self.RotateX(compute.Step(rotateSpeedX, deltaTime))
 
 			if self.Transform.Scale.X >= 0 {
-				scale := compute.Step(.5*float64(time.Since(self.SpawnTime)/time.Second), deltaTime)
+				scale := compute.Step(.5*float64(self.Scene.Since(self.SpawnTime)/time.Second), deltaTime)
 				self.Resize(-scale, -scale, -scale)
 			}
 
-			if time.Since(self.SpawnTime) >= time.Duration(time.Second*5) {
+			if self.Scene.Since(self.SpawnTime) >= time.Duration(time.Second*5) {
 				self.Destroy()
 			}
 			if showCollisions {
@@ -187,6 +194,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 	})
 
 	spot := scene.NewObject(scene.SceneObjectArgs{
+		Name: "spot",
 		Init: func(self *scene.Node) {
 			light := scene.NewSpotLight(color.RGBA{R: 128, G: 255, B: 153}, 5, 128, 255)
 			light.Ambient.A = 5
@@ -201,6 +209,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 	})
 
 	player := scene.NewObject(scene.SceneObjectArgs{
+		Name: "player",
 		Material: &rendering.Material{
 			Diffuse:   rm.NewTextureFromAtlas("assets/Environment_64x64.png", rendering.Diffuse, 176, 96, 32, 32),
 			Specular:  rm.NewTextureFromAtlas("assets/Environment_64x64.png", rendering.Specular, 176, 96, 32, 32),
@@ -210,7 +219,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 		Shape: compute.NewCube(),
 		Init: func(self *scene.Node) {
 			self.Data["fireRate"] = time.Second / 5.0
-			self.Data["lastFired"] = time.Now()
+			self.Data["lastFired"] = self.Scene.Now()
 		},
 		Input: func(self *scene.Node, event *pb.InputEvent) {
 			if self.Parent == nil {
@@ -222,10 +231,10 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 			}
 			if event.Device == pb.InputDevice_MOUSE {
 				if event.Pressed {
-					lastFired := time.Since(self.Data["lastFired"].(time.Time))
+					lastFired := self.Scene.Since(self.Data["lastFired"].(time.Time))
 					fireRate := self.Data["fireRate"].(time.Duration)
 					if lastFired > fireRate {
-						self.Data["lastFired"] = time.Now()
+						self.Data["lastFired"] = self.Scene.Now()
 						self.Scene.Spawn(ball, scene.SpawnArgs{
 							Data:     map[string]any{"Target": self.Parent.Transform.WorldPosition().Add(camera.LookAt().Mult(100.0))},
 							Position: self.Parent.Transform.WorldPosition().Sub(compute.Point{X: -2}),
@@ -253,6 +262,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 	})
 
 	rock := scene.NewObject(scene.SceneObjectArgs{
+		Name: "rock",
 		Material: &rendering.Material{
 			Diffuse:   rm.NewMaterialRGBAFromFile("assets/Sprite-0003.png", rendering.Diffuse),
 			Specular:  rm.NewMaterialRGBAFromFile("assets/Sprite-0003-specular.png", rendering.Specular),
@@ -411,12 +421,13 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 							Mass:     70,
 							Scale:    compute.Vector3{X: .3, Y: .3, Z: .3},
 						})
+						rnd := self.Scene.Rand()
 						cb.PushLocal(
 							lookAt,
-							2500+rand.Float64()*5000,
+							2500+rnd.Float64()*5000,
 							compute.Vector3{
-								X: compute.Clamp(-1+rand.Float64()*2, -.2, .2),
-								Y: compute.Clamp(-1+rand.Float64()*2, -.2, .2),
+								X: compute.Clamp(-1+rnd.Float64()*2, -.2, .2),
+								Y: compute.Clamp(-1+rnd.Float64()*2, -.2, .2),
 								Z: 0,
 							},
 						)
@@ -437,6 +448,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 	}
 
 	sun := scene.NewObject(scene.SceneObjectArgs{
+		Name: "sun",
 		Init: func(self *scene.Node) {
 			c := color.RGBA{R: 255, G: 255, B: 255, A: 255}
 			light := scene.NewDirectionalLight(c, 20, 255, 128)
@@ -449,6 +461,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 	})
 
 	lamp := scene.NewObject(scene.SceneObjectArgs{
+		Name: "lamp",
 		Init: func(self *scene.Node) {
 			light := scene.NewPointLight(color.RGBA{R: 233, G: 64, B: 64, A: 255}, 0, 250, 120)
 			light.Radius = 20.0
@@ -458,11 +471,12 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 			self.Scene.Spawn(ball, scene.SpawnArgs{Parent: self})
 		},
 		Update: func(self *scene.Node, deltaTime time.Duration) {
-			self.Transform.Position.Y = 1 + 5*math.Sin(float64(time.Since(self.SpawnTime))/float64(time.Second))
+			self.Transform.Position.Y = 1 + 5*math.Sin(float64(self.Scene.Since(self.SpawnTime))/float64(time.Second))
 		},
 	})
 
 	scn := scene.NewScene(scene.SceneOptions{
+		Name: "demo",
 		// Default camera settings when new camera is added to the scene
 		Camera: &scene.CameraSettings{
 			Projection: scene.Perspective,
@@ -474,6 +488,11 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 		CameraController: cameraController,
 	})
 
+	// Objects spawned by controllers must be known to restore snapshots
+	for _, o := range []*scene.SceneObject{aabb, ground, cube, ball, spot, player, rock, sun, lamp} {
+		scn.Register(o)
+	}
+
 	// Spawn some objects in scene
 	scn.Spawn(sun, scene.SpawnArgs{})
 	scn.Spawn(lamp, scene.SpawnArgs{
@@ -516,6 +535,7 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 
 	wall := NewWall(rm)
 	roomGround := NewGround(rm)
+	roomGround.Name = "room-ground"
 	roomScale := compute.Vector3{X: 1, Y: 3, Z: 1}
 	roomPos := compute.Vector3{X: 0, Y: 0, Z: 20}
 	roomSize := roomScale.X * 4 * 3
@@ -575,5 +595,5 @@ func NewDemo() (*scene.Scene, *rendering.ResourceManager) {
 		Rotation: compute.Vector3{X: 0, Y: math.Pi / 4, Z: 0},
 	})
 
-	return scn, rm
+	return scn
 }
@@ -20,6 +20,7 @@ func NewGround(rm *rendering.ResourceManager) *scene.SceneObject {
 	transform.ObjectToWorld(shape.Normals, shape.Normals)
 
 	return scene.NewObject(scene.SceneObjectArgs{
+		Name: "ground",
 		Material: &rendering.Material{
 			Diffuse:   rm.NewTextureFromAtlas("assets/Environment_64x64.png", rendering.Diffuse, 16, 16, 64, 64),
 			Specular:  rm.NewTextureFromAtlas("assets/Environment_64x64_specular.png", rendering.Specular, 16, 16, 64, 64),
@@ -18,6 +18,7 @@ func NewWall(rm *rendering.ResourceManager) *scene.SceneObject {
 	transform.ObjectToWorld(shape.Normals, shape.Normals)
 
 	return scene.NewObject(scene.SceneObjectArgs{
+		Name: "wall",
 		Material: &rendering.Material{
 			Diffuse:   rm.NewTextureFromAtlas("assets/Environment_64x64.png", rendering.Diffuse, 96, 96, 64, 64),
 			Specular:  rm.NewTextureFromAtlas("assets/Environment_64x64_specular.png", rendering.Specular, 96, 96, 64, 64),
@@ -58,6 +58,8 @@ type TextureGroup struct {
 	PixelSize int
 	Role      TextureRole
 	Textures  []*Texture
+	// Incremented when textures of the group change
+	Version int
 }
 
 func NewResourceManager() *ResourceManager {
@@ -134,6 +136,7 @@ func (rm *ResourceManager) UseRGBPalette(colors []string) error {
 		rm.Palette.Pixels[(i*4)+3] = 255
 		rm.paletteNextColorIndex++
 	}
+	rm.Palette.Group.Version++
 
 	return nil
 }
@@ -164,6 +167,7 @@ func (rm *ResourceManager) NewMaterialPalette(
 	if tex.Height > rm.Diffuse.Height {
 		rm.Diffuse.Height = tex.Height
 	}
+	rm.Diffuse.Version++
 	rm.Diffuse.Textures = append(rm.Diffuse.Textures, tex)
 
 	// Specular
@@ -182,6 +186,7 @@ func (rm *ResourceManager) NewMaterialPalette(
 	if spec.Height > rm.Specular.Height {
 		rm.Specular.Height = spec.Height
 	}
+	rm.Specular.Version++
 	rm.Specular.Textures = append(rm.Specular.Textures, spec)
 
 	return &Material{
@@ -246,6 +251,7 @@ func (rm *ResourceManager) NewMaterialRGBA(
 		diff.Height = rm.Diffuse.Height
 	}
 
+	rm.Diffuse.Version++
 	rm.Diffuse.Textures = append(rm.Diffuse.Textures, diff)
 
 	return &Material{
@@ -304,6 +310,7 @@ func (rm *ResourceManager) NewTextureFromAtlas(
 		group.Height = texture.Height
 	}
 
+	group.Version++
 	group.Textures = append(group.Textures, texture)
 
 	return texture
@@ -355,6 +362,7 @@ func (rm *ResourceManager) NewMaterialRGBAFromFile(path string, role TextureRole
 		group.Height = texture.Height
 	}
 
+	group.Version++
 	group.Textures = append(group.Textures, texture)
 
 	return texture
@@ -23,6 +23,9 @@ type Camera struct {
 	Near, Far  float64
 	Scale      float64
 
+	// Nodes closer than this distance are relevant even outside of the view
+	InterestRadius float64
+
 	Parent *Node
 
 	pitchYawRoll compute.Vector3
@@ -33,13 +36,17 @@ type Camera struct {
 }
 
 type CameraSettings struct {
-	Projection CameraProjection
-	Fov        float64
-	Near       float64
-	Far        float64
-	Scale      float64
+	Projection     CameraProjection
+	Fov            float64
+	Near           float64
+	Far            float64
+	Scale          float64
+	InterestRadius float64
 }
 
+// Field of view of perspective cameras without settings
+const DefaultFov = math.Pi / 2
+
 type Viewport = compute.Plane
 
 // Create a new Camera
@@ -53,7 +60,8 @@ func NewCamera(settings *CameraSettings) *Camera {
 		Far:         settings.Far,
 		Scale:       settings.Scale,
 
-		Projection: settings.Projection,
+		Projection:     settings.Projection,
+		InterestRadius: settings.InterestRadius,
 
 		pitchYawRoll:     compute.Vector3{X: 0, Y: -math.Pi / 2, Z: 0},
 		projectionMatrix: compute.NewMatrix4(),
@@ -61,6 +69,10 @@ func NewCamera(settings *CameraSettings) *Camera {
 		matrixTicker:     NewTicker(),
 	}
 
+	if c.Projection == Perspective && c.Fov == 0 {
+		c.Fov = DefaultFov
+	}
+
 	c.updateProjectionMatrix()
 
 	return c
@@ -80,6 +92,14 @@ func (c *Camera) SetSize(width, height int) {
 	}
 }
 
+// Copy size and projection settings of another camera
+func (c *Camera) CopySettings(o *Camera) {
+	c.Width, c.Height, c.AspectRatio = o.Width, o.Height, o.AspectRatio
+	c.Projection, c.Fov, c.Near, c.Far, c.Scale = o.Projection, o.Fov, o.Near, o.Far, o.Scale
+	c.InterestRadius = o.InterestRadius
+	c.updateProjectionMatrix()
+}
+
 func (c *Camera) SetNear(near float64) {
 	c.Near = near
 	c.updateProjectionMatrix()
@@ -110,32 +130,70 @@ func (c *Camera) Viewport() Viewport {
 	}
 }
 
-// Returns true if one of the point is in camera projection
+// Returns true if the bounding box of the node intersects the camera frustum
 func (c *Camera) IsVisible(o *Node) bool {
 	if o.Hidden {
 		return false
 	}
 
-	// Check object scope
-	// if o.LocalToCamera != nil && o.LocalToCamera != c {
-	// 	return false
-	// }
+	if o.Object != nil && o.Object.Space == ScreenSpace {
+		return true
+	}
 
-	// if o.SceneObject.Space == ScreenSpace {
-	// 	return true
-	// }
+	view, projection := c.ViewMatrix(), c.ProjectionMatrix()
+	model := o.Transform.Model()
 
-	// m := c.ModelViewMatrix(o)
-	// for _, p := range o.Points() {
-	// 	vp, w := p.MultMatrix(m)
-	// 	if -w < vp.X && vp.X < w && -w < vp.Y && vp.Y < w && -w < vp.Z && vp.Z < w {
-	// 		return true
-	// 	}
-	// }
+	points := []compute.Vector3{{}}
+	if o.Object != nil {
+		points = o.Object.Bounds()
+	}
+
+	// Number of points outside of each plane of the clip volume,
+	// the node is not visible if all points are outside of the same plane
+	var outside [6]int
+	for _, p := range points {
+		p, _ = p.MultMatrix(model)
+		p, _ = p.MultMatrix(view)
+		p, w := p.MultMatrix(projection)
+		if p.X < -w {
+			outside[0]++
+		}
+		if p.X > w {
+			outside[1]++
+		}
+		if p.Y < -w {
+			outside[2]++
+		}
+		if p.Y > w {
+			outside[3]++
+		}
+		if p.Z < -w {
+			outside[4]++
+		}
+		if p.Z > w {
+			outside[5]++
+		}
+	}
+	for _, n := range outside {
+		if n == len(points) {
+			return false
+		}
+	}
 
 	return true
 }
 
+// Returns true if the node is visible or closer than InterestRadius
+func (c *Camera) IsRelevant(o *Node) bool {
+	if o.Hidden {
+		return false
+	}
+	if c.InterestRadius > 0 && c.Parent.Transform.WorldPosition().DistanceTo(o.Transform.WorldPosition()) <= c.InterestRadius {
+		return true
+	}
+	return c.IsVisible(o)
+}
+
 func (c *Camera) LookAt() compute.Point {
 	pitch := c.pitchYawRoll.X
 	yaw := c.pitchYawRoll.Y
@@ -161,6 +219,10 @@ func (c *Camera) PitchYawRoll() compute.Vector3 {
 	return c.pitchYawRoll
 }
 
+func (c *Camera) SetPitchYawRoll(pitchYawRoll compute.Vector3) {
+	c.pitchYawRoll = pitchYawRoll
+}
+
 func (c *Camera) updateProjectionMatrix() {
 	c.projectionMatrix.Reset()
 	switch c.Projection {
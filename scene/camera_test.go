@@ -37,3 +37,37 @@ func TestIsVisible(t *testing.T) {
 		t.Errorf("expected point 0, 0, 0 to not be visible")
 	}
 }
+
+func TestIsRelevant(t *testing.T) {
+	s := NewScene(SceneOptions{
+		Camera: &CameraSettings{
+			Near:           0.01,
+			Far:            100.0,
+			Projection:     Perspective,
+			InterestRadius: 5,
+		},
+	})
+	c := s.SpawnCamera()
+
+	o := NewObject(SceneObjectArgs{})
+
+	// Behind the camera
+	obj := s.Spawn(o, SpawnArgs{Position: compute.Point{X: 0, Y: 0, Z: -4}})
+	if c.Camera.IsVisible(obj) {
+		t.Errorf("expected node behind camera to not be visible")
+	}
+	if !c.Camera.IsRelevant(obj) {
+		t.Errorf("expected node in interest radius to be relevant")
+	}
+
+	obj = s.Spawn(o, SpawnArgs{Position: compute.Point{X: 0, Y: 0, Z: -6}})
+	if c.Camera.IsRelevant(obj) {
+		t.Errorf("expected node out of interest radius to not be relevant")
+	}
+
+	obj.Hidden = true
+	obj.Transform.Position.Z = 3
+	if c.Camera.IsRelevant(obj) {
+		t.Errorf("expected hidden node to not be relevant")
+	}
+}
@@ -0,0 +1,46 @@
+package scene
+
+import (
+	"sync"
+	"time"
+)
+
+// Source of time of tickers, scenes and simulations
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Clock returning the wall time
+var SystemClock Clock = systemClock{}
+
+// Clock which only moves when advanced, to replay a simulation
+// with the same timings
+type ManualClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Move the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
@@ -10,6 +10,12 @@ import (
 	"github.com/geotry/stago/compute"
 )
 
+// Values saved with the state of a user between its sessions
+type UserValues interface {
+	Value(key string) (string, bool)
+	SetValue(key string, value string)
+}
+
 type Node struct {
 	Id uint32
 
@@ -43,6 +49,13 @@ type Node struct {
 
 	Data map[string]any
 
+	// Id of the user of the session controlling this node, set on
+	// cameras of sessions, see User()
+	UserId string
+	// Values saved with the state of the user, set on cameras of
+	// sessions, see Values()
+	UserValues UserValues
+
 	Transform *compute.Transform
 }
 
@@ -169,6 +182,27 @@ func (n *Node) IsDescendant(p *Node) bool {
 	return n.Parent.IsDescendant(p)
 }
 
+// Id of the user controlling the node or one of its parents, empty if none
+func (n *Node) User() string {
+	for p := n; p != nil; p = p.Parent {
+		if p.UserId != "" {
+			return p.UserId
+		}
+	}
+	return ""
+}
+
+// Values saved with the state of the user controlling the node or one
+// of its parents, nil if none
+func (n *Node) Values() UserValues {
+	for p := n; p != nil; p = p.Parent {
+		if p.UserValues != nil {
+			return p.UserValues
+		}
+	}
+	return nil
+}
+
 func (n *Node) String() string {
 	switch {
 	case n.Camera != nil:
@@ -2,7 +2,7 @@ package scene
 
 import (
 	"fmt"
-	"math/rand/v2"
+	"sync/atomic"
 	"time"
 
 	"github.com/geotry/stago/compute"
@@ -24,6 +24,8 @@ const (
 
 type SceneObject struct {
 	Id int32
+	// Name used to bind nodes of snapshots to this object
+	Name string
 
 	Material   *rendering.Material
 	Physics    *Physics
@@ -31,6 +33,11 @@ type SceneObject struct {
 	Shape      compute.Shape
 	Space      SceneSpace
 	Controller SceneObjectController
+	// Incremented when the shape or material changes
+	Version int
+
+	bounds        []compute.Vector3
+	boundsVersion int
 }
 
 type SceneObjectController struct {
@@ -40,6 +47,7 @@ type SceneObjectController struct {
 }
 
 type SceneObjectArgs struct {
+	Name      string
 	Material  *rendering.Material
 	Physics   *Physics
 	Shape     compute.Shape
@@ -49,9 +57,13 @@ type SceneObjectArgs struct {
 	Input     func(self *Node, event *pb.InputEvent)
 }
 
+// Ids of scene objects are sequential to be the same across runs
+var nextObjectId atomic.Int32
+
 func NewObject(args SceneObjectArgs) *SceneObject {
 	o := &SceneObject{
-		Id:       rand.Int32(),
+		Id:       nextObjectId.Add(1),
+		Name:     args.Name,
 		Material: args.Material,
 		Shape:    args.Shape,
 		Physics:  args.Physics,
@@ -78,6 +90,32 @@ func NewObject(args SceneObjectArgs) *SceneObject {
 	return o
 }
 
+// Replace the shape of the object, its geometry is sent again to clients
+func (o *SceneObject) SetShape(shape compute.Shape) {
+	o.Shape = shape
+	o.Version++
+}
+
+// Replace the material of the object
+func (o *SceneObject) SetMaterial(material *rendering.Material) {
+	o.Material = material
+	o.Version++
+}
+
+// Return the corners of the bounding box of the shape in object space,
+// or the origin if the object has no geometry
+func (o *SceneObject) Bounds() []compute.Vector3 {
+	if o.bounds == nil || o.boundsVersion != o.Version {
+		if len(o.Shape.Geometry) > 0 {
+			o.bounds = compute.NewAABB(o.Shape.Geometry).Points
+		} else {
+			o.bounds = []compute.Vector3{{}}
+		}
+		o.boundsVersion = o.Version
+	}
+	return o.bounds
+}
+
 func (o *SceneObject) String() string {
 	return fmt.Sprintf("id=%d space=%v w=%.2f h=%.2f", o.Id, o.Space, o.Size.X, o.Size.Y)
 }
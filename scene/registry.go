@@ -0,0 +1,63 @@
+package scene
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/geotry/stago/rendering"
+)
+
+var ErrUnknownScene = errors.New("scene is not registered")
+
+// Create a scene, with textures and meshes added to rm
+type SceneFactory func(rm *rendering.ResourceManager) *Scene
+
+// Factories of scenes by name
+type Registry struct {
+	factories map[string]SceneFactory
+	mu        sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{factories: map[string]SceneFactory{}}
+}
+
+// Register a factory of scenes by name. A factory registered with the
+// same name is replaced.
+func (r *Registry) Register(name string, factory SceneFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = factory
+}
+
+// Names of registered scenes, in alphabetical order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Create the scene registered with this name. The scene is named after
+// the registry if the factory does not name it.
+func (r *Registry) New(name string, rm *rendering.ResourceManager) (*Scene, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("new scene %q: %w", name, ErrUnknownScene)
+	}
+
+	scn := factory(rm)
+	if scn.Name == "" {
+		scn.Name = name
+	}
+	return scn, nil
+}
@@ -0,0 +1,39 @@
+package scene
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/geotry/stago/rendering"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register("empty", func(rm *rendering.ResourceManager) *Scene {
+		return NewScene(SceneOptions{})
+	})
+	r.Register("arena", func(rm *rendering.ResourceManager) *Scene {
+		return NewScene(SceneOptions{Name: "custom"})
+	})
+
+	if names := r.Names(); !slices.Equal(names, []string{"arena", "empty"}) {
+		t.Fatalf("expected sorted names, got %v", names)
+	}
+
+	rm := rendering.NewResourceManager()
+	scn, err := r.New("empty", rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scn.Name != "empty" {
+		t.Errorf("expected scene named after registry, got %q", scn.Name)
+	}
+	if scn, _ := r.New("arena", rm); scn.Name != "custom" {
+		t.Errorf("expected name of factory, got %q", scn.Name)
+	}
+
+	if _, err := r.New("missing", rm); !errors.Is(err, ErrUnknownScene) {
+		t.Errorf("expected ErrUnknownScene, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"image/color"
 	"math"
+	"math/rand/v2"
 	"slices"
 	"sync"
 	"time"
@@ -15,6 +16,9 @@ import (
 )
 
 type Scene struct {
+	// Name used by sessions to join the scene
+	Name string
+
 	nodes   map[uint32]*Node
 	sorted  []*Node
 	queue   chan func()
@@ -32,21 +36,41 @@ type Scene struct {
 	cameraSettings    *CameraSettings // default camera settings applied
 	cameraSceneObject *SceneObject
 
+	// Scene objects by name, see Register()
+	objects map[string]*SceneObject
+
+	seed uint64
+	rand *rand.Rand
+
+	// Number of pairs tested for collision during last update
+	collisionPairs int
+
 	mu sync.RWMutex
 }
 
 type SceneOptions struct {
+	Name             string
 	Camera           *CameraSettings
 	CameraController *SceneObjectController
 	Gravity          *Force
+	// Source of time of Update(), SystemClock if nil
+	Clock Clock
+	// Seed of Rand(), a random seed if 0
+	Seed uint64
 }
 
 func NewScene(opts SceneOptions) *Scene {
+	clock := opts.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+
 	scene := &Scene{
+		Name:    opts.Name,
 		nodes:   map[uint32]*Node{},
 		sorted:  make([]*Node, 0),
 		nextId:  1,
-		ticker:  &Ticker{},
+		ticker:  NewClockTicker(clock),
 		cameras: make([]*Camera, 0),
 		queue:   make(chan func(), 1000),
 
@@ -56,19 +80,85 @@ func NewScene(opts SceneOptions) *Scene {
 		gravity: compute.Vector3{Y: -9.8},
 
 		cameraSettings: opts.Camera,
-		cameraSceneObject: NewObject(SceneObjectArgs{
-			Init:   opts.CameraController.Init,
-			Update: opts.CameraController.Update,
-			Input:  opts.CameraController.Input,
-		}),
+		objects:        make(map[string]*SceneObject),
 	}
+
+	seed := opts.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	scene.Reseed(seed)
+
+	cameraArgs := SceneObjectArgs{Name: "camera"}
+	if opts.CameraController != nil {
+		cameraArgs.Init = opts.CameraController.Init
+		cameraArgs.Update = opts.CameraController.Update
+		cameraArgs.Input = opts.CameraController.Input
+	}
+	scene.cameraSceneObject = NewObject(cameraArgs)
+	scene.Register(scene.cameraSceneObject)
+
 	return scene
 }
 
+// Update the scene with the time elapsed since last update
 func (s *Scene) Update() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.processEvents()
+	_, deltaTime := s.ticker.Tick()
+	s.update(deltaTime)
+}
+
+// Update the scene with a fixed time step. Scenes updated with the same
+// steps and events end in the same state.
+func (s *Scene) Step(deltaTime time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.processEvents()
+	s.ticker.Step(deltaTime)
+	s.update(deltaTime)
+}
+
+// Handle the events queued since last update without moving the scene
+// forward, as for a paused scene
+func (s *Scene) ProcessEvents() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.processEvents()
+}
+
+// Source of randomness of the scene. Controllers must use it instead of
+// math/rand to update scenes the same way with the same seed.
+func (s *Scene) Rand() *rand.Rand {
+	return s.rand
+}
+
+// Seed of Rand()
+func (s *Scene) Seed() uint64 {
+	return s.seed
+}
+
+// Restart Rand() from seed
+func (s *Scene) Reseed(seed uint64) {
+	s.seed = seed
+	s.rand = rand.New(rand.NewPCG(seed, seed))
+}
+
+// Time of the scene, moved forward on each update
+func (s *Scene) Now() time.Time {
+	return s.ticker.Time()
+}
+
+// Time of the scene elapsed since t
+func (s *Scene) Since(t time.Time) time.Duration {
+	return s.ticker.Time().Sub(t)
+}
+
+func (s *Scene) processEvents() {
 	// Clear old, new nodes
 	s.OldNodes = nil
 	s.NewNodes = nil
@@ -83,8 +173,9 @@ queue:
 			break queue
 		}
 	}
+}
 
-	_, deltaTime := s.ticker.Tick()
+func (s *Scene) update(deltaTime time.Duration) {
 	// deltaTime /= 10
 
 	// Save transforms before update to compare with new transforms
@@ -114,6 +205,7 @@ queue:
 
 	// 1. Broad phase with Sweep and Prune
 	pairs := compute.SweepAndPrune(s.sorted)
+	s.collisionPairs = len(pairs)
 
 	// 2. Narrow phase with GJK
 	collisions := make([]Collision, 0)
@@ -231,7 +323,9 @@ func (s *Scene) sortNodes() {
 // Queue an input event
 func (s *Scene) ReceiveInput(event *pb.InputEvent, source *Node) {
 	s.queue <- func() {
-		for _, o := range s.nodes {
+		// Handlers run in the order of node ids to be replayed the same way
+		for _, id := range slices.Sorted(maps.Keys(s.nodes)) {
+			o := s.nodes[id]
 			if o.Object.Controller.Input != nil && o.IsDescendant(source) {
 				o.Object.Controller.Input(o, event)
 			}
@@ -240,7 +334,17 @@ func (s *Scene) ReceiveInput(event *pb.InputEvent, source *Node) {
 }
 
 func (s *Scene) SpawnCamera() *Node {
-	return s.Spawn(s.cameraSceneObject, SpawnArgs{camera: NewCamera(s.cameraSettings)})
+	return s.SpawnUserCamera("")
+}
+
+// Spawn a camera controlled by a user, see Node.User()
+func (s *Scene) SpawnUserCamera(userId string) *Node {
+	return s.Spawn(s.cameraSceneObject, SpawnArgs{UserId: userId, camera: NewCamera(s.cameraSettings)})
+}
+
+// Run fn on next update, after the events queued before
+func (s *Scene) Schedule(fn func()) {
+	s.queue <- fn
 }
 
 type SpawnArgs struct {
@@ -252,6 +356,7 @@ type SpawnArgs struct {
 	Data     map[string]any
 	Tint     color.RGBA
 	Hidden   bool
+	UserId   string
 
 	camera *Camera
 }
@@ -263,9 +368,10 @@ func (s *Scene) Spawn(o *SceneObject, args SpawnArgs) *Node {
 		Parent:    args.Parent,
 		Camera:    args.camera,
 		Data:      make(map[string]any),
-		SpawnTime: time.Now(),
+		SpawnTime: s.Now(),
 		Mass:      args.Mass,
 		Hidden:    args.Hidden,
+		UserId:    args.UserId,
 		Tint:      color.RGBA{R: 255, G: 255, B: 255, A: 255},
 		Transform: compute.NewTransform(nil),
 		// TransformOld: compute.NewTransform(nil),
@@ -320,6 +426,7 @@ func (s *Scene) scheduleNewObjectInstance(o *Node) {
 	s.queue <- func() {
 		o.Id = s.nextId
 		s.nextId = s.nextId + 1
+		s.Register(o.Object)
 		if o.Object.Controller.Init != nil {
 			o.Object.Controller.Init(o)
 		}
@@ -352,6 +459,14 @@ func (s *Scene) Objects() []*Node {
 	return s.sorted
 }
 
+// Number of pairs of nodes tested for collision during last update
+func (s *Scene) CollisionPairs() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.collisionPairs
+}
+
 // Return nodes visible by camera
 func (s *Scene) Scan(c *Camera) []*Node {
 	objs := make([]*Node, 0)
@@ -0,0 +1,61 @@
+package scene
+
+import (
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/compute"
+)
+
+func TestStepIsDeterministic(t *testing.T) {
+	run := func() []compute.Vector3 {
+		clock := NewManualClock(time.Unix(0, 0))
+		s := NewScene(SceneOptions{Clock: clock})
+		cube := NewObject(SceneObjectArgs{Shape: compute.NewCube(), Physics: &Physics{Mass: 1}})
+
+		nodes := []*Node{
+			s.Spawn(cube, SpawnArgs{Position: compute.Point{Y: 10}}),
+			s.Spawn(cube, SpawnArgs{Position: compute.Point{X: .5, Y: 12}}),
+		}
+		s.Update()
+		nodes[0].Accelerate(compute.Vector3{X: 1})
+
+		for range 120 {
+			// Wall time between steps must not change the result
+			clock.Advance(time.Duration(len(s.Objects())) * time.Millisecond)
+			s.Step(time.Second / 60)
+		}
+
+		positions := make([]compute.Vector3, len(nodes))
+		for i, node := range nodes {
+			positions[i] = node.Transform.Position
+		}
+		return positions
+	}
+
+	a, b := run(), run()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Errorf("expected node %d at the same position, got %v and %v", i, a[i], b[i])
+		}
+	}
+	if a[0].Y >= 10 {
+		t.Errorf("expected node to fall, got %v", a[0])
+	}
+}
+
+func TestSceneTime(t *testing.T) {
+	start := time.Unix(100, 0)
+	s := NewScene(SceneOptions{Clock: NewManualClock(start)})
+
+	s.Step(time.Second)
+	s.Step(time.Second)
+	if d := s.Since(start); d != 2*time.Second {
+		t.Errorf("expected scene time to move by fixed steps, got %v", d)
+	}
+
+	node := s.Spawn(NewObject(SceneObjectArgs{}), SpawnArgs{})
+	if !node.SpawnTime.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("expected spawn time to be scene time, got %v", node.SpawnTime)
+	}
+}
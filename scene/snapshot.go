@@ -0,0 +1,354 @@
+package scene
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"image/color"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/geotry/stago/compute"
+)
+
+// Version of SceneSnapshot, incremented when its layout changes
+const SnapshotVersion = 2
+
+var (
+	ErrUnnamedObject = errors.New("scene object has no name")
+	ErrUnknownObject = errors.New("scene object is not registered")
+	ErrUnknownParent = errors.New("parent node is not in snapshot")
+)
+
+// Snapshot of the node graph of a scene
+type SceneSnapshot struct {
+	Name    string
+	NextId  uint32
+	Gravity compute.Vector3
+	// Time of the scene, which SpawnTime of nodes and times stored in
+	// their data refer to
+	Time  time.Time
+	Nodes []NodeSnapshot
+}
+
+type NodeSnapshot struct {
+	Id uint32
+	// Name of the registered scene object
+	Object string
+	// Id of the parent node, 0 if none
+	Parent    uint32
+	Hidden    bool
+	SpawnTime time.Time
+	UserId    string
+
+	Camera *CameraSnapshot
+	Light  *LightSnapshot
+
+	Mass                float64
+	GravityVelocity     compute.Vector3
+	TranslationVelocity compute.Vector3
+	AngularVelocity     compute.Vector3
+	TerminalVelocity    float64
+	TranslationMomentum compute.Vector3
+	RotationMomentum    compute.Vector3
+	IsKinematic         bool
+
+	Tint color.RGBA
+	// Values referencing nodes are replaced by NodeRef
+	Data map[string]any
+
+	Position      compute.Vector3
+	Rotation      compute.Quaternion
+	RotationPivot compute.Vector3
+	Scale         compute.Vector3
+}
+
+type CameraSnapshot struct {
+	Width, Height, AspectRatio float64
+	Projection                 CameraProjection
+	Fov, Near, Far, Scale      float64
+	InterestRadius             float64
+	PitchYawRoll               compute.Vector3
+}
+
+type LightSnapshot struct {
+	Type                       LightType
+	Direction                  compute.Vector3
+	Ambient, Diffuse, Specular color.RGBA
+	Radius                     float64
+	CutOff, OuterCutOff        float64
+}
+
+// Reference to a node stored in Node.Data
+type NodeRef struct {
+	Id uint32
+}
+
+func init() {
+	// Types stored in Node.Data
+	gob.Register(NodeRef{})
+	gob.Register(compute.Vector3{})
+	gob.Register(compute.Vector4{})
+	gob.Register(color.RGBA{})
+	gob.Register(time.Time{})
+	gob.Register(time.Duration(0))
+}
+
+// Register a scene object by its name, to bind nodes of snapshots to it.
+// Named objects are registered when spawned.
+func (s *Scene) Register(o *SceneObject) {
+	if o.Name != "" {
+		s.objects[o.Name] = o
+	}
+}
+
+// Return the node with this id, or nil
+func (s *Scene) Node(id uint32) *Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.nodes[id]
+}
+
+// Take a snapshot of nodes of the scene. Nodes spawned or destroyed
+// since last Update() are not included.
+func (s *Scene) Snapshot() (*SceneSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap := &SceneSnapshot{
+		Name:    s.Name,
+		NextId:  s.nextId,
+		Gravity: s.gravity,
+		Time:    s.ticker.Time(),
+		Nodes:   make([]NodeSnapshot, 0, len(s.nodes)),
+	}
+
+	ids := slices.Sorted(maps.Keys(s.nodes))
+	for _, id := range ids {
+		node := s.nodes[id]
+		if node.Object.Name == "" {
+			return nil, fmt.Errorf("node %d: %w", id, ErrUnnamedObject)
+		}
+		snap.Nodes = append(snap.Nodes, node.snapshot())
+	}
+
+	return snap, nil
+}
+
+func (n *Node) snapshot() NodeSnapshot {
+	snap := NodeSnapshot{
+		Id:                  n.Id,
+		Object:              n.Object.Name,
+		Hidden:              n.Hidden,
+		SpawnTime:           n.SpawnTime,
+		UserId:              n.UserId,
+		Mass:                n.Mass,
+		GravityVelocity:     n.GravityVelocity,
+		TranslationVelocity: n.TranslationVelocity,
+		AngularVelocity:     n.AngularVelocity,
+		TerminalVelocity:    n.TerminalVelocity,
+		TranslationMomentum: n.TranslationMomentum,
+		RotationMomentum:    n.RotationMomentum,
+		IsKinematic:         n.IsKinematic,
+		Tint:                n.Tint,
+		Data:                make(map[string]any, len(n.Data)),
+		Position:            n.Transform.Position,
+		Rotation:            n.Transform.Rotation,
+		RotationPivot:       n.Transform.RotationPivot,
+		Scale:               n.Transform.Scale,
+	}
+
+	if n.Parent != nil {
+		snap.Parent = n.Parent.Id
+	}
+
+	for key, value := range n.Data {
+		switch v := value.(type) {
+		case nil:
+		case *Node:
+			if v != nil {
+				snap.Data[key] = NodeRef{Id: v.Id}
+			}
+		default:
+			snap.Data[key] = value
+		}
+	}
+
+	if c := n.Camera; c != nil {
+		snap.Camera = &CameraSnapshot{
+			Width:          c.Width,
+			Height:         c.Height,
+			AspectRatio:    c.AspectRatio,
+			Projection:     c.Projection,
+			Fov:            c.Fov,
+			Near:           c.Near,
+			Far:            c.Far,
+			Scale:          c.Scale,
+			InterestRadius: c.InterestRadius,
+			PitchYawRoll:   c.pitchYawRoll,
+		}
+	}
+
+	switch l := n.Light.(type) {
+	case *DirectionalLight:
+		snap.Light = &LightSnapshot{Type: Directional, Direction: l.Direction, Ambient: l.Ambient, Diffuse: l.Diffuse, Specular: l.Specular}
+	case *PointLight:
+		snap.Light = &LightSnapshot{Type: Point, Ambient: l.Ambient, Diffuse: l.Diffuse, Specular: l.Specular, Radius: l.Radius}
+	case *SpotLight:
+		snap.Light = &LightSnapshot{Type: Spot, Direction: l.Direction, Ambient: l.Ambient, Diffuse: l.Diffuse, Specular: l.Specular, CutOff: l.CutOff, OuterCutOff: l.OuterCutOff}
+	}
+
+	return snap
+}
+
+// Check that a snapshot can be restored in the scene: the scene objects
+// of its nodes are registered and their parents are in the snapshot.
+func (s *Scene) CheckSnapshot(snap *SceneSnapshot) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.checkSnapshot(snap)
+}
+
+func (s *Scene) checkSnapshot(snap *SceneSnapshot) error {
+	ids := make(map[uint32]bool, len(snap.Nodes))
+	for _, ns := range snap.Nodes {
+		if s.objects[ns.Object] == nil {
+			return fmt.Errorf("node %d: %w: %q", ns.Id, ErrUnknownObject, ns.Object)
+		}
+		ids[ns.Id] = true
+	}
+	for _, ns := range snap.Nodes {
+		if ns.Parent != 0 && !ids[ns.Parent] {
+			return fmt.Errorf("node %d: %w: %d", ns.Id, ErrUnknownParent, ns.Parent)
+		}
+	}
+	return nil
+}
+
+// Replace all nodes and the time of the scene by those of a snapshot.
+// Nodes are bound to registered scene objects by name, and controllers
+// are not initialized again. Pending events are discarded, and nodes
+// replaced and restored are in OldNodes and NewNodes after next update.
+// The scene is left unchanged if the snapshot cannot be restored.
+func (s *Scene) Restore(snap *SceneSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkSnapshot(snap); err != nil {
+		return err
+	}
+
+	nodes := make(map[uint32]*Node, len(snap.Nodes))
+	for _, ns := range snap.Nodes {
+		nodes[ns.Id] = s.restoreNode(s.objects[ns.Object], ns)
+	}
+
+	// Link nodes once all of them exist
+	for _, ns := range snap.Nodes {
+		node := nodes[ns.Id]
+		if parent := nodes[ns.Parent]; parent != nil {
+			node.Parent = parent
+			node.Transform.Parent = parent.Transform
+		}
+		for key, value := range ns.Data {
+			if ref, ok := value.(NodeRef); ok {
+				if target := nodes[ref.Id]; target != nil {
+					node.Data[key] = target
+				}
+				continue
+			}
+			node.Data[key] = value
+		}
+	}
+
+queue:
+	for {
+		select {
+		case <-s.queue:
+		default:
+			break queue
+		}
+	}
+
+	// Lists of nodes are cleared before events of next update are run
+	old, restored := slices.Collect(maps.Values(s.nodes)), slices.Collect(maps.Values(nodes))
+	s.queue <- func() {
+		s.OldNodes = append(s.OldNodes, old...)
+		s.NewNodes = append(s.NewNodes, restored...)
+	}
+
+	s.nodes = nodes
+	s.nextId = snap.NextId
+	s.gravity = snap.Gravity
+	s.ticker.time = snap.Time
+
+	for _, node := range nodes {
+		node.UpdateCollider()
+	}
+
+	s.sorted = slices.Collect(maps.Values(nodes))
+	s.sortNodes()
+
+	return nil
+}
+
+func (s *Scene) restoreNode(o *SceneObject, ns NodeSnapshot) *Node {
+	node := &Node{
+		Id:                  ns.Id,
+		Object:              o,
+		Scene:               s,
+		Hidden:              ns.Hidden,
+		SpawnTime:           ns.SpawnTime,
+		UserId:              ns.UserId,
+		Mass:                ns.Mass,
+		GravityVelocity:     ns.GravityVelocity,
+		TranslationVelocity: ns.TranslationVelocity,
+		AngularVelocity:     ns.AngularVelocity,
+		TerminalVelocity:    ns.TerminalVelocity,
+		TranslationMomentum: ns.TranslationMomentum,
+		RotationMomentum:    ns.RotationMomentum,
+		IsKinematic:         ns.IsKinematic,
+		Tint:                ns.Tint,
+		Data:                make(map[string]any, len(ns.Data)),
+		Transform:           compute.NewTransform(nil),
+	}
+
+	node.Transform.Position = ns.Position
+	node.Transform.Rotation = ns.Rotation
+	node.Transform.RotationPivot = ns.RotationPivot
+	node.Transform.Scale = ns.Scale
+
+	if cs := ns.Camera; cs != nil {
+		c := NewCamera(&CameraSettings{})
+		c.Width, c.Height, c.AspectRatio = cs.Width, cs.Height, cs.AspectRatio
+		c.Projection, c.Fov, c.Near, c.Far, c.Scale = cs.Projection, cs.Fov, cs.Near, cs.Far, cs.Scale
+		c.InterestRadius = cs.InterestRadius
+		c.pitchYawRoll = cs.PitchYawRoll
+		c.Parent = node
+		c.updateProjectionMatrix()
+		node.Camera = c
+	}
+
+	if ls := ns.Light; ls != nil {
+		switch ls.Type {
+		case Directional:
+			l := NewDirectionalLight(color.RGBA{}, 0, 0, 0)
+			l.Direction, l.Ambient, l.Diffuse, l.Specular = ls.Direction, ls.Ambient, ls.Diffuse, ls.Specular
+			node.Light = l
+		case Point:
+			l := NewPointLight(color.RGBA{}, 0, 0, 0)
+			l.Ambient, l.Diffuse, l.Specular, l.Radius = ls.Ambient, ls.Diffuse, ls.Specular, ls.Radius
+			node.Light = l
+		case Spot:
+			l := NewSpotLight(color.RGBA{}, 0, 0, 0)
+			l.Direction, l.Ambient, l.Diffuse, l.Specular = ls.Direction, ls.Ambient, ls.Diffuse, ls.Specular
+			l.CutOff, l.OuterCutOff = ls.CutOff, ls.OuterCutOff
+			node.Light = l
+		}
+	}
+
+	return node
+}
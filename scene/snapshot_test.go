@@ -0,0 +1,132 @@
+package scene
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/compute"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	newScene := func() (*Scene, *SceneObject) {
+		s := NewScene(SceneOptions{Name: "test", Camera: &CameraSettings{Projection: Perspective}})
+		o := NewObject(SceneObjectArgs{Name: "object"})
+		s.Register(o)
+		return s, o
+	}
+
+	s, o := newScene()
+	parent := s.Spawn(o, SpawnArgs{Position: compute.Point{X: 1, Y: 2, Z: 3}})
+	child := s.Spawn(o, SpawnArgs{Parent: parent, Position: compute.Point{Z: -1}})
+	s.Update()
+	child.TranslationVelocity = compute.Vector3{X: 4}
+	child.Data["target"] = parent
+	child.Data["speed"] = 1.5
+
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	restored, _ := newScene()
+	if err := restored.Restore(snap); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	p, c := restored.Node(parent.Id), restored.Node(child.Id)
+	if p == nil || c == nil {
+		t.Fatalf("expected nodes to be restored")
+	}
+	if c.Parent != p || c.Transform.Parent != p.Transform {
+		t.Errorf("expected child to be linked to its parent")
+	}
+	if c.Data["target"] != p || c.Data["speed"] != 1.5 {
+		t.Errorf("expected data to be restored, got %v", c.Data)
+	}
+	if c.TranslationVelocity.X != 4 || p.Transform.Position.Y != 2 {
+		t.Errorf("expected physics and transform to be restored")
+	}
+	next := restored.Spawn(o, SpawnArgs{})
+	restored.Update()
+	if next.Id != snap.NextId {
+		t.Errorf("expected next node id %d, got %d", snap.NextId, next.Id)
+	}
+
+	empty := NewScene(SceneOptions{Name: "test"})
+	if err := empty.Restore(snap); !errors.Is(err, ErrUnknownObject) {
+		t.Errorf("expected ErrUnknownObject, got %v", err)
+	}
+}
+
+func TestRestoreLifetime(t *testing.T) {
+	newScene := func(start time.Time) (*Scene, *SceneObject) {
+		s := NewScene(SceneOptions{Name: "test", Clock: NewManualClock(start)})
+		o := NewObject(SceneObjectArgs{
+			Name: "object",
+			Update: func(self *Node, deltaTime time.Duration) {
+				if self.Scene.Since(self.SpawnTime) >= time.Second {
+					self.Destroy()
+				}
+			},
+		})
+		s.Register(o)
+		return s, o
+	}
+
+	s, o := newScene(time.Unix(0, 0))
+	node := s.Spawn(o, SpawnArgs{})
+	for range 5 {
+		s.Step(100 * time.Millisecond)
+	}
+
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Clock of the restored scene is far from the time of the snapshot
+	restored, _ := newScene(time.Unix(1000, 0))
+	replaced := restored.Spawn(o, SpawnArgs{})
+	restored.Update()
+	if err := restored.Restore(snap); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !restored.Now().Equal(s.Now()) {
+		t.Errorf("expected scene time %v, got %v", s.Now(), restored.Now())
+	}
+
+	restored.Step(100 * time.Millisecond)
+	if !slices.Contains(restored.OldNodes, replaced) || len(restored.NewNodes) != 1 {
+		t.Errorf("expected replaced and restored nodes after update, got %v and %v", restored.OldNodes, restored.NewNodes)
+	}
+
+	// Node is destroyed one second after its spawn, on the update after
+	for range 4 {
+		restored.Step(100 * time.Millisecond)
+	}
+	if restored.Node(node.Id) == nil {
+		t.Fatalf("expected node alive before the end of its lifetime")
+	}
+	restored.Step(100 * time.Millisecond)
+	if restored.Node(node.Id) != nil || len(restored.OldNodes) != 1 {
+		t.Errorf("expected node destroyed at the end of its lifetime")
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	s := NewScene(SceneOptions{Name: "test"})
+	o := NewObject(SceneObjectArgs{Name: "object"})
+	s.Register(o)
+	node := s.Spawn(o, SpawnArgs{})
+	s.Update()
+
+	snap := &SceneSnapshot{NextId: 10, Nodes: []NodeSnapshot{{Id: 1, Object: "object", Parent: 5}}}
+	if err := s.Restore(snap); !errors.Is(err, ErrUnknownParent) {
+		t.Errorf("expected ErrUnknownParent, got %v", err)
+	}
+	if s.Node(node.Id) != node {
+		t.Errorf("expected scene unchanged after invalid snapshot")
+	}
+}
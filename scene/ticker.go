@@ -3,36 +3,52 @@ package scene
 import "time"
 
 type Ticker struct {
-	tick int
-	time time.Time
+	tick  int
+	time  time.Time
+	clock Clock
 }
 
 // Create a Ticker to synchronize two objects using ticks
 func NewTicker() *Ticker {
+	return NewClockTicker(SystemClock)
+}
+
+// Create a Ticker measuring time with clock
+func NewClockTicker(clock Clock) *Ticker {
 	return &Ticker{
-		tick: 0,
-		time: time.Now(),
+		tick:  0,
+		time:  clock.Now(),
+		clock: clock,
 	}
 }
 
 func (t *Ticker) Reset() {
 	t.tick = 0
-	t.time = time.Now()
+	t.time = t.clock.Now()
 }
 
+// Increment tick and returns the time elapsed since last tick
 func (t *Ticker) Tick() (int, time.Duration) {
 	t.tick++
-	d := time.Since(t.time)
-	t.time = time.Now()
+	now := t.clock.Now()
+	d := now.Sub(t.time)
+	t.time = now
 	return t.tick, d
 }
 
+// Increment tick and move time forward by d, ignoring the clock
+func (t *Ticker) Step(d time.Duration) int {
+	t.tick++
+	t.time = t.time.Add(d)
+	return t.tick
+}
+
 func (t *Ticker) Time() time.Time {
 	return t.time
 }
 
 func (t *Ticker) Since() time.Duration {
-	return time.Since(t.time)
+	return t.clock.Now().Sub(t.time)
 }
 
 func (t *Ticker) Sync(s *Ticker) int {
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Default lifetime of session tokens
+const TOKEN_TTL = 24 * time.Hour
+
+// Size in bytes of the random secret signing tokens
+const TOKEN_SECRET_SIZE = 32
+
+var (
+	ErrInvalidSessionToken = errors.New("invalid session token")
+	ErrForbidden           = errors.New("forbidden for the role of the session")
+	ErrUnauthenticated     = errors.New("user not authenticated")
+)
+
+// Role of the client of a session
+type Role string
+
+const (
+	// Moves the camera of its session
+	RolePlayer Role = "player"
+	// Watches the scene from its session without sending input
+	RoleSpectator Role = "spectator"
+	// Player which also controls the time of scenes and reads their assets
+	RoleAdmin Role = "admin"
+)
+
+// Input events can be sent to the session
+func (r Role) CanInput() bool {
+	return r == RolePlayer || r == RoleAdmin
+}
+
+// Time of the scene of the session can be paused, resumed, stepped and scaled
+func (r Role) CanControlTime() bool {
+	return r == RoleAdmin
+}
+
+func (r Role) valid() bool {
+	return r == RolePlayer || r == RoleSpectator || r == RoleAdmin
+}
+
+// Session a token was issued for
+type Claims struct {
+	SessionId string `json:"sid"`
+	UserId    string `json:"uid,omitempty"`
+	Role      Role   `json:"role"`
+	// Unix time after which the token is refused
+	Expires int64 `json:"exp"`
+}
+
+// Issues and verifies session tokens, made of the claims in JSON and their
+// HMAC-SHA256 signature, both encoded in base64
+type auth struct {
+	secret   []byte
+	ttl      time.Duration
+	adminKey string
+}
+
+func newAuth(secret []byte, ttl time.Duration, adminKey string) *auth {
+	if len(secret) == 0 {
+		secret = make([]byte, TOKEN_SECRET_SIZE)
+		rand.Read(secret)
+	}
+	if ttl <= 0 {
+		ttl = TOKEN_TTL
+	}
+	return &auth{secret: secret, ttl: ttl, adminKey: adminKey}
+}
+
+func (a *auth) signature(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign the claims of a new session
+func (a *auth) issue(claims Claims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("issue token: %w", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + a.signature(payload), nil
+}
+
+// Return the claims of a token signed by issue() and not expired
+func (a *auth) verify(token string, now time.Time) (*Claims, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(a.signature(payload))) {
+		return nil, ErrInvalidSessionToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidSessionToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, ErrInvalidSessionToken
+	}
+	if !claims.Role.valid() || claims.SessionId == "" || now.Unix() > claims.Expires {
+		return nil, ErrInvalidSessionToken
+	}
+	return &claims, nil
+}
+
+// Key or token sent in the header "Authorization: Bearer <key>"
+func bearer(r *http.Request) (string, bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// Admin key, or token of an admin session, sent as a bearer token
+func (a *auth) isAdmin(r *http.Request) bool {
+	key, ok := bearer(r)
+	if !ok {
+		return false
+	}
+	if a.adminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.adminKey)) == 1 {
+		return true
+	}
+	claims, err := a.verify(key, time.Now())
+	return err == nil && claims.Role == RoleAdmin
+}
+
+// Claims of a valid token sent as a bearer token, nil if none
+func (a *auth) claims(r *http.Request) *Claims {
+	token, ok := bearer(r)
+	if !ok {
+		return nil
+	}
+	claims, err := a.verify(token, time.Now())
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+type tokenRequest struct {
+	UserId string `json:"user_id"`
+	Role   Role   `json:"role"`
+}
+
+type tokenResponse struct {
+	Token     string `json:"token"`
+	SessionId string `json:"session_id"`
+	UserId    string `json:"user_id"`
+	Role      Role   `json:"role"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// Handler issuing the token of a new session:
+//
+//	POST /token {"user_id": "", "role": "player"}
+//
+// Players and spectators are issued to anyone, admins only to requests with
+// the admin key in the header "Authorization: Bearer <key>". The user of
+// the session is the user authenticated by Options.Authenticate, or else
+// the user of a valid token of the client sent in the same header, or a
+// new user. Only admins choose the user id of the request.
+func (s *WebsocketServer) Tokens() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := tokenRequest{Role: RolePlayer}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Role == "" {
+			req.Role = RolePlayer
+		}
+		if !req.Role.valid() {
+			http.Error(w, "invalid role", http.StatusBadRequest)
+			return
+		}
+		if req.Role == RoleAdmin && !s.auth.isAdmin(r) {
+			http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+
+		userId, err := s.userOf(r, req)
+		switch {
+		case errors.Is(err, ErrForbidden):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, ErrUnauthenticated.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		claims := Claims{
+			SessionId: rand.Text(),
+			UserId:    userId,
+			Role:      req.Role,
+			Expires:   time.Now().Add(s.auth.ttl).Unix(),
+		}
+		token, err := s.auth.issue(claims)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokenResponse{Token: token, SessionId: claims.SessionId, UserId: claims.UserId, Role: claims.Role, ExpiresAt: claims.Expires})
+	})
+}
+
+// User of a token request
+func (s *WebsocketServer) userOf(r *http.Request, req tokenRequest) (string, error) {
+	if req.UserId != "" {
+		if !s.auth.isAdmin(r) {
+			return "", ErrForbidden
+		}
+		return req.UserId, nil
+	}
+	if s.authenticate != nil {
+		return s.authenticate(r)
+	}
+	if claims := s.auth.claims(r); claims != nil && claims.UserId != "" {
+		return claims.UserId, nil
+	}
+	return rand.Text(), nil
+}
+
+// Handler refusing requests without the admin key or an admin token
+func (s *WebsocketServer) requireAdmin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.auth.isAdmin(r) {
+			http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	a := newAuth([]byte("secret"), time.Hour, "")
+	now := time.Unix(1000, 0)
+
+	token, err := a.issue(Claims{SessionId: "session", UserId: "user", Role: RolePlayer, Expires: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := a.verify(token, now)
+	if err != nil || claims.SessionId != "session" || claims.UserId != "user" || claims.Role != RolePlayer {
+		t.Fatalf("expected claims of the token, got %+v (error %v)", claims, err)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	tampered := base64.RawURLEncoding.EncodeToString([]byte(`{"sid":"session","uid":"user","role":"admin","exp":4600}`))
+	other, _ := newAuth([]byte("other"), time.Hour, "").issue(*claims)
+
+	for name, token := range map[string]string{
+		"bad signature":  payload + "." + sig[1:],
+		"other secret":   other,
+		"tampered role":  tampered + "." + sig,
+		"no signature":   payload,
+		"empty":          "",
+		"not base64":     "!." + a.signature("!"),
+		"not json":       "e30x." + a.signature("e30x"),
+		"unknown role":   signed(a, `{"sid":"session","role":"root","exp":4600}`),
+		"missing sid":    signed(a, `{"role":"player","exp":4600}`),
+		"missing expiry": signed(a, `{"sid":"session","role":"player"}`),
+	} {
+		if _, err := a.verify(token, now); !errors.Is(err, ErrInvalidSessionToken) {
+			t.Errorf("%s: expected ErrInvalidSessionToken, got %v", name, err)
+		}
+	}
+
+	if _, err := a.verify(token, now.Add(2*time.Hour)); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Errorf("expected expired token to be refused, got %v", err)
+	}
+}
+
+// Token with a payload signed by a
+func signed(a *auth, payload string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + a.signature(encoded)
+}
+
+func TestRoles(t *testing.T) {
+	for _, test := range []struct {
+		role        Role
+		input, time bool
+	}{
+		{RolePlayer, true, false},
+		{RoleSpectator, false, false},
+		{RoleAdmin, true, true},
+		{Role("root"), false, false},
+	} {
+		if test.role.CanInput() != test.input || test.role.CanControlTime() != test.time {
+			t.Errorf("expected %s to input %v and control time %v", test.role, test.input, test.time)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	s := newTestServer(t, Options{AdminKey: "key"})
+	handler := s.Tokens()
+
+	request := func(body string, authorization string) (*httptest.ResponseRecorder, tokenResponse) {
+		r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(body))
+		if authorization != "" {
+			r.Header.Set("Authorization", "Bearer "+authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		var res tokenResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w, res
+	}
+
+	w, player := request("", "")
+	if w.Code != http.StatusOK || player.Role != RolePlayer || player.UserId == "" {
+		t.Fatalf("expected token of a new player, got %d %s", w.Code, w.Body)
+	}
+	claims, err := s.auth.verify(player.Token, time.Now())
+	if err != nil || claims.SessionId != player.SessionId || claims.UserId != player.UserId {
+		t.Errorf("expected token of the session, got %+v (error %v)", claims, err)
+	}
+
+	// Returning client is identified by its last token
+	if _, res := request("", player.Token); res.UserId != player.UserId || res.SessionId == player.SessionId {
+		t.Errorf("expected new session of user %s, got %+v", player.UserId, res)
+	}
+	if _, res := request("", "invalid"); res.UserId == player.UserId || res.UserId == "" {
+		t.Errorf("expected new user with an invalid token, got %q", res.UserId)
+	}
+
+	// Users and admins are chosen with the admin key only
+	for _, body := range []string{`{"user_id":"alice"}`, `{"role":"admin"}`} {
+		if w, _ := request(body, player.Token); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 without admin key, got %d", body, w.Code)
+		}
+		if w, _ := request(body, "wrong"); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 with wrong key, got %d", body, w.Code)
+		}
+	}
+	w, admin := request(`{"user_id":"alice","role":"admin"}`, "key")
+	if w.Code != http.StatusOK || admin.Role != RoleAdmin || admin.UserId != "alice" {
+		t.Errorf("expected admin token of alice, got %d %s", w.Code, w.Body)
+	}
+	if _, res := request(`{"user_id":"bob","role":"spectator"}`, admin.Token); res.UserId != "bob" || res.Role != RoleSpectator {
+		t.Errorf("expected admin token to issue tokens of any user, got %+v", res)
+	}
+
+	if w, _ := request(`{"role":"root"}`, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown role, got %d", w.Code)
+	}
+	if w, _ := request(`{`, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid body, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/token", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET, got %d", w.Code)
+	}
+}
+
+func TestTokensAuthenticate(t *testing.T) {
+	s := newTestServer(t, Options{Authenticate: func(r *http.Request) (string, error) {
+		cookie, err := r.Cookie("user")
+		if err != nil {
+			return "", err
+		}
+		return cookie.Value, nil
+	}})
+
+	r := httptest.NewRequest(http.MethodPost, "/token", nil)
+	r.AddCookie(&http.Cookie{Name: "user", Value: "carol"})
+	w := httptest.NewRecorder()
+	s.Tokens().ServeHTTP(w, r)
+	var res tokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.UserId != "carol" {
+		t.Errorf("expected token of authenticated user, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	s.Tokens().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/token", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for unauthenticated request, got %d", w.Code)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	s := newTestServer(t, Options{AdminKey: "key"})
+	handler := s.requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for key, code := range map[string]int{"": http.StatusForbidden, "wrong": http.StatusForbidden, "key": http.StatusOK} {
+		r := httptest.NewRequest(http.MethodGet, "/debug/assets", nil)
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Errorf("key %q: expected %d, got %d", key, code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/geotry/stago/encoding"
+	"github.com/geotry/stago/pb"
+	"github.com/geotry/stago/simulation"
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Subprotocols of the websocket connections. "stago" carries the typed
+// messages of a session on one connection, "render" and "input" are kept
+// for clients opening one connection for each. Client messages are sent
+// in protobuf in binary messages with "stago.proto", which clients request
+// before "stago" when they can, and in JSON in text messages otherwise.
+const (
+	PROTOCOL_STAGO       = "stago"
+	PROTOCOL_STAGO_PROTO = "stago.proto"
+	PROTOCOL_RENDER      = "render"
+	PROTOCOL_INPUT       = "input"
+)
+
+var (
+	ErrSchemaMismatch = errors.New("schema mismatch")
+	ErrNoSession      = errors.New("no session open on the connection")
+	ErrBadMessage     = errors.New("bad message")
+	ErrMessageType    = errors.New("message type does not match the subprotocol")
+)
+
+// Websocket connection written by the sender of frames and by the
+// handler of messages
+type syncConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *syncConn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// Connection of the "stago" subprotocol, carrying the messages of one
+// session. Messages are handled one at a time in the order received,
+// frames are written by another goroutine.
+type connection struct {
+	server *WebsocketServer
+	conn   *syncConn
+	// Session and role of the token of the connection
+	claims  *Claims
+	session *simulation.Session
+}
+
+func newConnection(s *WebsocketServer, c *websocket.Conn, claims *Claims) *connection {
+	return &connection{server: s, conn: &syncConn{Conn: c}, claims: claims}
+}
+
+// Handle messages until the connection is closed
+func (c *connection) serve() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer func() {
+		if c.session != nil {
+			c.server.sim.CloseSession(c.session.Id)
+		}
+	}()
+
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var msg pb.ClientMessage
+		if err := decode(c.conn.Subprotocol(), messageType, data, &msg); err != nil {
+			if errors.Is(err, ErrMessageType) {
+				return closeConn(c.conn.Conn, websocket.CloseUnsupportedData, err)
+			}
+			c.sendError(0, fmt.Errorf("%w: %v", ErrBadMessage, err))
+			continue
+		}
+
+		if err := c.handle(ctx, &msg); err != nil {
+			c.sendError(msg.Id, err)
+			if errors.Is(err, ErrSchemaMismatch) {
+				return closeConn(c.conn.Conn, websocket.ClosePolicyViolation, ErrSchemaMismatch)
+			}
+			if errors.Is(err, simulation.ErrInvalidToken) || errors.Is(err, simulation.ErrSessionDetached) {
+				return closeConn(c.conn.Conn, websocket.ClosePolicyViolation, simulation.ErrInvalidToken)
+			}
+		}
+	}
+}
+
+func (c *connection) handle(ctx context.Context, msg *pb.ClientMessage) error {
+	switch m := msg.Message.(type) {
+	case *pb.ClientMessage_Render:
+		return c.handleRender(ctx, m.Render)
+	case *pb.ClientMessage_Input:
+		if c.session == nil {
+			return ErrNoSession
+		}
+		if !c.claims.Role.CanInput() {
+			return ErrForbidden
+		}
+		// Events can only move the session of the connection
+		m.Input.SessionId = c.session.Id
+		return c.server.sim.ReceiveInput(c.session.Id, m.Input)
+	case *pb.ClientMessage_Inputs:
+		if c.session == nil {
+			return ErrNoSession
+		}
+		if !c.claims.Role.CanInput() {
+			return ErrForbidden
+		}
+		for _, event := range m.Inputs.Events {
+			event.SessionId = c.session.Id
+		}
+		return c.server.sim.ReceiveInputs(c.session.Id, m.Inputs.Events)
+	case *pb.ClientMessage_Ack:
+		if c.session == nil {
+			return ErrNoSession
+		}
+		c.session.Ack(m.Ack)
+		return nil
+	case *pb.ClientMessage_Ping:
+		return c.send(&pb.ServerMessage{Message: &pb.ServerMessage_Pong{Pong: m.Ping}})
+	}
+	return ErrBadMessage
+}
+
+// Open the session with the first render message, and apply the render
+// options of the next ones
+func (c *connection) handleRender(ctx context.Context, req *pb.RenderRequest) error {
+	if c.session != nil {
+		if req.TimeControl != nil {
+			if !c.claims.Role.CanControlTime() {
+				return ErrForbidden
+			}
+			return c.server.HandleTimeControl(c.session, req.TimeControl)
+		}
+		return c.server.configure(c.session, req)
+	}
+
+	// Connection can only open the session of its token
+	req.SessionId, req.UserId = c.claims.SessionId, c.claims.UserId
+	if req.SchemaHash != encoding.SchemaHash {
+		log.Printf("[ws] session_id=%s schema mismatch client=%#08x server=%#08x", req.SessionId, req.SchemaHash, encoding.SchemaHash)
+		return ErrSchemaMismatch
+	}
+
+	session, newSession, err := c.server.openSession(req)
+	if err != nil {
+		log.Printf("[ws] session_id=%s error=%v", req.SessionId, err)
+		return err
+	}
+	c.session = session
+
+	if err := c.server.configure(session, req); err != nil {
+		return err
+	}
+
+	// Frames of the session are sent on another connection
+	if !newSession {
+		return nil
+	}
+
+	// Send the token to resume the session before frames
+	res := &pb.ServerMessage{Message: &pb.ServerMessage_Render{Render: &pb.RenderResponse{ResumeToken: session.ResumeToken()}}}
+	if err := c.send(res); err != nil {
+		return err
+	}
+
+	sender := newSender(c.conn, session)
+	go sender.run(ctx)
+	go c.server.stream(ctx, session, sender)
+	return nil
+}
+
+// Type of the client messages of a subprotocol: binary messages in
+// protobuf for "stago.proto", text messages in JSON for the others
+func clientMessageType(protocol string) int {
+	if protocol == PROTOCOL_STAGO_PROTO {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// Decode a client message with the encoding of the subprotocol. Returns
+// ErrMessageType for a message of another type.
+func decode(protocol string, messageType int, data []byte, m proto.Message) error {
+	if messageType != clientMessageType(protocol) {
+		return ErrMessageType
+	}
+	if messageType == websocket.BinaryMessage {
+		return proto.Unmarshal(data, m)
+	}
+	return protojson.Unmarshal(data, m)
+}
+
+// Write a text message to the client
+func (c *connection) send(msg *pb.ServerMessage) error {
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Write the error caused by the message id to the client
+func (c *connection) sendError(id uint32, err error) {
+	code := pb.ErrorCode_INTERNAL
+	switch {
+	case errors.Is(err, ErrBadMessage):
+		code = pb.ErrorCode_BAD_MESSAGE
+	case errors.Is(err, ErrSchemaMismatch):
+		code = pb.ErrorCode_SCHEMA_MISMATCH
+	case errors.Is(err, simulation.ErrInvalidToken), errors.Is(err, simulation.ErrSessionDetached):
+		code = pb.ErrorCode_INVALID_TOKEN
+	case errors.Is(err, ErrNoSession), errors.Is(err, simulation.ErrSessionNotFound):
+		code = pb.ErrorCode_SESSION_NOT_FOUND
+	case errors.Is(err, simulation.ErrSceneNotFound):
+		code = pb.ErrorCode_SCENE_NOT_FOUND
+	case errors.Is(err, ErrForbidden):
+		code = pb.ErrorCode_FORBIDDEN
+	}
+
+	msg := &pb.ServerMessage{Message: &pb.ServerMessage_Error{Error: &pb.Error{Id: id, Code: code, Message: err.Error()}}}
+	if err := c.send(msg); err != nil {
+		log.Printf("[ws] send error: %v", err)
+	}
+}
+
+// Close the connection with a code and the error as reason, and return
+// the error
+func closeConn(c *websocket.Conn, code int, err error) error {
+	msg := websocket.FormatCloseMessage(code, err.Error())
+	c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	return err
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/encoding"
+	"github.com/geotry/stago/pb"
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Serve the websocket connections of s
+func newTestHTTPServer(t *testing.T, s *WebsocketServer) *httptest.Server {
+	upgrader := websocket.Upgrader{Subprotocols: []string{PROTOCOL_STAGO_PROTO, PROTOCOL_STAGO, PROTOCOL_RENDER, PROTOCOL_INPUT}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		s.Handle(c, r.URL.Query().Get("token"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Open a connection with the subprotocol to the session of a new player
+func dial(t *testing.T, s *WebsocketServer, ts *httptest.Server, protocol string) *websocket.Conn {
+	token, err := s.auth.issue(Claims{SessionId: t.Name(), Role: RolePlayer, Expires: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := websocket.Dialer{Subprotocols: []string{protocol}}
+	endpoint := "ws" + strings.TrimPrefix(ts.URL, "http") + "?token=" + url.QueryEscape(token)
+	c, _, err := dialer.Dial(endpoint, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if c.Subprotocol() != protocol {
+		t.Fatalf("expected subprotocol %s, got %q", protocol, c.Subprotocol())
+	}
+	return c
+}
+
+// Read the next text message, skipping frames
+func receive(t *testing.T, c *websocket.Conn) *pb.ServerMessage {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		messageType, data, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("expected message, got %v", err)
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		var msg pb.ServerMessage
+		if err := protojson.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("expected server message, got %q: %v", data, err)
+		}
+		return &msg
+	}
+}
+
+// Read the next frame, skipping text messages
+func receiveFrame(t *testing.T, c *websocket.Conn) *encoding.BlockReader {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		messageType, data, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("expected frame, got %v", err)
+		}
+		if messageType == websocket.BinaryMessage {
+			return encoding.NewBlockReader(data)
+		}
+	}
+}
+
+func sendJSON(t *testing.T, c *websocket.Conn, msg *pb.ClientMessage) {
+	t.Helper()
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(websocket.TextMessage, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectionJSON(t *testing.T) {
+	s := newTestServer(t, Options{})
+	ts := newTestHTTPServer(t, s)
+	c := dial(t, s, ts, PROTOCOL_STAGO)
+
+	sendJSON(t, c, &pb.ClientMessage{Id: 1, Message: &pb.ClientMessage_Ping{Ping: &pb.Ping{Time: 5}}})
+	if msg := receive(t, c); msg.GetPong().GetTime() != 5 {
+		t.Errorf("expected pong, got %v", msg)
+	}
+
+	// Messages other than render need a session
+	sendJSON(t, c, &pb.ClientMessage{Id: 2, Message: &pb.ClientMessage_Ack{Ack: 1}})
+	if msg := receive(t, c); msg.GetError().GetCode() != pb.ErrorCode_SESSION_NOT_FOUND || msg.GetError().GetId() != 2 {
+		t.Errorf("expected SESSION_NOT_FOUND error of message 2, got %v", msg)
+	}
+
+	// Malformed messages are refused without closing the connection
+	for _, data := range []string{"not json", `{"id":3,"unknown":1}`, `{"id":3}`} {
+		if err := c.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if msg := receive(t, c); msg.GetError().GetCode() != pb.ErrorCode_BAD_MESSAGE {
+			t.Errorf("%s: expected BAD_MESSAGE error, got %v", data, msg)
+		}
+	}
+
+	sendJSON(t, c, &pb.ClientMessage{Id: 4, Message: &pb.ClientMessage_Render{Render: &pb.RenderRequest{SchemaHash: encoding.SchemaHash, Fps: 30}}})
+	if msg := receive(t, c); msg.GetRender().GetResumeToken() == "" {
+		t.Fatalf("expected resume token, got %v", msg)
+	}
+	r := receiveFrame(t, c)
+	if !r.Next() || r.Header() == nil || !r.Header().Keyframe {
+		t.Errorf("expected keyframe first, got error %v", r.Err())
+	}
+	if session := s.sim.GetSession(t.Name()); session == nil {
+		t.Errorf("expected session of the token opened")
+	}
+}
+
+func sendProto(t *testing.T, c *websocket.Conn, msg *pb.ClientMessage) {
+	t.Helper()
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectionProto(t *testing.T) {
+	// Camera counting the key presses of its session
+	var pressed atomic.Int32
+	registry := scene.NewRegistry()
+	registry.Register("test", func(rm *rendering.ResourceManager) *scene.Scene {
+		return scene.NewScene(scene.SceneOptions{
+			Camera: &scene.CameraSettings{Projection: scene.Perspective},
+			CameraController: &scene.SceneObjectController{
+				Input: func(self *scene.Node, event *pb.InputEvent) {
+					if event.Pressed {
+						pressed.Add(1)
+					}
+				},
+			},
+		})
+	})
+	s := newTestServer(t, Options{Registry: registry})
+	ts := newTestHTTPServer(t, s)
+	c := dial(t, s, ts, PROTOCOL_STAGO_PROTO)
+
+	sendProto(t, c, &pb.ClientMessage{Id: 1, Message: &pb.ClientMessage_Ping{Ping: &pb.Ping{Time: 5}}})
+	if msg := receive(t, c); msg.GetPong().GetTime() != 5 {
+		t.Errorf("expected pong, got %v", msg)
+	}
+
+	if err := c.WriteMessage(websocket.BinaryMessage, []byte{0xff, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, c); msg.GetError().GetCode() != pb.ErrorCode_BAD_MESSAGE {
+		t.Errorf("expected BAD_MESSAGE error, got %v", msg)
+	}
+
+	sendProto(t, c, &pb.ClientMessage{Id: 3, Message: &pb.ClientMessage_Render{Render: &pb.RenderRequest{SchemaHash: encoding.SchemaHash}}})
+	if msg := receive(t, c); msg.GetRender().GetResumeToken() == "" {
+		t.Fatalf("expected resume token, got %v", msg)
+	}
+	s.sim.RunTicks(1)
+
+	events := []*pb.InputEvent{
+		{Device: pb.InputDevice_KEYBOARD, Pressed: true},
+		{Device: pb.InputDevice_KEYBOARD, Released: true},
+		{Device: pb.InputDevice_KEYBOARD, Pressed: true, SessionId: "other"},
+	}
+	sendProto(t, c, &pb.ClientMessage{Id: 4, Message: &pb.ClientMessage_Inputs{Inputs: &pb.InputBatch{Events: events}}})
+
+	// Messages are handled in order, the batch is received before the pong
+	sendProto(t, c, &pb.ClientMessage{Id: 5, Message: &pb.ClientMessage_Ping{Ping: &pb.Ping{Time: 7}}})
+	if msg := receive(t, c); msg.GetPong().GetTime() != 7 {
+		t.Errorf("expected pong, got %v", msg)
+	}
+	s.sim.RunTicks(1)
+	if n := pressed.Load(); n != 2 {
+		t.Errorf("expected 2 key presses on the camera of the session, got %d", n)
+	}
+}
+
+func TestConnectionMessageType(t *testing.T) {
+	s := newTestServer(t, Options{})
+	ts := newTestHTTPServer(t, s)
+	ping := &pb.ClientMessage{Id: 1, Message: &pb.ClientMessage_Ping{Ping: &pb.Ping{Time: 5}}}
+
+	for _, test := range []struct {
+		protocol string
+		send     func(t *testing.T, c *websocket.Conn, msg *pb.ClientMessage)
+	}{
+		{PROTOCOL_STAGO, sendProto},
+		{PROTOCOL_STAGO_PROTO, sendJSON},
+		{PROTOCOL_RENDER, sendProto},
+	} {
+		t.Run(test.protocol, func(t *testing.T) {
+			c := dial(t, s, ts, test.protocol)
+			test.send(t, c, ping)
+			c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err := c.ReadMessage()
+			if !websocket.IsCloseError(err, websocket.CloseUnsupportedData) {
+				t.Errorf("expected connection closed for unsupported data, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConnectionSchemaMismatch(t *testing.T) {
+	s := newTestServer(t, Options{})
+	ts := newTestHTTPServer(t, s)
+	c := dial(t, s, ts, PROTOCOL_STAGO)
+
+	sendJSON(t, c, &pb.ClientMessage{Id: 1, Message: &pb.ClientMessage_Render{Render: &pb.RenderRequest{SchemaHash: encoding.SchemaHash + 1}}})
+	if msg := receive(t, c); msg.GetError().GetCode() != pb.ErrorCode_SCHEMA_MISMATCH {
+		t.Errorf("expected SCHEMA_MISMATCH error, got %v", msg)
+	}
+	_, _, err := c.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("expected connection closed for policy violation, got %v", err)
+	}
+}
+
+func TestConnectionInvalidToken(t *testing.T) {
+	s := newTestServer(t, Options{})
+	ts := newTestHTTPServer(t, s)
+
+	dialer := websocket.Dialer{Subprotocols: []string{PROTOCOL_STAGO}}
+	c, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?token=invalid", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, _, err := c.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("expected connection closed for policy violation, got %v", err)
+	}
+}
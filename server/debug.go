@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/geotry/stago/simulation"
+)
+
+// Handler exporting the assets of scenes, with the scene name in the
+// query parameter "scene" (the default scene if empty):
+//
+//	GET /debug/assets              textures and objects of the scene in JSON
+//	GET /debug/assets/textures/{id} texture group in PNG
+//	GET /debug/assets/objects/{id}  mesh of a scene object in Wavefront OBJ
+//
+// Requests must send the admin key or the token of an admin session in the
+// header "Authorization: Bearer <token>".
+func (s *WebsocketServer) DebugAssets() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /debug/assets", func(w http.ResponseWriter, r *http.Request) {
+		assets, err := s.sim.Assets(r.URL.Query().Get("scene"))
+		if err != nil {
+			writeDebugError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assets)
+	})
+
+	mux.HandleFunc("GET /debug/assets/textures/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid texture id", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		if err := s.sim.ExportTexture(w, r.URL.Query().Get("scene"), id); err != nil {
+			writeDebugError(w, err)
+		}
+	})
+
+	mux.HandleFunc("GET /debug/assets/objects/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid object id", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "model/obj")
+		if err := s.sim.ExportObject(w, r.URL.Query().Get("scene"), int32(id)); err != nil {
+			writeDebugError(w, err)
+		}
+	})
+
+	return s.requireAdmin(mux)
+}
+
+func writeDebugError(w http.ResponseWriter, err error) {
+	if errors.Is(err, simulation.ErrAssetNotFound) || errors.Is(err, simulation.ErrSceneNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("[debug] error=%v", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
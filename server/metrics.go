@@ -0,0 +1,199 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/geotry/stago/simulation"
+)
+
+// Upper bounds in seconds of the buckets of tick durations
+var durationBuckets = []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05}
+
+// Metrics of the simulation in the Prometheus text format. Series are
+// labeled by scene but not by session, so their number does not grow
+// with sessions.
+type Metrics struct {
+	// Simulation of sessions, set once the simulation is created
+	sim *simulation.Simulation
+
+	update *histogram
+	save   *histogram
+
+	ticks      uint64
+	saveErrors uint64
+	last       simulation.TickStats
+
+	mu sync.Mutex
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		update: newHistogram(durationBuckets),
+		save:   newHistogram(durationBuckets),
+	}
+}
+
+// Record the statistics of a tick, see simulation.SimulationOptions.OnTick
+func (m *Metrics) ObserveTick(stats simulation.TickStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.update.observe(stats.Update.Seconds())
+	m.save.observe(stats.Save.Seconds())
+	m.ticks++
+	m.saveErrors += uint64(stats.SaveErrors)
+	m.last = stats
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// Write all metrics in w
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+
+	m.mu.Lock()
+	writeHistogram(&b, "stago_tick_update_seconds", "Time to update all scenes in a tick.", m.update)
+	writeHistogram(&b, "stago_tick_save_seconds", "Time to save the state of all scenes in a tick.", m.save)
+	writeHeader(&b, "stago_ticks_total", "Number of ticks run.", "counter")
+	writeSample(&b, "stago_ticks_total", nil, float64(m.ticks))
+	writeHeader(&b, "stago_save_errors_total", "Number of scenes which failed to be saved.", "counter")
+	writeSample(&b, "stago_save_errors_total", nil, float64(m.saveErrors))
+	last := m.last
+	m.mu.Unlock()
+
+	scenes := []struct {
+		name, help string
+		value      func(s simulation.SceneStats) float64
+	}{
+		{"stago_scene_nodes", "Number of nodes in the scene.", func(s simulation.SceneStats) float64 { return float64(s.Nodes) }},
+		{"stago_scene_collision_pairs", "Number of pairs of nodes tested for collision in the last tick.", func(s simulation.SceneStats) float64 { return float64(s.CollisionPairs) }},
+		{"stago_scene_buffer_bytes", "Bytes used in the state buffer of the scene.", func(s simulation.SceneStats) float64 { return float64(s.BufferSize) }},
+		{"stago_scene_buffer_capacity_bytes", "Capacity of the state buffer of the scene.", func(s simulation.SceneStats) float64 { return float64(s.BufferCapacity) }},
+		{"stago_scene_blocks", "Number of blocks in the state buffer of the scene.", func(s simulation.SceneStats) float64 { return float64(s.Blocks) }},
+	}
+	for _, metric := range scenes {
+		writeHeader(&b, metric.name, metric.help, "gauge")
+		for _, scn := range last.Scenes {
+			writeSample(&b, metric.name, []string{"scene", scn.Name}, metric.value(scn))
+		}
+	}
+
+	// Sessions and their frames by scene
+	sessions := m.sim.SessionStats()
+	count, fps := make(map[string]int), make(map[string]int)
+	frames := make(map[string]simulation.SessionStats)
+	for _, session := range sessions {
+		count[session.Scene]++
+		fps[session.Scene] += session.Fps
+		f := frames[session.Scene]
+		f.BytesSent += session.BytesSent
+		f.FramesSent += session.FramesSent
+		f.FramesDropped += session.FramesDropped
+		frames[session.Scene] = f
+	}
+	writeHeader(&b, "stago_sessions", "Number of opened sessions.", "gauge")
+	writeSample(&b, "stago_sessions", nil, float64(len(sessions)))
+
+	writeHeader(&b, "stago_scene_sessions", "Number of sessions in the scene.", "gauge")
+	for _, scn := range last.Scenes {
+		writeSample(&b, "stago_scene_sessions", []string{"scene", scn.Name}, float64(count[scn.Name]))
+	}
+	writeHeader(&b, "stago_scene_session_fps", "Mean frame rate of the sessions in the scene.", "gauge")
+	for _, scn := range last.Scenes {
+		if n := count[scn.Name]; n > 0 {
+			writeSample(&b, "stago_scene_session_fps", []string{"scene", scn.Name}, float64(fps[scn.Name])/float64(n))
+		}
+	}
+
+	// Counters of sessions, which are not counted anymore once the
+	// session is deleted or moved to another scene
+	sessionFrames := []struct {
+		name, help string
+		value      func(s simulation.SessionStats) float64
+	}{
+		{"stago_scene_session_sent_bytes", "Bytes of frames written to the sessions in the scene.", func(s simulation.SessionStats) float64 { return float64(s.BytesSent) }},
+		{"stago_scene_session_frames_sent", "Number of frames written to the sessions in the scene.", func(s simulation.SessionStats) float64 { return float64(s.FramesSent) }},
+		{"stago_scene_session_frames_dropped", "Number of frames which could not be written to the sessions in the scene.", func(s simulation.SessionStats) float64 { return float64(s.FramesDropped) }},
+	}
+	for _, metric := range sessionFrames {
+		writeHeader(&b, metric.name, metric.help, "gauge")
+		for _, scn := range last.Scenes {
+			writeSample(&b, metric.name, []string{"scene", scn.Name}, metric.value(frames[scn.Name]))
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Histogram with cumulative buckets
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func writeHeader(b *strings.Builder, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(b *strings.Builder, name, help string, h *histogram) {
+	writeHeader(b, name, help, "histogram")
+	for i, bound := range h.bounds {
+		writeSample(b, name+"_bucket", []string{"le", formatFloat(bound)}, float64(h.counts[i]))
+	}
+	writeSample(b, name+"_bucket", []string{"le", "+Inf"}, float64(h.count))
+	writeSample(b, name+"_sum", nil, h.sum)
+	writeSample(b, name+"_count", nil, float64(h.count))
+}
+
+// Write a sample with labels given as name, value pairs
+func writeSample(b *strings.Builder, name string, labels []string, value float64) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+	"github.com/geotry/stago/simulation"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metrics := NewMetrics()
+	sim := simulation.NewSimulation(rendering.NewResourceManager(), simulation.SimulationOptions{
+		Clock:  scene.NewManualClock(time.Unix(0, 0)),
+		OnTick: metrics.ObserveTick,
+	})
+	sim.AddScene(scene.NewScene(scene.SceneOptions{Name: "arena", Camera: &scene.CameraSettings{Projection: scene.Perspective}}))
+	sim.Start(ctx)
+	metrics.sim = sim
+
+	for i, id := range []string{"secret-a", "secret-b"} {
+		session, _, err := sim.OpenSession(id, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		session.SetFps(30)
+		session.FrameSent(100 / (i + 1))
+	}
+	sim.GetSession("secret-a").FrameDropped()
+	sim.RunTicks(2)
+
+	var b strings.Builder
+	if _, err := metrics.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	for _, line := range []string{
+		"# TYPE stago_tick_update_seconds histogram",
+		`stago_tick_update_seconds_bucket{le="+Inf"} 2`,
+		"stago_tick_update_seconds_count 2",
+		"stago_ticks_total 2",
+		`stago_scene_nodes{scene="arena"} 2`,
+		"stago_sessions 2",
+		`stago_scene_sessions{scene="arena"} 2`,
+		`stago_scene_session_fps{scene="arena"} 30`,
+		`stago_scene_session_sent_bytes{scene="arena"} 150`,
+		`stago_scene_session_frames_sent{scene="arena"} 2`,
+		`stago_scene_session_frames_dropped{scene="arena"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "secret-") {
+		t.Errorf("expected no session id in metrics")
+	}
+}
+
+func TestWriteSample(t *testing.T) {
+	var b strings.Builder
+	writeSample(&b, "stago_scene_nodes", []string{"scene", "a \"b\"\n"}, 1.5)
+	if s := b.String(); s != "stago_scene_nodes{scene=\"a \\\"b\\\"\\n\"} 1.5\n" {
+		t.Errorf("expected escaped label, got %q", s)
+	}
+}
+
+func TestMetricsAdmin(t *testing.T) {
+	s := newTestServer(t, Options{AdminKey: "key"})
+
+	for key, status := range map[string]int{"": http.StatusForbidden, "other": http.StatusForbidden, "key": http.StatusOK} {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		s.Metrics().ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("key %q: expected status %d, got %d", key, status, w.Code)
+		}
+	}
+}
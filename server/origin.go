@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Origins allowed to reach the server from a browser, either exact
+// ("https://example.com") or with wildcards matching any part of a host
+// or a port ("https://*.example.com", "http://localhost:*"). "*" allows
+// any origin.
+type Origins []string
+
+// Parse a comma separated list of origins
+func ParseOrigins(list string) Origins {
+	var origins Origins
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, strings.ToLower(strings.TrimSuffix(origin, "/")))
+		}
+	}
+	return origins
+}
+
+// Origin matches one of the origins
+func (o Origins) Allow(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range o {
+		if pattern == "*" || matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept websocket connections from allowed origins, see websocket.Upgrader.
+// Requests without an origin are not sent by browsers and are accepted.
+func (o Origins) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || o.Allow(origin)
+}
+
+// Handler answering cross-origin requests from allowed origins
+func (o Origins) CORS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && o.Allow(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Match an origin with a pattern in which "*" matches one or more
+// characters other than "/"
+func matchOrigin(pattern, origin string) bool {
+	star := strings.IndexByte(pattern, '*')
+	if star == -1 {
+		return pattern == origin
+	}
+	if !strings.HasPrefix(origin, pattern[:star]) {
+		return false
+	}
+	rest, origin := pattern[star+1:], origin[star:]
+	for i := 1; i <= len(origin) && origin[i-1] != '/'; i++ {
+		if matchOrigin(rest, origin[i:]) {
+			return true
+		}
+	}
+	return false
+}
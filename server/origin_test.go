@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/pb"
+	"github.com/gorilla/websocket"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	for _, test := range []struct {
+		pattern, origin string
+		match           bool
+	}{
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "https://example.org", false},
+		{"https://example.com", "https://example.com:8443", false},
+		{"https://example.com", "http://example.com", false},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://app.example.com.evil.org", false},
+		{"https://*.example.com", "https://evil.org/.example.com", false},
+		{"https://*.example.com", "http://app.example.com", false},
+		{"https://*.example.com", "https://app.example.com:8443", false},
+		{"http://localhost:*", "http://localhost:8080", true},
+		{"http://localhost:*", "http://localhost", false},
+		{"http://localhost:*", "https://localhost:8080", false},
+		{"http://localhost:*", "http://localhost.evil.org:8080", false},
+		{"http://*:*", "http://127.0.0.1:9000", true},
+	} {
+		if match := matchOrigin(test.pattern, test.origin); match != test.match {
+			t.Errorf("expected %q matching %q to be %v", test.pattern, test.origin, test.match)
+		}
+	}
+}
+
+func TestOrigins(t *testing.T) {
+	origins := ParseOrigins(" https://Example.com/, http://localhost:*,, ")
+	if len(origins) != 2 || origins[0] != "https://example.com" {
+		t.Fatalf("expected 2 normalized origins, got %q", origins)
+	}
+	if !origins.Allow("HTTPS://EXAMPLE.COM") || origins.Allow("https://evil.org") {
+		t.Errorf("expected origins to be matched without case")
+	}
+	if !ParseOrigins("*").Allow("https://evil.org") {
+		t.Errorf("expected * to allow any origin")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if !origins.CheckOrigin(r) {
+		t.Errorf("expected request without origin to be accepted")
+	}
+	r.Header.Set("Origin", "https://evil.org")
+	if origins.CheckOrigin(r) {
+		t.Errorf("expected request from another origin to be refused")
+	}
+
+	handler := origins.CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for origin, allowed := range map[string]string{"https://example.com": "https://example.com", "https://evil.org": ""} {
+		r := httptest.NewRequest(http.MethodOptions, "/token", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != allowed {
+			t.Errorf("%s: expected allowed origin %q, got %q", origin, allowed, got)
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	s := newTestServer(t, Options{})
+	origins := ParseOrigins("https://example.com")
+	upgrader := websocket.Upgrader{Subprotocols: []string{PROTOCOL_STAGO}, CheckOrigin: origins.CheckOrigin}
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		s.Handle(c, r.URL.Query().Get("token"))
+	}))
+	defer ts.Close()
+
+	token, _ := s.auth.issue(Claims{SessionId: "test", Role: RolePlayer, Expires: time.Now().Add(time.Hour).Unix()})
+	endpoint := "wss" + strings.TrimPrefix(ts.URL, "https") + "?token=" + url.QueryEscape(token)
+	dialer := websocket.Dialer{
+		Subprotocols:    []string{PROTOCOL_STAGO},
+		TLSClientConfig: ts.Client().Transport.(*http.Transport).TLSClientConfig,
+	}
+
+	header := http.Header{"Origin": {"https://evil.org"}}
+	if _, res, err := dialer.Dial(endpoint, header); err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected connection from another origin to be refused")
+	}
+
+	// Certificate of the server is not trusted by default
+	if _, _, err := (&websocket.Dialer{TLSClientConfig: &tls.Config{}}).Dial(endpoint, nil); err == nil {
+		t.Errorf("expected untrusted certificate to be refused")
+	}
+
+	header = http.Header{"Origin": {"https://example.com"}}
+	c, _, err := dialer.Dial(endpoint, header)
+	if err != nil {
+		t.Fatalf("expected connection over TLS, got %v", err)
+	}
+	defer c.Close()
+	sendJSON(t, c, &pb.ClientMessage{Id: 1, Message: &pb.ClientMessage_Ping{Ping: &pb.Ping{Time: 5}}})
+	if msg := receive(t, c); msg.GetPong().GetTime() != 5 {
+		t.Errorf("expected pong over TLS, got %v", msg)
+	}
+}
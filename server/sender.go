@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/geotry/stago/simulation"
+	"github.com/gorilla/websocket"
+)
+
+// Number of frames waiting to be written to a client
+const SEND_QUEUE_DEPTH = 2
+
+// Lowest frame rate of a session throttled by its write latency
+const MIN_FPS = 10
+
+// Number of frames written between two frame rate adjustments
+const PACING_FRAMES = 30
+
+// Connection the frames are written to
+type messageWriter interface {
+	WriteMessage(messageType int, data []byte) error
+}
+
+// Writes the frames of a session to its client. Frames are deltas since
+// the last acknowledged frame, so when the client falls behind the oldest
+// frame waiting is skipped for the newest one. The frame rate of the session
+// is lowered when writes are slow or frames are skipped, and raised back
+// up to the frame rate requested by the client when writes are fast.
+type sender struct {
+	conn    messageWriter
+	session *simulation.Session
+	queue   chan []byte
+
+	// Frames skipped since last adjustment
+	skipped atomic.Int32
+	// Average write latency
+	latency time.Duration
+	writes  int
+}
+
+func newSender(conn messageWriter, session *simulation.Session) *sender {
+	return &sender{
+		conn:    conn,
+		session: session,
+		queue:   make(chan []byte, SEND_QUEUE_DEPTH),
+	}
+}
+
+// Queue a frame, skipping the oldest frame if the queue is full.
+// The frame is copied.
+func (s *sender) push(frame []byte) {
+	frame = bytes.Clone(frame)
+	for {
+		select {
+		case s.queue <- frame:
+			return
+		default:
+		}
+		select {
+		case <-s.queue:
+			s.session.FrameDropped()
+			s.skipped.Add(1)
+		default:
+		}
+	}
+}
+
+// Write queued frames until ctx is done
+func (s *sender) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case frame := <-s.queue:
+			start := time.Now()
+			if err := s.conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+				log.Printf("[render] session_id=%s write error=%v", s.session.Id, err)
+				s.session.FrameDropped()
+				continue
+			}
+			s.session.FrameSent(len(frame))
+			s.adapt(time.Since(start))
+		}
+	}
+}
+
+// Adjust the frame rate of the session to the write latency
+func (s *sender) adapt(latency time.Duration) {
+	if s.writes == 0 {
+		s.latency = latency
+	} else {
+		s.latency += (latency - s.latency) / 8
+	}
+	s.writes++
+	if s.writes%PACING_FRAMES != 0 {
+		return
+	}
+
+	fps, maxFps := s.session.Fps()
+	if fps == 0 {
+		return
+	}
+	interval := time.Second / time.Duration(fps)
+	skipped := s.skipped.Swap(0)
+
+	next := fps
+	switch {
+	case skipped > 0 || s.latency > interval/2:
+		next = max(MIN_FPS, fps*3/4)
+	case s.latency < interval/4 && fps < maxFps:
+		next = fps + fps/4 + 1
+	}
+	if next == fps {
+		return
+	}
+
+	next = s.session.Throttle(next)
+	log.Printf("[render] session_id=%s fps=%d max_fps=%d latency=%v skipped=%d", s.session.Id, next, maxFps, s.latency, skipped)
+}
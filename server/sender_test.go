@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+	"github.com/geotry/stago/simulation"
+)
+
+// Connection recording written frames
+type fakeWriter struct {
+	frames chan []byte
+	err    error
+}
+
+func (w *fakeWriter) WriteMessage(messageType int, data []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.frames <- data
+	return nil
+}
+
+func newTestSession(t *testing.T) *simulation.Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	sim := simulation.NewSimulation(rendering.NewResourceManager(), simulation.SimulationOptions{Clock: scene.NewManualClock(time.Unix(0, 0))})
+	sim.AddScene(scene.NewScene(scene.SceneOptions{Name: "test", Camera: &scene.CameraSettings{Projection: scene.Perspective}}))
+	sim.Start(ctx)
+
+	session, _, err := sim.OpenSession("test", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(session.Ticker.Stop)
+	return session
+}
+
+func TestSenderQueueFull(t *testing.T) {
+	session := newTestSession(t)
+	s := newSender(&fakeWriter{}, session)
+
+	for i := range SEND_QUEUE_DEPTH + 1 {
+		s.push([]byte{byte(i)})
+	}
+	if stats := session.Stats(); stats.FramesDropped != 1 {
+		t.Errorf("expected oldest frame dropped, got %d dropped", stats.FramesDropped)
+	}
+	if skipped := s.skipped.Load(); skipped != 1 {
+		t.Errorf("expected 1 frame skipped, got %d", skipped)
+	}
+	for i := range SEND_QUEUE_DEPTH {
+		if frame := <-s.queue; frame[0] != byte(i+1) {
+			t.Errorf("expected newest frames queued, got frame %d", frame[0])
+		}
+	}
+}
+
+func TestSenderRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	session := newTestSession(t)
+	w := &fakeWriter{frames: make(chan []byte, 1)}
+	s := newSender(w, session)
+	go s.run(ctx)
+
+	frame := []byte{1, 2, 3}
+	s.push(frame)
+	frame[0] = 0
+	if written := <-w.frames; !bytes.Equal(written, []byte{1, 2, 3}) {
+		t.Errorf("expected copy of the frame written, got %v", written)
+	}
+	for session.Stats().FramesSent != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if sent := session.Stats().BytesSent; sent != 3 {
+		t.Errorf("expected 3 bytes sent, got %d", sent)
+	}
+}
+
+func TestSenderWriteError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	session := newTestSession(t)
+	s := newSender(&fakeWriter{err: errors.New("closed")}, session)
+	go s.run(ctx)
+
+	s.push([]byte{1})
+	for session.Stats().FramesDropped != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if sent := session.Stats().FramesSent; sent != 0 {
+		t.Errorf("expected no frame sent, got %d", sent)
+	}
+}
+
+func TestSenderAdapt(t *testing.T) {
+	session := newTestSession(t)
+	s := newSender(&fakeWriter{}, session)
+
+	// Write latency over half the frame interval
+	session.SetFps(60)
+	for range PACING_FRAMES {
+		s.adapt(10 * time.Millisecond)
+	}
+	if fps, _ := session.Fps(); fps != 45 {
+		t.Errorf("expected fps lowered to 45 by latency, got %d", fps)
+	}
+
+	// Frames skipped while writes are fast
+	s.skipped.Add(1)
+	for range PACING_FRAMES {
+		s.adapt(time.Millisecond)
+	}
+	if fps, _ := session.Fps(); fps != 33 {
+		t.Errorf("expected fps lowered to 33 by skipped frames, got %d", fps)
+	}
+
+	// Frame rate never goes under MIN_FPS
+	for range 10 * PACING_FRAMES {
+		s.adapt(time.Second)
+	}
+	if fps, _ := session.Fps(); fps != MIN_FPS {
+		t.Errorf("expected fps lowered to %d, got %d", MIN_FPS, fps)
+	}
+
+	// Fast writes raise the frame rate up to the requested one
+	for range 20 * PACING_FRAMES {
+		s.adapt(0)
+	}
+	if fps, maxFps := session.Fps(); fps != maxFps {
+		t.Errorf("expected fps raised to %d, got %d", maxFps, fps)
+	}
+}
+
+func TestSenderAdaptStopped(t *testing.T) {
+	session := newTestSession(t)
+	s := newSender(&fakeWriter{}, session)
+
+	session.SetFps(30)
+	session.SetFps(-1)
+	s.skipped.Add(1)
+	for range PACING_FRAMES {
+		s.adapt(time.Second)
+	}
+	if fps, _ := session.Fps(); fps != 0 {
+		t.Errorf("expected stopped session to stay stopped, got fps %d", fps)
+	}
+	if skipped := s.skipped.Load(); skipped != 1 {
+		t.Errorf("expected skipped frames kept while stopped, got %d", skipped)
+	}
+}
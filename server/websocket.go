@@ -2,140 +2,369 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"io"
 	"log"
 	"math"
+	"net/http"
+	"time"
 
-	"github.com/geotry/stago/examples"
+	"github.com/geotry/stago/encoding"
 	"github.com/geotry/stago/pb"
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
 	"github.com/geotry/stago/simulation"
 	"github.com/gorilla/websocket"
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
-// Create scene and renderer
-var scene, rm = examples.NewDemo()
-var simu = simulation.NewSimulation(rm)
+var ErrNoScene = errors.New("no scene to simulate")
 
-type WebsocketServer struct{}
+type Options struct {
+	// Simulation of the scenes. If nil, a simulation of the scenes of
+	// Registry is created, and started until Close() is called.
+	Simulation *simulation.Simulation
+	// Metrics of the simulation, created if nil. A Simulation given in
+	// Options must call ObserveTick() on each tick to record tick durations.
+	Metrics *Metrics
 
-func NewWebsocketServer() *WebsocketServer {
-	simu.AddScene(scene)
-	simu.Start(context.Background())
+	// Factories of the scenes of the created simulation
+	Registry *scene.Registry
+	// Names of the scenes of Registry to create, all scenes if empty
+	Scenes []string
+	// Options of the created simulation
+	SimulationOptions simulation.SimulationOptions
 
-	return &WebsocketServer{}
+	// Secret signing session tokens, a random secret if empty
+	Secret []byte
+	// Lifetime of session tokens, TOKEN_TTL if 0
+	TokenTTL time.Duration
+	// Key of clients issuing admin tokens, no admin token is issued if empty
+	AdminKey string
+	// Return the user of a token request, for example from a cookie of the
+	// application. If nil, users are identified by their last token.
+	Authenticate func(r *http.Request) (userId string, err error)
 }
 
-func (s *WebsocketServer) Handle(c *websocket.Conn) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+type WebsocketServer struct {
+	sim     *simulation.Simulation
+	metrics *Metrics
+	auth    *auth
+	// See Options.Authenticate
+	authenticate func(r *http.Request) (string, error)
+
+	// Stop the simulation created by the server
+	stop context.CancelFunc
+}
+
+func New(opts Options) (*WebsocketServer, error) {
+	s := &WebsocketServer{
+		sim:     opts.Simulation,
+		metrics: opts.Metrics,
+		auth:    newAuth(opts.Secret, opts.TokenTTL, opts.AdminKey),
+		stop:    func() {},
+
+		authenticate: opts.Authenticate,
+	}
+	if s.metrics == nil {
+		s.metrics = NewMetrics()
+	}
+
+	if s.sim == nil {
+		sim, err := s.newSimulation(opts)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		sim.Start(ctx)
+		s.sim, s.stop = sim, cancel
+	}
+
+	s.metrics.sim = s.sim
+	return s, nil
+}
+
+// Create a simulation of the scenes of the registry
+func (s *WebsocketServer) newSimulation(opts Options) (*simulation.Simulation, error) {
+	if opts.Registry == nil {
+		return nil, ErrNoScene
+	}
+	names := opts.Scenes
+	if len(names) == 0 {
+		names = opts.Registry.Names()
+	}
+	if len(names) == 0 {
+		return nil, ErrNoScene
+	}
+
+	simOpts := opts.SimulationOptions
+	onTick := simOpts.OnTick
+	simOpts.OnTick = func(stats simulation.TickStats) {
+		s.metrics.ObserveTick(stats)
+		if onTick != nil {
+			onTick(stats)
+		}
+	}
+
+	rm := rendering.NewResourceManager()
+	sim := simulation.NewSimulation(rm, simOpts)
+	for _, name := range names {
+		scn, err := opts.Registry.New(name, rm)
+		if err != nil {
+			return nil, err
+		}
+		sim.AddScene(scn)
+	}
+	return sim, nil
+}
+
+// Stop the simulation created by the server
+func (s *WebsocketServer) Close() {
+	s.stop()
+}
+
+// Simulation of the scenes of the server
+func (s *WebsocketServer) Simulation() *simulation.Simulation {
+	return s.sim
+}
+
+// Handler of the metrics of the simulation. Requests must send the admin
+// key or the token of an admin session in the header
+// "Authorization: Bearer <token>".
+func (s *WebsocketServer) Metrics() http.Handler {
+	return s.requireAdmin(s.metrics)
+}
+
+// Record sessions and their input events in w, see simulation.Record()
+func (s *WebsocketServer) Record(w io.Writer) error {
+	return s.sim.Record(w)
+}
+
+// Replay a recording in the scene, see simulation.Replay()
+func (s *WebsocketServer) Replay(r io.Reader) error {
+	return s.sim.Replay(r)
+}
+
+// Handle the messages of a connection according to its subprotocol,
+// for the session of a token issued by Tokens()
+func (s *WebsocketServer) Handle(c *websocket.Conn, token string) error {
+	claims, err := s.auth.verify(token, time.Now())
+	if err != nil {
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+		c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		return err
+	}
 
 	protocol := c.Subprotocol()
+	if protocol == PROTOCOL_STAGO || protocol == PROTOCOL_STAGO_PROTO {
+		return newConnection(s, c, claims).serve()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	for {
-		_, message, err := c.ReadMessage()
+		messageType, message, err := c.ReadMessage()
 		if err != nil {
 			return err
 		}
+		if messageType != clientMessageType(protocol) {
+			return closeConn(c, websocket.CloseUnsupportedData, ErrMessageType)
+		}
 
 		switch protocol {
-		case "render":
-			go s.HandleRender(ctx, c, message)
-		case "input":
-			go s.HandleInput(ctx, c, message)
+		case PROTOCOL_RENDER:
+			go s.HandleRender(ctx, c, claims, messageType, message)
+		case PROTOCOL_INPUT:
+			go s.HandleInput(ctx, c, claims, messageType, message)
 		}
 	}
 }
 
-func (s *WebsocketServer) HandleRender(ctx context.Context, c *websocket.Conn, in []byte) error {
+func (s *WebsocketServer) HandleRender(ctx context.Context, c *websocket.Conn, claims *Claims, messageType int, in []byte) error {
 	var req pb.RenderRequest
 
-	if err := protojson.Unmarshal(in, &req); err != nil {
-		log.Println("[render] json unmarshal error:", err)
+	if err := decode(c.Subprotocol(), messageType, in, &req); err != nil {
+		log.Println("[render] unmarshal error:", err)
 		return err
 	}
 
-	if req.SessionId == "" {
+	// Connection can only render the session of its token
+	req.SessionId, req.UserId = claims.SessionId, claims.UserId
+
+	// Acknowledge frame received by client
+	if req.Ack > 0 {
+		if session := s.sim.GetSession(req.SessionId); session != nil {
+			session.Ack(req.Ack)
+		}
+		return nil
+	}
+
+	// Control time of the scene of the session
+	if req.TimeControl != nil {
+		if !claims.Role.CanControlTime() {
+			return ErrForbidden
+		}
+		session := s.sim.GetSession(req.SessionId)
+		if session == nil {
+			return simulation.ErrSessionNotFound
+		}
+		if err := s.HandleTimeControl(session, req.TimeControl); err != nil {
+			log.Printf("[render] session_id=%s time control error=%v", req.SessionId, err)
+			return err
+		}
 		return nil
 	}
 
-	// Get session
-	session, newSession := simu.OpenSession(req.SessionId, req.UserId)
-	defer simu.CloseSession(session.Id)
+	// Refuse clients decoding frames with another schema
+	if req.SchemaHash != encoding.SchemaHash {
+		log.Printf("[render] session_id=%s schema mismatch client=%#08x server=%#08x", req.SessionId, req.SchemaHash, encoding.SchemaHash)
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "schema mismatch")
+		c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		return ErrSchemaMismatch
+	}
 
-	// Set frame rate
-	session.SetFps(int(req.Fps))
+	// Get session, or resume it after a reconnection
+	session, newSession, err := s.openSession(&req)
+	if err != nil {
+		log.Printf("[render] session_id=%s error=%v", req.SessionId, err)
+		if errors.Is(err, simulation.ErrInvalidToken) || errors.Is(err, simulation.ErrSessionDetached) {
+			msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "invalid resume token")
+			c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		}
+		return err
+	}
+	defer s.sim.CloseSession(session.Id)
 
-	// Update camera settings
-	if req.Width > 0 && req.Height > 0 {
-		session.Root.Camera.SetSize(int(req.Width), int(req.Height))
+	if err := s.configure(session, &req); err != nil {
+		log.Printf("[render] session_id=%s error=%v", session.Id, err)
+		return err
+	}
+
+	// Stop here for existing session
+	if !newSession {
+		return nil
 	}
-	if req.Fov > 0 {
-		session.Root.Camera.SetFov(float64(req.Fov) * (math.Pi / 180))
+
+	// Send the token to resume the session before frames
+	res, err := protojson.Marshal(&pb.RenderResponse{ResumeToken: session.ResumeToken()})
+	if err != nil {
+		return err
 	}
-	if req.Near > 0 {
-		session.Root.Camera.SetNear(float64(req.Near))
+	if err := c.WriteMessage(websocket.TextMessage, res); err != nil {
+		return err
+	}
+
+	sender := newSender(c, session)
+	go sender.run(ctx)
+	s.stream(ctx, session, sender)
+	return nil
+}
+
+// Open the session of a request, or resume it with its token. Returns
+// true if frames of the session must be sent on this connection.
+func (s *WebsocketServer) openSession(req *pb.RenderRequest) (*simulation.Session, bool, error) {
+	if req.ResumeToken != "" {
+		session, err := s.sim.ResumeSession(req.SessionId, req.ResumeToken)
+		if !errors.Is(err, simulation.ErrSessionNotFound) {
+			return session, err == nil, err
+		}
+		// Grace period is over, open a new session
+	}
+	return s.sim.OpenSession(req.SessionId, req.UserId, req.Scene)
+}
+
+// Apply the render options of a request to its session
+func (s *WebsocketServer) configure(session *simulation.Session, req *pb.RenderRequest) error {
+	// Move existing session to another scene
+	if req.Scene != "" && session.Scene().Name != req.Scene {
+		if err := s.sim.MoveSession(session.Id, req.Scene); err != nil {
+			return err
+		}
 	}
-	if req.Far > 0 {
-		session.Root.Camera.SetFar(float64(req.Far))
+
+	// Client has no state
+	if req.Keyframe {
+		session.Keyframe()
 	}
 
-	log.Printf("[render] session_id=%s[%d] near=%.2f far=%.2f fov=%.2f",
+	// Set frame rate
+	session.SetFps(int(req.Fps))
+
+	// Update camera settings
+	session.SetCamera(simulation.CameraOptions{
+		Width:  int(req.Width),
+		Height: int(req.Height),
+		Fov:    float64(req.Fov) * (math.Pi / 180),
+		Near:   float64(req.Near),
+		Far:    float64(req.Far),
+	})
+
+	log.Printf("[render] session_id=%s scene=%s near=%.2f far=%.2f fov=%.2f",
 		session.Id,
-		session.Count,
-		session.Root.Camera.Near,
-		session.Root.Camera.Far,
-		session.Root.Camera.Fov*180.0/math.Pi,
+		session.Scene().Name,
+		req.Near,
+		req.Far,
+		req.Fov,
 	)
 
-	// Stop here for existing session
-	if !newSession {
-		return nil
+	return nil
+}
+
+// Render frames of the session until ctx is done
+func (s *WebsocketServer) stream(ctx context.Context, session *simulation.Session, sender *sender) {
+	for {
+		select {
+		case <-ctx.Done():
+			stats := session.Stats()
+			log.Printf("[ws] client disconnected session_id=%s frames_sent=%d frames_dropped=%d bytes_sent=%d", session.Id, stats.FramesSent, stats.FramesDropped, stats.BytesSent)
+			return
+		case <-session.Ticker.C:
+			sender.push(session.Render())
+		}
 	}
+}
 
-	go func() {
-		for {
-			select {
-			case <-session.Closed:
-				return
-			case <-session.Ticker.C:
-				if err := c.WriteMessage(websocket.BinaryMessage, session.Render()); err != nil {
-					log.Printf("error=%v", err)
-				}
-
-				if err := ctx.Err(); err != nil {
-					if err.Error() != "context canceled" {
-						log.Printf("error=%v", err)
-					} else {
-						log.Print("[ws] client disconnected")
-					}
-					session.Ticker.Stop()
-					session.Closed <- struct{}{}
-				}
-			}
+func (s *WebsocketServer) HandleTimeControl(session *simulation.Session, control *pb.TimeControl) error {
+	name := session.Scene().Name
+
+	if control.TimeScale > 0 {
+		if err := s.sim.SetTimeScale(name, float64(control.TimeScale)); err != nil {
+			return err
 		}
-	}()
+	}
 
-	<-session.Closed
+	switch {
+	case control.Pause:
+		return s.sim.Pause(name)
+	case control.Resume:
+		return s.sim.Resume(name)
+	case control.Step > 0:
+		return s.sim.Step(name, int(control.Step))
+	}
 
 	return nil
 }
 
-func (s *WebsocketServer) HandleInput(ctx context.Context, c *websocket.Conn, in []byte) error {
+func (s *WebsocketServer) HandleInput(ctx context.Context, c *websocket.Conn, claims *Claims, messageType int, in []byte) error {
+	if !claims.Role.CanInput() {
+		return ErrForbidden
+	}
+
 	var req pb.InputEvent
 
-	if err := protojson.Unmarshal(in, &req); err != nil {
-		log.Println("[input] json unmarshal error:", err)
+	if err := decode(c.Subprotocol(), messageType, in, &req); err != nil {
+		log.Println("[input] unmarshal error:", err)
 		return err
 	}
 
-	session := simu.GetSession(req.SessionId)
-	if session == nil {
-		log.Println("[input] invalid session ", req.SessionId)
-		return fmt.Errorf("session does not exist")
-	}
+	// Events can only move the session of the token
+	req.SessionId = claims.SessionId
 
-	scene.ReceiveInput(&req, session.Root)
+	if err := s.sim.ReceiveInput(req.SessionId, &req); err != nil {
+		log.Printf("[input] session_id=%s error=%v", req.SessionId, err)
+		return err
+	}
 
 	return nil
 }
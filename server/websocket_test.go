@@ -0,0 +1,102 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/pb"
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+	"github.com/geotry/stago/simulation"
+)
+
+func newTestRegistry(names ...string) *scene.Registry {
+	registry := scene.NewRegistry()
+	for _, name := range names {
+		registry.Register(name, func(rm *rendering.ResourceManager) *scene.Scene {
+			return scene.NewScene(scene.SceneOptions{Camera: &scene.CameraSettings{Projection: scene.Perspective}})
+		})
+	}
+	return registry
+}
+
+// Server of a simulation run with RunTicks()
+func newTestServer(t *testing.T, opts Options) *WebsocketServer {
+	if opts.Registry == nil {
+		opts.Registry = newTestRegistry("test")
+	}
+	opts.SimulationOptions.Clock = scene.NewManualClock(time.Unix(0, 0))
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Options{}); !errors.Is(err, ErrNoScene) {
+		t.Errorf("expected ErrNoScene without registry, got %v", err)
+	}
+	if _, err := New(Options{Registry: scene.NewRegistry()}); !errors.Is(err, ErrNoScene) {
+		t.Errorf("expected ErrNoScene with empty registry, got %v", err)
+	}
+	if _, err := New(Options{Registry: newTestRegistry("a"), Scenes: []string{"a", "b"}}); !errors.Is(err, scene.ErrUnknownScene) {
+		t.Errorf("expected ErrUnknownScene, got %v", err)
+	}
+
+	var ticks []uint32
+	s := newTestServer(t, Options{
+		Registry: newTestRegistry("a", "b", "c"),
+		Scenes:   []string{"c", "a"},
+		SimulationOptions: simulation.SimulationOptions{
+			OnTick: func(stats simulation.TickStats) {
+				ticks = append(ticks, stats.Tick)
+			},
+		},
+	})
+
+	stats := s.Simulation().RunTicks(2)
+	if len(ticks) != 2 || len(stats) != 2 {
+		t.Errorf("expected OnTick of options called on each tick, got %v", ticks)
+	}
+	if n := s.metrics.ticks; n != 2 {
+		t.Errorf("expected ticks observed by metrics, got %d", n)
+	}
+	if scenes := stats[1].Scenes; len(scenes) != 2 || scenes[0].Name != "c" || scenes[1].Name != "a" {
+		t.Errorf("expected scenes c and a, got %+v", scenes)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	s := newTestServer(t, Options{Registry: newTestRegistry("a", "b")})
+	sim := s.Simulation()
+
+	session, _, err := sim.OpenSession("test", "", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &pb.RenderRequest{Scene: "b", Fps: 30, Width: 200, Height: 100, Fov: 90, Near: 0.5, Far: 50}
+	if err := s.configure(session, req); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if name := session.Scene().Name; name != "b" {
+		t.Errorf("expected session moved to scene b, got %s", name)
+	}
+	if fps, _ := session.Fps(); fps != 30 {
+		t.Errorf("expected fps 30, got %d", fps)
+	}
+
+	// Camera is changed by the main loop
+	sim.RunTicks(1)
+	camera := session.Root.Camera
+	if camera.AspectRatio != 2 || camera.Near != 0.5 || camera.Far != 50 || math.Abs(camera.Fov-math.Pi/2) > 1e-6 {
+		t.Errorf("expected camera settings applied, got aspect ratio %v near %v far %v fov %v", camera.AspectRatio, camera.Near, camera.Far, camera.Fov)
+	}
+
+	if err := s.configure(session, &pb.RenderRequest{Scene: "missing"}); !errors.Is(err, simulation.ErrSceneNotFound) {
+		t.Errorf("expected ErrSceneNotFound, got %v", err)
+	}
+}
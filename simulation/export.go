@@ -0,0 +1,180 @@
+package simulation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/geotry/stago/scene"
+)
+
+var ErrAssetNotFound = errors.New("asset not found")
+
+// Assets of a scene which can be exported
+type Assets struct {
+	Scene    string        `json:"scene"`
+	Textures []TextureInfo `json:"textures"`
+	Objects  []ObjectInfo  `json:"objects"`
+}
+
+// Description of a scene object with nodes in a scene
+type ObjectInfo struct {
+	Id       int32  `json:"id"`
+	Name     string `json:"name"`
+	Vertices int    `json:"vertices"`
+}
+
+// List the texture groups and scene objects of the scene with this name,
+// or the default scene if name is empty
+func (s *Simulation) Assets(sceneName string) (*Assets, error) {
+	var assets *Assets
+	err := s.export(sceneName, func(w *world) error {
+		assets = &Assets{Scene: w.scene.Name, Textures: w.state.Textures(), Objects: make([]ObjectInfo, 0)}
+		objects := sceneObjects(w.scene)
+		for _, id := range slices.Sorted(maps.Keys(objects)) {
+			o := objects[id]
+			assets.Objects = append(assets.Objects, ObjectInfo{Id: o.Id, Name: o.Name, Vertices: len(o.Shape.Geometry)})
+		}
+		return nil
+	})
+	return assets, err
+}
+
+// Write a texture group of the scene in PNG
+func (s *Simulation) ExportTexture(out io.Writer, sceneName string, id int) error {
+	return s.export(sceneName, func(w *world) error {
+		img, err := w.state.GetTexture(id)
+		if err != nil {
+			return fmt.Errorf("export texture %d: %w: %w", id, ErrAssetNotFound, err)
+		}
+		return png.Encode(out, img)
+	})
+}
+
+// Write the mesh of a scene object with nodes in the scene in Wavefront OBJ
+func (s *Simulation) ExportObject(out io.Writer, sceneName string, id int32) error {
+	return s.export(sceneName, func(w *world) error {
+		o := sceneObjects(w.scene)[id]
+		if o == nil {
+			return fmt.Errorf("export object %d: %w", id, ErrAssetNotFound)
+		}
+		return writeObj(out, o)
+	})
+}
+
+// Write the texture groups and the meshes of scene objects of all
+// scenes in dir, in a folder per scene
+func (s *Simulation) ExportAssets(dir string) error {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.worlds))
+	for _, w := range s.worlds {
+		names = append(names, w.scene.Name)
+	}
+	s.mu.Unlock()
+
+	for _, name := range names {
+		assets, err := s.Assets(name)
+		if err != nil {
+			return err
+		}
+		sceneDir := filepath.Join(dir, name)
+		if err := os.MkdirAll(sceneDir, 0o755); err != nil {
+			return fmt.Errorf("export assets: %w", err)
+		}
+		for _, texture := range assets.Textures {
+			path := filepath.Join(sceneDir, fmt.Sprintf("texture-%d.png", texture.Id))
+			if err := exportFile(path, func(f io.Writer) error { return s.ExportTexture(f, name, texture.Id) }); err != nil {
+				return err
+			}
+		}
+		for _, o := range assets.Objects {
+			path := filepath.Join(sceneDir, fmt.Sprintf("object-%d.obj", o.Id))
+			if err := exportFile(path, func(f io.Writer) error { return s.ExportObject(f, name, o.Id) }); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func exportFile(path string, write func(f io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("export %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := write(f); err != nil {
+		return fmt.Errorf("export %s: %w", path, err)
+	}
+	return nil
+}
+
+// Run fn with the world of a scene between two ticks
+func (s *Simulation) export(sceneName string, fn func(w *world) error) error {
+	return s.run(func() error {
+		s.mu.Lock()
+		w := s.findWorld(sceneName)
+		s.mu.Unlock()
+
+		if w == nil {
+			return fmt.Errorf("scene %q: %w", sceneName, ErrSceneNotFound)
+		}
+		return fn(w)
+	})
+}
+
+// Scene objects of the nodes of a scene, by id
+func sceneObjects(scn *scene.Scene) map[int32]*scene.SceneObject {
+	objects := make(map[int32]*scene.SceneObject)
+	for _, node := range scn.Objects() {
+		objects[node.Object.Id] = node.Object
+	}
+	return objects
+}
+
+// Write the shape of a scene object as a list of triangles
+func writeObj(out io.Writer, o *scene.SceneObject) error {
+	w := bufio.NewWriter(out)
+
+	fmt.Fprintf(w, "# stago scene object %d\n", o.Id)
+	if o.Name != "" {
+		fmt.Fprintf(w, "o %s\n", o.Name)
+	}
+	for _, v := range o.Shape.Geometry {
+		fmt.Fprintf(w, "v %g %g %g\n", v.X, v.Y, v.Z)
+	}
+	for _, vt := range o.Shape.Texture {
+		fmt.Fprintf(w, "vt %g %g\n", vt.X, vt.Y)
+	}
+	for _, vn := range o.Shape.Normals {
+		fmt.Fprintf(w, "vn %g %g %g\n", vn.X, vn.Y, vn.Z)
+	}
+
+	hasTexture := len(o.Shape.Texture) == len(o.Shape.Geometry)
+	hasNormals := len(o.Shape.Normals) == len(o.Shape.Geometry)
+	for i := 0; i+2 < len(o.Shape.Geometry); i += 3 {
+		w.WriteString("f")
+		for j := i + 1; j <= i+3; j++ {
+			switch {
+			case hasTexture && hasNormals:
+				fmt.Fprintf(w, " %d/%d/%d", j, j, j)
+			case hasTexture:
+				fmt.Fprintf(w, " %d/%d", j, j)
+			case hasNormals:
+				fmt.Fprintf(w, " %d//%d", j, j)
+			default:
+				fmt.Fprintf(w, " %d", j)
+			}
+		}
+		w.WriteString("\n")
+	}
+
+	return w.Flush()
+}
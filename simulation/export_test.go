@@ -0,0 +1,58 @@
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/compute"
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+)
+
+func TestExportAssets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rm := rendering.NewResourceManager()
+	scn := scene.NewScene(scene.SceneOptions{Name: "test"})
+	cube := scene.NewObject(scene.SceneObjectArgs{Name: "cube", Shape: compute.NewCube()})
+	scn.Spawn(cube, scene.SpawnArgs{})
+
+	sim := NewSimulation(rm, SimulationOptions{Clock: scene.NewManualClock(time.Unix(0, 0))})
+	sim.AddScene(scn)
+	sim.Start(ctx)
+	sim.RunTicks(1)
+
+	assets, err := sim.Assets("test")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(assets.Textures) != 3 || len(assets.Objects) != 1 || assets.Objects[0].Name != "cube" {
+		t.Fatalf("expected 3 textures and the cube, got %+v", assets)
+	}
+
+	var buf bytes.Buffer
+	if err := sim.ExportTexture(&buf, "test", rm.Palette.Group.Id); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if img, err := png.Decode(&buf); err != nil || img.Bounds().Dx() != 256 {
+		t.Errorf("expected palette in PNG, got error %v", err)
+	}
+
+	buf.Reset()
+	if err := sim.ExportObject(&buf, "test", cube.Id); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if faces := strings.Count(buf.String(), "\nf "); faces != 12 {
+		t.Errorf("expected 12 faces in cube mesh, got %d", faces)
+	}
+
+	if err := sim.ExportObject(&buf, "test", cube.Id+1); !errors.Is(err, ErrAssetNotFound) {
+		t.Errorf("expected ErrAssetNotFound, got %v", err)
+	}
+}
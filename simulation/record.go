@@ -0,0 +1,256 @@
+package simulation
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/geotry/stago/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Version of the recording format
+const RECORDING_VERSION = 1
+
+var (
+	ErrRecordingVersion  = errors.New("unsupported recording version")
+	ErrRecordingTickRate = errors.New("recording has a different tick rate")
+)
+
+type RecordType string
+
+const (
+	RecordOpen   RecordType = "open"
+	RecordClose  RecordType = "close"
+	RecordMove   RecordType = "move"
+	RecordInput  RecordType = "input"
+	RecordResume RecordType = "resume"
+)
+
+// An event received by the simulation between two ticks
+type Record struct {
+	// Number of ticks run since the recording started
+	Tick      uint32
+	Type      RecordType
+	SessionId string
+	// User of the session, for RecordOpen
+	UserId string
+	// Name of the scene of the session
+	Scene string
+	// Input event, for RecordInput
+	Event *pb.InputEvent
+}
+
+// First line of a recording
+type recordingHeader struct {
+	Version  int              `json:"version"`
+	TickRate int              `json:"tick_rate"`
+	Scenes   []recordingScene `json:"scenes"`
+}
+
+type recordingScene struct {
+	Name string `json:"name"`
+	Seed uint64 `json:"seed"`
+}
+
+// Line of a recording after the header
+type recordLine struct {
+	Tick      uint32          `json:"tick"`
+	Type      RecordType      `json:"type"`
+	SessionId string          `json:"session_id"`
+	UserId    string          `json:"user_id,omitempty"`
+	Scene     string          `json:"scene,omitempty"`
+	Event     json.RawMessage `json:"event,omitempty"`
+}
+
+type recorder struct {
+	enc *json.Encoder
+	// Tick of the simulation when recording started
+	start uint32
+}
+
+// Write sessions opened, moved, closed and resumed, and their input events in w,
+// one JSON object per line, until StopRecording() is called. Scenes are
+// seeded again to be replayed with the same randomness.
+// A recording started on the first tick replays into fresh scenes,
+// otherwise it replays from a Snapshot() taken at the same time.
+func (s *Simulation) Record(w io.Writer) error {
+	return s.run(func() error {
+		return s.startRecording(w)
+	})
+}
+
+func (s *Simulation) startRecording(w io.Writer) error {
+	header := recordingHeader{Version: RECORDING_VERSION, TickRate: s.tickRate}
+	for _, w := range s.worlds {
+		seed := w.scene.Rand().Uint64()
+		w.scene.Reseed(seed)
+		header.Scenes = append(header.Scenes, recordingScene{Name: w.scene.Name, Seed: seed})
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return fmt.Errorf("record header: %w", err)
+	}
+	s.recorder = &recorder{enc: enc, start: s.tick.Load()}
+	return nil
+}
+
+// Stop writing events started by Record()
+func (s *Simulation) StopRecording() {
+	s.run(func() error {
+		s.stopRecording()
+		return nil
+	})
+}
+
+func (s *Simulation) stopRecording() {
+	s.recorder = nil
+}
+
+// Write an event in the recording. Recording stops on first error.
+func (s *Simulation) record(r Record) {
+	if s.recorder == nil {
+		return
+	}
+
+	line := recordLine{
+		Tick:      s.tick.Load() - s.recorder.start,
+		Type:      r.Type,
+		SessionId: r.SessionId,
+		UserId:    r.UserId,
+		Scene:     r.Scene,
+	}
+	if r.Event != nil {
+		event, err := protojson.Marshal(r.Event)
+		if err != nil {
+			log.Printf("record %s of session %s: %v", r.Type, r.SessionId, err)
+			return
+		}
+		line.Event = event
+	}
+
+	if err := s.recorder.enc.Encode(line); err != nil {
+		log.Printf("recording stopped: %v", err)
+		s.recorder = nil
+	}
+}
+
+// Read a recording written by Record()
+func ReadRecording(r io.Reader) (tickRate int, seeds map[string]uint64, records []Record, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return 0, nil, nil, fmt.Errorf("read recording header: %w", err)
+		}
+		return 0, nil, nil, fmt.Errorf("read recording header: %w", io.ErrUnexpectedEOF)
+	}
+	var header recordingHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return 0, nil, nil, fmt.Errorf("read recording header: %w", err)
+	}
+	if header.Version != RECORDING_VERSION {
+		return 0, nil, nil, fmt.Errorf("read recording version %d: %w", header.Version, ErrRecordingVersion)
+	}
+
+	seeds = make(map[string]uint64, len(header.Scenes))
+	for _, scn := range header.Scenes {
+		seeds[scn.Name] = scn.Seed
+	}
+
+	for n := 2; scanner.Scan(); n++ {
+		var line recordLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return 0, nil, nil, fmt.Errorf("read recording line %d: %w", n, err)
+		}
+		record := Record{Tick: line.Tick, Type: line.Type, SessionId: line.SessionId, UserId: line.UserId, Scene: line.Scene}
+		if len(line.Event) > 0 {
+			record.Event = &pb.InputEvent{}
+			if err := protojson.Unmarshal(line.Event, record.Event); err != nil {
+				return 0, nil, nil, fmt.Errorf("read recording line %d: %w", n, err)
+			}
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, nil, fmt.Errorf("read recording: %w", err)
+	}
+
+	return header.TickRate, seeds, records, nil
+}
+
+// Feed the events of a recording written by Record() back into the
+// simulation, at the same ticks counted from now. Scenes are matched by
+// name and seeded as when the recording started.
+func (s *Simulation) Replay(r io.Reader) error {
+	tickRate, seeds, records, err := ReadRecording(r)
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	if tickRate != s.tickRate {
+		return fmt.Errorf("replay %d ticks/s in %d ticks/s: %w", tickRate, s.tickRate, ErrRecordingTickRate)
+	}
+
+	// Main loop does not wait for the store of users
+	users := make(map[string]*UserState)
+	for _, r := range records {
+		if _, ok := users[r.UserId]; r.Type == RecordOpen && !ok {
+			users[r.UserId] = s.loadUser(r.UserId)
+		}
+	}
+
+	return s.run(func() error {
+		return s.startReplay(seeds, records, users)
+	})
+}
+
+func (s *Simulation) startReplay(seeds map[string]uint64, records []Record, users map[string]*UserState) error {
+	s.mu.Lock()
+	for name := range seeds {
+		if s.findWorld(name) == nil {
+			s.mu.Unlock()
+			return fmt.Errorf("replay scene %q: %w", name, ErrSceneNotFound)
+		}
+	}
+	for name, seed := range seeds {
+		s.findWorld(name).scene.Reseed(seed)
+	}
+	s.mu.Unlock()
+
+	s.replay = records
+	s.replayUsers = users
+	s.replayStart = s.tick.Load()
+	s.replayTick()
+	return nil
+}
+
+// Apply the records of the replay due before the next tick
+func (s *Simulation) replayTick() {
+	tick := s.tick.Load() - s.replayStart
+	for len(s.replay) > 0 && s.replay[0].Tick <= tick {
+		r := s.replay[0]
+		s.replay = s.replay[1:]
+
+		var err error
+		switch r.Type {
+		case RecordOpen:
+			_, _, err = s.openSession(r.SessionId, r.UserId, r.Scene, s.replayUsers[r.UserId])
+		case RecordClose:
+			s.closeSession(r.SessionId)
+		case RecordMove:
+			err = s.moveSession(r.SessionId, r.Scene)
+		case RecordResume:
+			_, err = s.resumeSession(r.SessionId)
+		case RecordInput:
+			err = s.receiveInput(r.SessionId, r.Event)
+		}
+		if err != nil {
+			log.Printf("replay tick=%d %s of session %s: %v", r.Tick, r.Type, r.SessionId, err)
+		}
+	}
+}
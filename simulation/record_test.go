@@ -0,0 +1,183 @@
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/compute"
+	"github.com/geotry/stago/pb"
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+)
+
+func TestRecordReplay(t *testing.T) {
+	// Run a scene in which the camera moves randomly on input
+	run := func(fn func(sim *Simulation, tick func(n int))) *scene.Scene {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		clock := scene.NewManualClock(time.Unix(0, 0))
+		scn := scene.NewScene(scene.SceneOptions{
+			Name:   "test",
+			Clock:  clock,
+			Camera: &scene.CameraSettings{Projection: scene.Perspective},
+			CameraController: &scene.SceneObjectController{
+				Input: func(self *scene.Node, event *pb.InputEvent) {
+					if event.Pressed {
+						self.Transform.Position.X += self.Scene.Rand().Float64()
+					}
+				},
+			},
+		})
+		sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 100, Clock: clock})
+		sim.AddScene(scn)
+		sim.Start(ctx)
+
+		// Scene is added on next iteration of the main loop
+		for sim.Pause("test") != nil {
+			time.Sleep(time.Millisecond)
+		}
+		sim.Resume("test")
+
+		tick := func(n int) {
+			for range n {
+				target := sim.Tick() + 1
+				clock.Advance(10 * time.Millisecond)
+				for sim.Tick() < target {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}
+		fn(sim, tick)
+		return scn
+	}
+
+	position := func(scn *scene.Scene) compute.Vector3 {
+		for _, node := range scn.Objects() {
+			if node.Camera != nil {
+				return node.Transform.Position
+			}
+		}
+		return compute.Vector3{}
+	}
+
+	var recording bytes.Buffer
+	recorded := run(func(sim *Simulation, tick func(n int)) {
+		if err := sim.Record(&recording); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		sim.OpenSession("player", "alice", "")
+		tick(2)
+		for range 3 {
+			sim.ReceiveInput("player", &pb.InputEvent{SessionId: "player", Device: pb.InputDevice_KEYBOARD, Pressed: true})
+			tick(3)
+		}
+		sim.StopRecording()
+	})
+
+	replayed := run(func(sim *Simulation, tick func(n int)) {
+		if err := sim.Replay(bytes.NewReader(recording.Bytes())); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		tick(11)
+		if session := sim.GetSession("player"); session == nil || session.UserId != "alice" {
+			t.Errorf("expected session replayed for its user")
+		}
+	})
+
+	if p := position(recorded); p.X == 0 {
+		t.Fatalf("expected camera to move on input")
+	}
+	if p, q := position(recorded), position(replayed); p != q {
+		t.Errorf("expected replayed camera at %v, got %v", p, q)
+	}
+
+	other := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 60})
+	if err := other.Replay(bytes.NewReader(recording.Bytes())); !errors.Is(err, ErrRecordingTickRate) {
+		t.Errorf("expected ErrRecordingTickRate, got %v", err)
+	}
+	if _, _, _, err := ReadRecording(strings.NewReader(`{"version":0}`)); !errors.Is(err, ErrRecordingVersion) {
+		t.Errorf("expected ErrRecordingVersion, got %v", err)
+	}
+}
+
+func TestReplaySpawn(t *testing.T) {
+	// Two nodes of the camera spawn a ball at a random position from
+	// their offset on input
+	ball := scene.NewObject(scene.SceneObjectArgs{Name: "ball"})
+	hand := scene.NewObject(scene.SceneObjectArgs{
+		Name: "hand",
+		Input: func(self *scene.Node, event *pb.InputEvent) {
+			if event.Pressed {
+				x := self.Data["offset"].(float64) + self.Scene.Rand().Float64()
+				self.Scene.Spawn(ball, scene.SpawnArgs{Position: compute.Point{X: x}})
+			}
+		},
+	})
+
+	run := func(fn func(sim *Simulation)) map[uint32]float64 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		clock := scene.NewManualClock(time.Unix(0, 0))
+		scn := scene.NewScene(scene.SceneOptions{
+			Name:   "test",
+			Clock:  clock,
+			Camera: &scene.CameraSettings{Projection: scene.Perspective},
+			CameraController: &scene.SceneObjectController{
+				Init: func(self *scene.Node) {
+					self.Scene.Spawn(hand, scene.SpawnArgs{Parent: self, Data: map[string]any{"offset": -10.0}})
+					self.Scene.Spawn(hand, scene.SpawnArgs{Parent: self, Data: map[string]any{"offset": 10.0}})
+				},
+			},
+		})
+		sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 100, Clock: clock})
+		sim.AddScene(scn)
+		sim.Start(ctx)
+		fn(sim)
+
+		balls := make(map[uint32]float64)
+		sim.run(func() error {
+			for _, node := range scn.Objects() {
+				if node.Object == ball {
+					balls[node.Id] = node.Transform.Position.X
+				}
+			}
+			return nil
+		})
+		return balls
+	}
+
+	var recording bytes.Buffer
+	recorded := run(func(sim *Simulation) {
+		if err := sim.Record(&recording); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		sim.OpenSession("player", "", "")
+		sim.RunTicks(2)
+		for range 5 {
+			sim.ReceiveInput("player", &pb.InputEvent{SessionId: "player", Device: pb.InputDevice_KEYBOARD, Pressed: true})
+			sim.RunTicks(1)
+		}
+		sim.StopRecording()
+	})
+
+	replayed := run(func(sim *Simulation) {
+		if err := sim.Replay(bytes.NewReader(recording.Bytes())); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		sim.RunTicks(8)
+	})
+
+	if len(recorded) != 10 {
+		t.Fatalf("expected 10 balls spawned, got %d", len(recorded))
+	}
+	if !maps.Equal(recorded, replayed) {
+		t.Errorf("expected balls replayed with the same ids and positions, got %v instead of %v", replayed, recorded)
+	}
+}
@@ -1,81 +1,414 @@
 package simulation
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/geotry/stago/encoding"
 	"github.com/geotry/stago/scene"
 )
 
 type Session struct {
 	Id string
+	// Id of the user of the session, empty if anonymous
+	UserId string
 
-	// Number of opened sessions
+	// Number of opened sessions, 0 when the session waits to be resumed
 	Count int
+	// Tick after which a session waiting to be resumed is deleted
+	expires uint32
 
 	sim *Simulation
+	// Scene of the session and its state
+	world *world
 
 	// The root object attached to this session (the camera)
 	Root *scene.Node
 
 	buffer []byte
+	// Blocks written by the session: frame header and instances left
+	local *encoding.BlockBuffer
+	// Sequence number of the last rendered frame
+	sequence uint32
+	// Recent frames, indexed by sequence number
+	frames [frameHistory]sentFrame
+	// Generation of the state in the last frame acknowledged by client
+	acked uint32
+	// Sequence number of the last frame acknowledged by client
+	ackedSequence uint32
+	// Next frame contains all blocks
+	keyframe bool
+
+	// Instances relevant to the camera, updated every tick
+	relevant map[uint32]bool
+	// Instances sent to the client, with the sequence of the frame they entered
+	entered map[uint32]uint32
+	// Instances which left, with the sequence of the frame they left
+	left map[uint32]uint32
 
 	Ticker *time.Ticker
-	Closed chan struct{}
+	// Values saved with the state of the user, see SetValue()
+	values map[string]string
+
+	// Frame rate of Ticker, and frame rate requested by the client
+	fps    int
+	maxFps int
 
 	readCount int
 
-	objectsSent int
-	instances   map[*scene.Node]bool
+	bytesSent     atomic.Uint64
+	framesSent    atomic.Uint64
+	framesDropped atomic.Uint64
+
+	mu sync.Mutex
 }
 
-func NewSession(id string, simulation *Simulation, root *scene.Node, context any) *Session {
-	return &Session{
+// Frame rate of sessions until the client requests one
+const DEFAULT_FPS = 60
+
+// Number of frames which can be acknowledged by client
+const frameHistory = 256
+
+type sentFrame struct {
+	sequence   uint32
+	generation uint32
+}
+
+func NewSession(id string, userId string, simulation *Simulation, world *world, root *scene.Node) *Session {
+	s := &Session{
 		Id:     id,
+		UserId: userId,
 		sim:    simulation,
+		world:  world,
 		Count:  1,
 		buffer: make([]byte, 1024*1024),
-		Ticker: time.NewTicker(time.Second / time.Duration(60)),
-		Closed: make(chan struct{}),
+		local:  encoding.NewBlockBuffer(1024),
+		Ticker: time.NewTicker(time.Second / time.Duration(DEFAULT_FPS)),
+		fps:    DEFAULT_FPS,
+		maxFps: DEFAULT_FPS,
 		Root:   root,
 
-		objectsSent: 0,
-		instances:   make(map[*scene.Node]bool),
+		keyframe: true,
+		relevant: make(map[uint32]bool),
+		entered:  make(map[uint32]uint32),
+		left:     make(map[uint32]uint32),
 	}
+	// Controllers reach the values of the user from the camera
+	root.UserValues = s
+	return s
 }
 
 func (s *Session) RenderCount() int {
 	return s.readCount
 }
 
+// Set the frame rate requested by the client, which is the highest
+// frame rate of the session. Frames are not rendered anymore if fps is -1.
 func (s *Session) SetFps(fps int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if fps > 0 {
+		s.maxFps = fps
+		s.fps = fps
 		s.Ticker.Reset(time.Second / time.Duration(fps))
 	} else if fps == -1 {
+		s.fps = 0
 		s.Ticker.Stop()
 	}
 }
 
+// Render frames again at the frame rate of the session, unless frames
+// are not rendered anymore
+func (s *Session) restartTicker() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fps > 0 {
+		s.Ticker.Reset(time.Second / time.Duration(s.fps))
+	}
+}
+
+// Change the frame rate of the session without exceeding the frame rate
+// requested by the client, and return the frame rate applied
+func (s *Session) Throttle(fps int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fps == 0 {
+		return 0
+	}
+	fps = max(1, min(fps, s.maxFps))
+	if fps != s.fps {
+		s.fps = fps
+		s.Ticker.Reset(time.Second / time.Duration(fps))
+	}
+	return fps
+}
+
+// Current frame rate of the session, and the frame rate requested by the client
+func (s *Session) Fps() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.fps, s.maxFps
+}
+
+// Count a frame of size bytes written to the client
+func (s *Session) FrameSent(size int) {
+	s.bytesSent.Add(uint64(size))
+	s.framesSent.Add(1)
+}
+
+// Count a frame which could not be written to the client
+func (s *Session) FrameDropped() {
+	s.framesDropped.Add(1)
+}
+
+// Acknowledge the reception of a frame. Next frames only contain
+// blocks changed after the state of this frame.
+func (s *Session) Ack(sequence uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	frame := s.frames[sequence%frameHistory]
+	if frame.sequence == sequence && frame.generation > s.acked {
+		s.acked = frame.generation
+		s.ackedSequence = sequence
+	}
+}
+
+// Send all blocks in next frame, when the client lost its state
+func (s *Session) Keyframe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keyframe = true
+}
+
+// Generation of the state in the last frame acknowledged by client
+func (s *Session) Acked() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.acked
+}
+
+// Scene of the session
+func (s *Session) Scene() *scene.Scene {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.world.scene
+}
+
+func (s *Session) currentWorld() *world {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.world
+}
+
+// Return the world of the session and the generation of its state
+// acknowledged by client
+func (s *Session) acknowledged() (*world, uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.world, s.acked
+}
+
+// Move the camera of the session to the scene of w. The client
+// discards its state on next frame.
+func (s *Session) move(w *world) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.world == w {
+		return
+	}
+
+	root := w.scene.SpawnUserCamera(s.UserId)
+	root.Camera.CopySettings(s.Root.Camera)
+	root.UserValues = s
+	s.Root.Destroy()
+	s.Root = root
+	s.world = w
+
+	// Generations of the previous state are not valid anymore
+	s.keyframe = true
+	s.acked = 0
+	s.ackedSequence = 0
+	clear(s.relevant)
+}
+
+// Bind the session to a restored world with its camera, or a new camera
+// with the settings of the current one if root is nil
+func (s *Session) restore(w *world, root *scene.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if root == nil {
+		root = w.scene.SpawnUserCamera(s.UserId)
+		root.Camera.CopySettings(s.Root.Camera)
+	}
+	root.UserValues = s
+	s.Root = root
+	s.world = w
+
+	s.keyframe = true
+	s.acked = 0
+	s.ackedSequence = 0
+	clear(s.relevant)
+}
+
+func (s *Session) root() *scene.Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Root
+}
+
+// Settings of the camera of a session requested by its client.
+// Settings which are 0 are not changed.
+type CameraOptions struct {
+	Width, Height int
+	// Vertical field of view in radians
+	Fov       float64
+	Near, Far float64
+}
+
+// Apply settings to the camera of the session on next update of its
+// scene, the camera being read by the main loop
+func (s *Session) SetCamera(opts CameraOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	camera := s.Root.Camera
+	if camera == nil {
+		return
+	}
+	s.world.scene.Schedule(func() {
+		if opts.Width > 0 && opts.Height > 0 {
+			camera.SetSize(opts.Width, opts.Height)
+		}
+		if opts.Fov > 0 {
+			camera.SetFov(opts.Fov)
+		}
+		if opts.Near > 0 {
+			camera.SetNear(opts.Near)
+		}
+		if opts.Far > 0 {
+			camera.SetFar(opts.Far)
+		}
+	})
+}
+
+// Update instances relevant to the camera of the session
+func (s *Session) UpdateInterest() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	camera := s.Root.Camera
+
+	clear(s.relevant)
+	for _, node := range s.world.scene.Objects() {
+		if node.Camera != nil || node.Light != nil {
+			continue
+		}
+		if camera == nil || camera.IsRelevant(node) {
+			s.relevant[node.Id] = true
+		}
+	}
+}
+
+// Render a frame with blocks changed since the last acknowledged frame.
+// Instances are sent when they are relevant to the camera, and a block
+// SceneObjectInstanceLeft is sent when they are not relevant anymore.
 func (s *Session) Render() []byte {
-	state := s.sim.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.world.state
 	offset := 0
 
-	stateObjectsCount := len(state.sceneObjects)
+	// Frame is a snapshot of the state, which is not saved meanwhile
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	// Client may have missed tombstones of deleted nodes
+	if s.acked > 0 && s.acked < state.pruned {
+		s.keyframe = true
+	}
+	if s.keyframe {
+		s.acked = 0
+		s.ackedSequence = 0
+		s.frames = [frameHistory]sentFrame{}
+		clear(s.entered)
+		clear(s.left)
+	}
+	since, ackedSequence := s.acked, s.ackedSequence
+
+	s.sequence++
+	s.frames[s.sequence%frameHistory] = sentFrame{sequence: s.sequence, generation: state.generation}
+
+	for id := range s.relevant {
+		if _, ok := s.entered[id]; !ok {
+			s.entered[id] = s.sequence
+			delete(s.left, id)
+		}
+	}
+	for id := range s.entered {
+		if !s.relevant[id] {
+			delete(s.entered, id)
+			s.left[id] = s.sequence
+		}
+	}
+
+	s.local.Reset()
+	header := &encoding.FrameHeader{
+		Version:   encoding.ProtocolVersion,
+		Tick:      s.sim.Tick(),
+		Sequence:  s.sequence,
+		Timestamp: float64(time.Now().UnixMilli()),
+		Keyframe:  s.keyframe,
+	}
+	s.keyframe = false
+	header.Write(s.local)
+	s.local.EndBlock()
 
-	if s.readCount == 0 {
-		offset += state.CopyTextures(s.buffer[offset:])
+	// Notify until the client acknowledges a frame sent after the instance left
+	for id, sequence := range s.left {
+		if sequence <= ackedSequence {
+			delete(s.left, id)
+			continue
+		}
+		left := &encoding.SceneObjectInstanceLeft{Id: uint16(id)}
+		left.Write(s.local)
+		s.local.EndBlock()
 	}
 
-	if stateObjectsCount != s.objectsSent {
-		offset += state.CopySceneObjects(s.buffer[offset:])
-		s.objectsSent = stateObjectsCount
+	// A frame cannot be larger than the state and the session blocks
+	if size := state.buffer.Offset() + s.local.Offset(); size > len(s.buffer) {
+		s.buffer = make([]byte, size)
 	}
 
-	// Check new and old objects
-	offset += state.CopyCamera(s.buffer[offset:], s.Root.Id)
-	offset += state.CopyLights(s.buffer[offset:])
-	offset += state.CopyLightsDeleted(s.buffer[offset:])
-	offset += state.CopySceneObjectInstances(s.buffer[offset:])
-	offset += state.CopySceneObjectInstancesDeleted(s.buffer[offset:])
+	offset += s.local.Copy(s.buffer[offset:])
+
+	offset += copyBlocks(state, s.buffer[offset:], state.textures, since)
+	offset += copyBlocks(state, s.buffer[offset:], state.sceneObjects, since)
+	if b := state.cameras[s.Root.Id]; b != nil && state.generations[b] > since {
+		offset += b.Copy(s.buffer[offset:])
+	}
+	offset += copyBlocks(state, s.buffer[offset:], state.lights, since)
+	offset += copyBlocks(state, s.buffer[offset:], state.lightsDeleted, since)
+	// Instances entered in a frame not acknowledged yet are sent again
+	offset += copyBlocksFunc(state, s.buffer[offset:], state.sceneObjectInstances, func(id uint32, generation uint32) bool {
+		entered, ok := s.entered[id]
+		return ok && (generation > since || entered > ackedSequence)
+	})
+	offset += copyBlocks(state, s.buffer[offset:], state.sceneObjectInstancesDeleted, since)
 
 	s.readCount++
 
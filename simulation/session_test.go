@@ -0,0 +1,303 @@
+package simulation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/compute"
+	"github.com/geotry/stago/encoding"
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+)
+
+func TestSessionInterest(t *testing.T) {
+	scn := scene.NewScene(scene.SceneOptions{
+		Camera: &scene.CameraSettings{
+			Near:       0.01,
+			Far:        100.0,
+			Projection: scene.Perspective,
+		},
+	})
+	camera := scn.SpawnCamera()
+
+	object := scene.NewObject(scene.SceneObjectArgs{})
+	visible := scn.Spawn(object, scene.SpawnArgs{Position: compute.Point{X: 0, Y: 0, Z: 3}})
+	hidden := scn.Spawn(object, scene.SpawnArgs{Position: compute.Point{X: 0, Y: 0, Z: -3}})
+	nodes := []*scene.Node{visible, hidden}
+	// Spawned nodes are added on update
+	scn.Update()
+
+	w := newWorld(scn)
+	session := NewSession("test", "", &Simulation{}, w, camera)
+
+	save := func() {
+		for _, node := range nodes {
+			w.state.WriteSceneObjectInstance(node)
+		}
+		w.state.Commit()
+		session.UpdateInterest()
+	}
+
+	// Returns ids of instances and instances left in frame
+	render := func() (instances []uint16, left []uint16, sequence uint32) {
+		r := encoding.NewBlockReader(session.Render())
+		for r.Next() {
+			switch b := r.Block().(type) {
+			case *encoding.SceneObjectInstance:
+				instances = append(instances, b.Id)
+			case *encoding.SceneObjectInstanceLeft:
+				left = append(left, b.Id)
+			}
+		}
+		if err := r.Err(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return instances, left, r.Header().Sequence
+	}
+
+	save()
+	instances, left, sequence := render()
+	if len(instances) != 1 || instances[0] != uint16(visible.Id) || len(left) != 0 {
+		t.Errorf("expected only visible instance to be sent, got %v (left %v)", instances, left)
+	}
+	session.Ack(sequence)
+
+	// Instance enters the view
+	hidden.Transform.Position.Z = 3
+	save()
+	instances, _, sequence = render()
+	if len(instances) != 1 || instances[0] != uint16(hidden.Id) {
+		t.Errorf("expected entered instance to be sent, got %v", instances)
+	}
+	session.Ack(sequence)
+
+	// Instance leaves the view
+	hidden.Transform.Position.Z = -3
+	save()
+	instances, left, _ = render()
+	if len(left) != 1 || left[0] != uint16(hidden.Id) {
+		t.Errorf("expected left instance to be notified, got %v", left)
+	}
+	if len(instances) != 0 {
+		t.Errorf("expected no instance sent, got %v", instances)
+	}
+
+	// Notification is sent until acknowledged
+	_, left, sequence = render()
+	if len(left) != 1 {
+		t.Errorf("expected left instance to be notified again, got %v", left)
+	}
+	session.Ack(sequence)
+	_, left, _ = render()
+	if len(left) != 0 {
+		t.Errorf("expected no notification after acknowledgement, got %v", left)
+	}
+}
+
+func TestSessionDelta(t *testing.T) {
+	scn := scene.NewScene(scene.SceneOptions{Camera: &scene.CameraSettings{Projection: scene.Perspective}})
+	camera := scn.SpawnCamera()
+
+	object := scene.NewObject(scene.SceneObjectArgs{})
+	a := scn.Spawn(object, scene.SpawnArgs{Position: compute.Point{X: 0, Y: 0, Z: 3}})
+	b := scn.Spawn(object, scene.SpawnArgs{Position: compute.Point{X: 0, Y: 0, Z: 4}})
+	scn.Update()
+
+	w := newWorld(scn)
+	session := NewSession("test", "", &Simulation{}, w, camera)
+
+	save := func(nodes ...*scene.Node) {
+		for _, node := range nodes {
+			w.state.WriteSceneObjectInstance(node)
+		}
+		w.state.Commit()
+		session.UpdateInterest()
+	}
+
+	// Returns ids of instances in frame
+	render := func() (instances []uint16, header *encoding.FrameHeader) {
+		r := encoding.NewBlockReader(session.Render())
+		for r.Next() {
+			if b, ok := r.Block().(*encoding.SceneObjectInstance); ok {
+				instances = append(instances, b.Id)
+			}
+		}
+		if err := r.Err(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return instances, r.Header()
+	}
+
+	save(a, b)
+	instances, header := render()
+	if len(instances) != 2 || !header.Keyframe {
+		t.Fatalf("expected first frame to be a keyframe with all instances, got %v", instances)
+	}
+	session.Ack(header.Sequence)
+
+	// Only changed blocks are sent after an acknowledged frame
+	a.Transform.Position.Z = 2
+	save(a, b)
+	instances, header = render()
+	if len(instances) != 1 || instances[0] != uint16(a.Id) || header.Keyframe {
+		t.Errorf("expected only changed instance to be sent, got %v", instances)
+	}
+
+	// Frame is sent again until acknowledged
+	instances, header = render()
+	if len(instances) != 1 || instances[0] != uint16(a.Id) {
+		t.Errorf("expected unacknowledged instance to be sent again, got %v", instances)
+	}
+	session.Ack(header.Sequence)
+	if instances, _ = render(); len(instances) != 0 {
+		t.Errorf("expected no instance sent after acknowledgement, got %v", instances)
+	}
+
+	// Keyframe resets the state of the client
+	session.Keyframe()
+	instances, header = render()
+	if len(instances) != 2 || !header.Keyframe {
+		t.Errorf("expected keyframe with all instances, got %v", instances)
+	}
+	session.Ack(header.Sequence)
+	acked := session.Acked()
+
+	// Tombstone of deleted instance pruned before being acknowledged
+	w.state.WriteSceneObjectInstanceDeleted(b)
+	w.state.DeleteSceneObjectInstance(b)
+	generation := w.state.Commit()
+	if n := w.state.PruneTombstones(acked, generation+1); n != 1 {
+		t.Fatalf("expected tombstone pruned, got %d", n)
+	}
+	instances, header = render()
+	if !header.Keyframe || len(instances) != 1 || instances[0] != uint16(a.Id) {
+		t.Errorf("expected keyframe when acknowledged state is before pruned tombstones, got %v", instances)
+	}
+}
+
+func TestMoveSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sim := NewSimulation(nil, SimulationOptions{Clock: scene.NewManualClock(time.Unix(0, 0))})
+	for _, name := range []string{"a", "b"} {
+		scn := scene.NewScene(scene.SceneOptions{Name: name, Camera: &scene.CameraSettings{Projection: scene.Perspective}})
+		sim.worlds = append(sim.worlds, newWorld(scn))
+	}
+	sim.Start(ctx)
+
+	session, created, err := sim.OpenSession("test", "", "")
+	if err != nil || !created {
+		t.Fatalf("expected session to be created, got error %v", err)
+	}
+	if name := session.Scene().Name; name != "a" {
+		t.Errorf("expected session in default scene a, got %s", name)
+	}
+	session.Root.Camera.SetFov(1)
+	session.Render()
+
+	if err := sim.MoveSession("test", "c"); !errors.Is(err, ErrSceneNotFound) {
+		t.Errorf("expected ErrSceneNotFound, got %v", err)
+	}
+	if err := sim.MoveSession("test", "b"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if name := session.Scene().Name; name != "b" {
+		t.Errorf("expected session in scene b, got %s", name)
+	}
+	if session.Root.Scene.Name != "b" || session.Root.Camera.Fov != 1 {
+		t.Errorf("expected camera spawned in scene b with the same settings")
+	}
+
+	r := encoding.NewBlockReader(session.Render())
+	r.Next()
+	if header := r.Header(); header == nil || !header.Keyframe {
+		t.Errorf("expected keyframe after session moved")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	scn := scene.NewScene(scene.SceneOptions{Camera: &scene.CameraSettings{Projection: scene.Perspective}})
+	session := NewSession("test", "", nil, newWorld(scn), scn.SpawnCamera())
+	defer session.Ticker.Stop()
+
+	session.SetFps(30)
+	if fps := session.Throttle(20); fps != 20 {
+		t.Errorf("expected fps 20, got %d", fps)
+	}
+	if fps := session.Throttle(60); fps != 30 {
+		t.Errorf("expected fps limited to the requested 30, got %d", fps)
+	}
+	if fps, maxFps := session.Fps(); fps != 30 || maxFps != 30 {
+		t.Errorf("expected fps 30/30, got %d/%d", fps, maxFps)
+	}
+
+	session.SetFps(-1)
+	if fps := session.Throttle(20); fps != 0 {
+		t.Errorf("expected stopped session to not render, got fps %d", fps)
+	}
+}
+
+func TestResumeSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := scene.NewManualClock(time.Unix(0, 0))
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 100, Clock: clock, GracePeriod: 100 * time.Millisecond})
+	scn := scene.NewScene(scene.SceneOptions{Name: "test", Camera: &scene.CameraSettings{Projection: scene.Perspective}})
+	sim.AddScene(scn)
+	sim.Start(ctx)
+
+	session, _, err := sim.OpenSession("test", "", "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	token := session.ResumeToken()
+	root := session.Root
+	sim.RunTicks(1)
+	session.Render()
+
+	sim.CloseSession("test")
+	sim.RunTicks(5)
+	select {
+	case <-session.Ticker.C:
+		t.Errorf("expected no frame rendered for closed session")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if scn.Node(root.Id) == nil {
+		t.Fatalf("expected camera kept during grace period")
+	}
+	if _, _, err := sim.OpenSession("test", "", ""); !errors.Is(err, ErrSessionDetached) {
+		t.Errorf("expected ErrSessionDetached, got %v", err)
+	}
+	if _, err := sim.ResumeSession("test", "invalid"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken, got %v", err)
+	}
+
+	resumed, err := sim.ResumeSession("test", token)
+	if err != nil || resumed != session || resumed.Root != root {
+		t.Fatalf("expected session resumed with its camera, got error %v", err)
+	}
+	select {
+	case <-session.Ticker.C:
+	case <-time.After(time.Second):
+		t.Errorf("expected frames rendered again once resumed")
+	}
+	r := encoding.NewBlockReader(session.Render())
+	r.Next()
+	if header := r.Header(); header == nil || !header.Keyframe {
+		t.Errorf("expected keyframe after session resumed")
+	}
+
+	// Camera is destroyed on the tick after expiration
+	sim.CloseSession("test")
+	sim.RunTicks(11)
+	if sim.GetSession("test") != nil || scn.Node(root.Id) != nil {
+		t.Errorf("expected session and camera deleted after grace period")
+	}
+	if _, err := sim.ResumeSession("test", token); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+}
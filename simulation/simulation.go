@@ -2,76 +2,334 @@ package simulation
 
 import (
 	"context"
-	"image/png"
+	"errors"
+	"fmt"
 	"log"
-	"os"
+	"math"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/geotry/stago/pb"
 	"github.com/geotry/stago/rendering"
 	"github.com/geotry/stago/scene"
 )
 
 type Simulation struct {
-	currentScene *scene.Scene
-	rm           *rendering.ResourceManager
-	state        *State
-	scenes       []*scene.Scene
-	sessions     []*Session
-	queue        chan *scene.Scene
-	dequeue      chan *scene.Scene
-	ticker       *scene.Ticker
-	bench        *scene.Ticker
-	mu           sync.Mutex
+	rm       *rendering.ResourceManager
+	worlds   []*world
+	sessions []*Session
+	queue    chan *scene.Scene
+	dequeue  chan *scene.Scene
+	tasks    chan func()
+	bench    *scene.Ticker
+	mu       sync.Mutex
+
+	// Set once Start() is called
+	started atomic.Bool
+	// Closed when the main loop stops
+	stopped chan struct{}
+
+	clock    scene.Clock
+	tickRate int
+	// Duration of a tick
+	step time.Duration
+
+	// Tick of the last saved state
+	tick atomic.Uint32
+
+	// Called after each tick, see SimulationOptions
+	onTick func(TickStats)
+	// Ticks closed sessions wait to be resumed
+	graceTicks uint32
+	// Key of resume tokens
+	secret []byte
+	// Writer of the state of users, nil to not save them
+	users *userWriter
+	// Ticks between two saves of the users of opened sessions
+	userSaveTicks uint32
+
+	// Events are written in recorder if not nil, see Record()
+	recorder *recorder
+	// Records fed back on their tick, see Replay()
+	replay      []Record
+	replayStart uint32
+	// State of the users of the replay, loaded before it starts
+	replayUsers map[string]*UserState
+
+	// Errors of saveState are reported with statistics to not flood logs
+	saveErrors  int
+	lastSaveErr error
+}
+
+type SimulationOptions struct {
+	// Number of ticks per second, TICKS_PER_SEC if 0
+	TickRate int
+	// Source of time of the main loop, scene.SystemClock if nil.
+	// Scenes advance by a fixed step on each tick whatever the clock.
+	Clock scene.Clock
+	// Called in the main loop with the statistics of each tick,
+	// it must not block
+	OnTick func(TickStats)
+	// Time the nodes of closed sessions are kept for the session to be
+	// resumed, SESSION_GRACE_PERIOD if 0. Sessions are closed at once
+	// if negative.
+	GracePeriod time.Duration
+	// Key of resume tokens, a random key if nil
+	Secret []byte
+	// State of users restored when they open a session, and saved when
+	// their sessions are closed. Users are not saved if nil.
+	Users UserStore
+	// Time between two saves of the users of opened sessions,
+	// USER_SAVE_INTERVAL if 0
+	UserSaveInterval time.Duration
+}
+
+// A scene updated by the simulation, with the state sent to its sessions
+type world struct {
+	scene *scene.Scene
+	state *State
+
+	// Scene is not updated, except for steps
+	paused bool
+	// Number of ticks to run while paused
+	steps int
+	// Factor applied to the time step of the scene
+	timeScale float64
+}
+
+func newWorld(scn *scene.Scene) *world {
+	return &world{scene: scn, state: NewState(), timeScale: 1}
 }
 
+// Default tick rate of simulations
 const TICKS_PER_SEC = 60
 
-func NewSimulation(rm *rendering.ResourceManager) *Simulation {
+// Maximum number of ticks run to catch up with the clock,
+// late ticks are dropped beyond
+const MAX_CATCHUP_TICKS = 5
+
+// Default time closed sessions can be resumed
+const SESSION_GRACE_PERIOD = 30 * time.Second
+
+// Default time between two saves of the users of opened sessions
+const USER_SAVE_INTERVAL = time.Minute
+
+// Duration tombstones of deleted nodes are kept for sessions
+// which did not acknowledge them
+const TOMBSTONE_RETENTION = 10 * time.Second
+
+var (
+	ErrSceneNotFound    = errors.New("scene not found")
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrInvalidTimeScale = errors.New("time scale must be positive")
+	ErrSessionDetached  = errors.New("session is waiting to be resumed")
+	ErrInvalidToken     = errors.New("invalid resume token")
+	ErrStopped          = errors.New("simulation is not running")
+)
+
+func NewSimulation(rm *rendering.ResourceManager, opts SimulationOptions) *Simulation {
+	tickRate := opts.TickRate
+	if tickRate <= 0 {
+		tickRate = TICKS_PER_SEC
+	}
+	clock := opts.Clock
+	if clock == nil {
+		clock = scene.SystemClock
+	}
+	gracePeriod := opts.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = SESSION_GRACE_PERIOD
+	}
+	secret := opts.Secret
+	if secret == nil {
+		secret = newSecret()
+	}
+	userSaveInterval := opts.UserSaveInterval
+	if userSaveInterval <= 0 {
+		userSaveInterval = USER_SAVE_INTERVAL
+	}
+	step := time.Second / time.Duration(tickRate)
+
 	r := &Simulation{
 		rm:       rm,
-		state:    NewState(),
-		scenes:   make([]*scene.Scene, 0),
+		worlds:   make([]*world, 0),
 		sessions: make([]*Session, 0),
 		queue:    make(chan *scene.Scene, 10),
 		dequeue:  make(chan *scene.Scene, 10),
-		ticker:   scene.NewTicker(),
+		tasks:    make(chan func()),
+		stopped:  make(chan struct{}),
 		bench:    scene.NewTicker(),
+		clock:    clock,
+		tickRate: tickRate,
+		onTick:   opts.OnTick,
+		step:     step,
+		secret:   secret,
+	}
+	if opts.Users != nil {
+		r.users = newUserWriter(opts.Users)
+		r.userSaveTicks = max(1, uint32(userSaveInterval/step))
+	}
+	if gracePeriod > 0 {
+		r.graceTicks = uint32(gracePeriod / step)
 	}
 	return r
 }
 
+// Number of ticks per second
+func (s *Simulation) TickRate() int {
+	return s.tickRate
+}
+
+// Return the tick of the last saved state
+func (s *Simulation) Tick() uint32 {
+	return s.tick.Load()
+}
+
+// Add a scene to the simulation on next tick. The first scene added
+// is the default scene of sessions.
 func (s *Simulation) AddScene(scene *scene.Scene) {
 	s.queue <- scene
 }
 
+// Remove a scene from the simulation on next tick. Its sessions are
+// moved to the default scene.
 func (s *Simulation) RemoveScene(scene *scene.Scene) {
 	s.dequeue <- scene
 }
 
+// Return the scene with this name, or the default scene if name is empty
+func (s *Simulation) findWorld(name string) *world {
+	if len(s.worlds) == 0 {
+		return nil
+	}
+	if name == "" {
+		return s.worlds[0]
+	}
+	index := slices.IndexFunc(s.worlds, func(w *world) bool { return w.scene.Name == name })
+	if index == -1 {
+		return nil
+	}
+	return s.worlds[index]
+}
+
 // Create or return existing session. Second value returns true if session was created.
-func (s *Simulation) OpenSession(sessionId string, userId string) (*Session, bool) {
+// New sessions are opened in the scene with this name, or the default scene if empty.
+// Sessions are opened between two ticks of the main loop.
+func (s *Simulation) OpenSession(sessionId string, userId string, sceneName string) (*Session, bool, error) {
+	// Main loop does not wait for the store of users
+	user := s.loadUser(userId)
+
+	var session *Session
+	var created bool
+	err := s.run(func() (err error) {
+		session, created, err = s.openSession(sessionId, userId, sceneName, user)
+		return err
+	})
+	return session, created, err
+}
+
+// Open a session of the user with its state, nil if the user has no state
+func (s *Simulation) openSession(sessionId string, userId string, sceneName string, user *UserState) (*Session, bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	sIndex := slices.IndexFunc(s.sessions, func(ss *Session) bool { return ss.Id == sessionId })
 	if sIndex != -1 {
 		session := s.sessions[sIndex]
+		if session.Count <= 0 {
+			return nil, false, fmt.Errorf("open session %s: %w", sessionId, ErrSessionDetached)
+		}
 		session.Count++
-		return session, false
+		return session, false, nil
+	}
+
+	// Returning users open their last scene, unless they request one
+	if user != nil && sceneName == "" && s.findWorld(user.Scene) != nil {
+		sceneName = user.Scene
 	}
 
-	if s.currentScene == nil {
-		return nil, false
+	w := s.findWorld(sceneName)
+	if w == nil {
+		return nil, false, fmt.Errorf("open session %s in scene %q: %w", sessionId, sceneName, ErrSceneNotFound)
 	}
 
-	camera := s.currentScene.SpawnCamera()
+	camera := w.scene.SpawnUserCamera(userId)
 
-	session := NewSession(sessionId, s, camera, struct{ UserId string }{UserId: userId})
+	session := NewSession(sessionId, userId, s, w, camera)
+	if user != nil {
+		session.restoreUser(user)
+	}
 	s.sessions = append(s.sessions, session)
+	s.record(Record{Type: RecordOpen, SessionId: sessionId, UserId: userId, Scene: w.scene.Name})
+
+	return session, true, nil
+}
+
+// Move a session to another scene without closing it. The camera of the
+// session is spawned in the scene with the same settings, and the next
+// frame is a keyframe.
+func (s *Simulation) MoveSession(sessionId string, sceneName string) error {
+	return s.run(func() error {
+		return s.moveSession(sessionId, sceneName)
+	})
+}
 
-	return session, true
+func (s *Simulation) moveSession(sessionId string, sceneName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sIndex := slices.IndexFunc(s.sessions, func(ss *Session) bool { return ss.Id == sessionId })
+	if sIndex == -1 {
+		return fmt.Errorf("move session %s: %w", sessionId, ErrSessionNotFound)
+	}
+
+	w := s.findWorld(sceneName)
+	if w == nil {
+		return fmt.Errorf("move session %s to scene %q: %w", sessionId, sceneName, ErrSceneNotFound)
+	}
+
+	s.sessions[sIndex].move(w)
+	s.record(Record{Type: RecordMove, SessionId: sessionId, Scene: w.scene.Name})
+	return nil
+}
+
+// Send an input event to the nodes of the session. Events are received
+// between two ticks of the main loop and handled on next tick.
+func (s *Simulation) ReceiveInput(sessionId string, event *pb.InputEvent) error {
+	return s.run(func() error {
+		return s.receiveInput(sessionId, event)
+	})
+}
+
+// Send input events to the nodes of the session in order, between the
+// same two ticks. Stops at the first event which cannot be received.
+func (s *Simulation) ReceiveInputs(sessionId string, events []*pb.InputEvent) error {
+	return s.run(func() error {
+		return s.receiveInputs(sessionId, events)
+	})
+}
+
+func (s *Simulation) receiveInputs(sessionId string, events []*pb.InputEvent) error {
+	for _, event := range events {
+		if err := s.receiveInput(sessionId, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Simulation) receiveInput(sessionId string, event *pb.InputEvent) error {
+	session := s.GetSession(sessionId)
+	if session == nil {
+		return fmt.Errorf("input of session %s: %w", sessionId, ErrSessionNotFound)
+	}
+
+	scn, root := session.Scene(), session.root()
+	scn.ReceiveInput(event, root)
+	s.record(Record{Type: RecordInput, SessionId: sessionId, Scene: scn.Name, Event: event})
+	return nil
 }
 
 func (s *Simulation) GetSession(sessionId string) *Session {
@@ -86,96 +344,416 @@ func (s *Simulation) GetSession(sessionId string) *Session {
 	return nil
 }
 
+// Resume a session with the token returned by Session.ResumeToken(),
+// while the session is opened or waiting to be resumed after being closed.
+// The nodes of the session are kept and the next frame is a keyframe.
+func (s *Simulation) ResumeSession(sessionId string, token string) (*Session, error) {
+	if !s.verify("resume:"+sessionId, token) {
+		return nil, fmt.Errorf("resume session %s: %w", sessionId, ErrInvalidToken)
+	}
+	var session *Session
+	err := s.run(func() (err error) {
+		session, err = s.resumeSession(sessionId)
+		return err
+	})
+	return session, err
+}
+
+func (s *Simulation) resumeSession(sessionId string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sIndex := slices.IndexFunc(s.sessions, func(ss *Session) bool { return ss.Id == sessionId })
+	if sIndex == -1 {
+		return nil, fmt.Errorf("resume session %s: %w", sessionId, ErrSessionNotFound)
+	}
+
+	session := s.sessions[sIndex]
+	if session.Count <= 0 {
+		// Ticker was stopped when the session was closed
+		session.restartTicker()
+	}
+	session.Count = max(session.Count, 0) + 1
+	session.Keyframe()
+	s.record(Record{Type: RecordResume, SessionId: sessionId, Scene: session.Scene().Name})
+	return session, nil
+}
+
+// Close a session opened once more than closed. The camera of the
+// session is destroyed between two ticks of the main loop, once the
+// grace period to resume the session is over.
 func (s *Simulation) CloseSession(sessionId string) bool {
+	var closed bool
+	s.run(func() error {
+		closed = s.closeSession(sessionId)
+		return nil
+	})
+	return closed
+}
+
+func (s *Simulation) closeSession(sessionId string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	sIndex := slices.IndexFunc(s.sessions, func(ss *Session) bool { return ss.Id == sessionId })
 	if sIndex != -1 {
 		session := s.sessions[sIndex]
-		session.Count--
 		if session.Count <= 0 {
-			session.Root.Destroy()
-			s.sessions = slices.Delete(s.sessions, sIndex, sIndex+1)
+			return false
+		}
+		session.Count--
+		if session.Count == 0 {
+			session.expires = s.tick.Load() + s.graceTicks
+			session.Ticker.Stop()
+			s.saveUser(session)
+			s.record(Record{Type: RecordClose, SessionId: sessionId, Scene: session.Scene().Name})
+		}
+		if session.Count == 0 && s.graceTicks == 0 {
+			s.deleteSession(sIndex)
 		}
 		return true
 	}
 	return false
 }
 
-// Starts the main loop
+// Destroy the nodes of sessions closed for longer than the grace period
+func (s *Simulation) expireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tick := s.tick.Load()
+	for i := len(s.sessions) - 1; i >= 0; i-- {
+		if session := s.sessions[i]; session.Count <= 0 && session.expires <= tick {
+			s.deleteSession(i)
+		}
+	}
+}
+
+func (s *Simulation) deleteSession(index int) {
+	s.sessions[index].root().Destroy()
+	s.sessions = slices.Delete(s.sessions, index, index+1)
+}
+
+// Starts the main loop. Ticks run at a fixed rate measured with the
+// clock of the simulation, late ticks are run at once to catch up.
 func (s *Simulation) Start(ctx context.Context) {
-	ticker := time.NewTicker(time.Second / time.Duration(TICKS_PER_SEC))
+	wakeup := time.NewTicker(s.step)
+
+	last := s.clock.Now()
+	var elapsed time.Duration
 
+	if s.users != nil {
+		go s.users.run(ctx)
+	}
+
+	s.started.Store(true)
 	go func() {
+		defer close(s.stopped)
+
 		for {
 			select {
 			case scn := <-s.queue:
-				if s.currentScene == nil {
-					s.currentScene = scn
-				}
-				s.scenes = append(s.scenes, scn)
+				s.addWorld(scn)
 			case scn := <-s.dequeue:
-				if s.currentScene == scn {
-					s.currentScene = nil
-				}
-				s.scenes = slices.DeleteFunc(s.scenes, func(dscn *scene.Scene) bool { return dscn == scn })
+				s.removeWorld(scn)
+			case task := <-s.tasks:
+				// Scenes added before the task are visible to it
+				s.addQueuedWorlds()
+				task()
 			case <-ctx.Done():
+				wakeup.Stop()
 				return
-			case <-ticker.C:
-				tick, _ := s.ticker.Tick()
-
-				s.bench.Reset()
-				for _, scn := range s.scenes {
-					scn.Update()
-				}
-				_, updateTime := s.bench.Tick()
-
-				s.bench.Reset()
-				s.saveState()
-				_, saveTime := s.bench.Tick()
-
-				// Write textures in resources folder
-				if tick == 1 {
-					palette, _ := s.state.GetTextureRGBA(1)
-					diffuse, _ := s.state.GetTexturePaletted(2, palette)
-					specular, _ := s.state.GetTextureGrayScale(3)
-					f2, _ := os.Create(".out/resources/images/diffuse.png")
-					png.Encode(f2, diffuse)
-					f3, _ := os.Create(".out/resources/images/specular.png")
-					png.Encode(f3, specular)
-				}
+			case <-wakeup.C:
+				now := s.clock.Now()
+				elapsed += now.Sub(last)
+				last = now
 
-				if tick%TICKS_PER_SEC == 0 {
-					bufferSize := s.state.buffer.Offset()
-					bufferCapacity := s.state.buffer.Capacity()
-					bufferUsage := (float64(bufferSize) / float64(bufferCapacity)) * 100.0
-					log.Printf("tick=%d buffer_size=%.3fMb usage=%.2f%% blocks=%d update=%vμs save=%vμs", tick, float64(bufferSize)/float64(MiB), bufferUsage, s.state.buffer.BlockCount(), updateTime.Microseconds(), saveTime.Microseconds())
+				for n := 0; elapsed >= s.step; n++ {
+					if n == MAX_CATCHUP_TICKS {
+						elapsed = 0
+						break
+					}
+					s.replayTick()
+					s.runTick()
+					elapsed -= s.step
 				}
 			}
 		}
 	}()
 }
 
-func (s *Simulation) saveState() {
-	s.state.WriteTextureOnce(s.rm.Palette)
-	s.state.WriteTextureGroupOnce(s.rm.Diffuse)
-	s.state.WriteTextureGroupOnce(s.rm.Specular)
+// Run fn in the main loop between two ticks and wait for its result.
+// Code running in the main loop, as scene controllers, waits for itself:
+// it calls the unexported function of an API, or the API from another
+// goroutine. Returns ErrStopped if the main loop is not running.
+func (s *Simulation) run(fn func() error) error {
+	if !s.started.Load() {
+		return ErrStopped
+	}
+
+	done := make(chan error, 1)
+	select {
+	case s.tasks <- func() { done <- fn() }:
+		return <-done
+	case <-s.stopped:
+		return ErrStopped
+	}
+}
+
+// Update scenes by one step and save their state
+func (s *Simulation) runTick() TickStats {
+	tick := s.tick.Load() + 1
+
+	s.bench.Reset()
+	for _, w := range s.worlds {
+		s.stepWorld(w)
+	}
+	_, updateTime := s.bench.Tick()
+
+	s.bench.Reset()
+	var errs []error
+	for _, w := range s.worlds {
+		if err := s.saveState(w); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	saveErr := errors.Join(errs...)
+	_, saveTime := s.bench.Tick()
+	s.tick.Store(tick)
+
+	s.expireSessions()
+	s.updateInterest()
+	s.pruneTombstones()
+	if s.users != nil && tick%s.userSaveTicks == 0 {
+		s.saveUsers()
+	}
+
+	if saveErr != nil {
+		s.saveErrors++
+		s.lastSaveErr = saveErr
+	}
+
+	stats := TickStats{Tick: tick, Update: updateTime, Save: saveTime, SaveErrors: len(errs)}
+	for _, w := range s.worlds {
+		stats.Scenes = append(stats.Scenes, w.stats())
+	}
+	s.mu.Lock()
+	stats.Sessions = len(s.sessions)
+	s.mu.Unlock()
+
+	if tick%uint32(s.tickRate) == 0 {
+		for _, scn := range stats.Scenes {
+			bufferUsage := (float64(scn.BufferSize) / float64(scn.BufferCapacity)) * 100.0
+			log.Printf("tick=%d scene=%s buffer_size=%.3fMb usage=%.2f%% blocks=%d", tick, scn.Name, float64(scn.BufferSize)/float64(MiB), bufferUsage, scn.Blocks)
+		}
+		log.Printf("tick=%d scenes=%d update=%vμs save=%vμs", tick, len(s.worlds), updateTime.Microseconds(), saveTime.Microseconds())
+		if s.saveErrors > 0 {
+			log.Printf("tick=%d save_errors=%d error=%v", tick, s.saveErrors, s.lastSaveErr)
+			s.saveErrors = 0
+		}
+	}
+
+	if s.onTick != nil {
+		s.onTick(stats)
+	}
+
+	return stats
+}
+
+// Run n ticks at once between two iterations of the main loop, without
+// waiting for the clock, and return their statistics. Used to run
+// simulations faster than real time.
+func (s *Simulation) RunTicks(n int) []TickStats {
+	var stats []TickStats
+	s.run(func() error {
+		stats = s.runTicks(n)
+		return nil
+	})
+	return stats
+}
+
+func (s *Simulation) runTicks(n int) []TickStats {
+	stats := make([]TickStats, 0, n)
+	for range n {
+		s.replayTick()
+		stats = append(stats, s.runTick())
+	}
+	return stats
+}
 
-	for _, obj := range s.currentScene.OldNodes {
-		if obj.Camera != nil {
-			delete(s.state.cameras, obj.Id)
-		} else if obj.Light != nil {
-			delete(s.state.lights, obj.Id)
-		} else {
-			delete(s.state.sceneObjectInstances, obj.Id)
+// Update the scene of w by one tick. A paused scene only handles its
+// events, for its queue to not fill up.
+func (s *Simulation) stepWorld(w *world) {
+	if w.paused {
+		if w.steps == 0 {
+			w.scene.ProcessEvents()
+			return
 		}
-		s.state.WriteSceneObjectInstanceDeleted(obj)
+		w.steps--
 	}
 
-	for _, obj := range s.currentScene.Objects() {
-		s.state.WriteSceneObjectOnce(obj.Object)
-		s.state.WriteSceneObjectInstance(obj)
+	// Large steps are split to keep physics stable
+	n := int(math.Ceil(w.timeScale))
+	d := time.Duration(float64(s.step) * w.timeScale / float64(n))
+	for range n {
+		w.scene.Step(d)
 	}
+}
+
+// Stop updating the scene with this name, or the default scene if name
+// is empty. Sessions keep rendering the frozen scene.
+func (s *Simulation) Pause(name string) error {
+	return s.control(name, func(w *world) {
+		w.paused = true
+		w.steps = 0
+	})
+}
+
+// Update the scene at its time scale again
+func (s *Simulation) Resume(name string) error {
+	return s.control(name, func(w *world) {
+		w.paused = false
+		w.steps = 0
+	})
+}
+
+// Pause the scene and update it by n ticks, one per tick
+func (s *Simulation) Step(name string, n int) error {
+	return s.control(name, func(w *world) {
+		w.paused = true
+		w.steps += n
+	})
+}
 
-	// todo: compact buffer to reclaim free space by shifting offsets
+// Multiply the time step of the scene by f, to slow down (f < 1)
+// or fast forward (f > 1) the scene
+func (s *Simulation) SetTimeScale(name string, f float64) error {
+	if f <= 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("set time scale %v: %w", f, ErrInvalidTimeScale)
+	}
+	return s.control(name, func(w *world) {
+		w.timeScale = f
+	})
+}
+
+// Apply fn to the world of a scene between two ticks
+func (s *Simulation) control(name string, fn func(w *world)) error {
+	return s.run(func() error {
+		s.mu.Lock()
+		w := s.findWorld(name)
+		s.mu.Unlock()
+
+		if w == nil {
+			return fmt.Errorf("scene %q: %w", name, ErrSceneNotFound)
+		}
+		fn(w)
+		return nil
+	})
+}
+
+func (s *Simulation) addWorld(scn *scene.Scene) {
+	s.mu.Lock()
+	s.worlds = append(s.worlds, newWorld(scn))
+	s.mu.Unlock()
+}
+
+func (s *Simulation) addQueuedWorlds() {
+	for {
+		select {
+		case scn := <-s.queue:
+			s.addWorld(scn)
+		default:
+			return
+		}
+	}
+}
+
+// Remove the world of the scene and move its sessions to the default scene
+func (s *Simulation) removeWorld(scn *scene.Scene) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := slices.IndexFunc(s.worlds, func(w *world) bool { return w.scene == scn })
+	if index == -1 {
+		return
+	}
+	removed := s.worlds[index]
+	s.worlds = slices.Delete(s.worlds, index, index+1)
+
+	w := s.findWorld("")
+	for _, session := range s.sessions {
+		if w != nil && session.currentWorld() == removed {
+			session.move(w)
+		}
+	}
+}
+
+// Update instances relevant to the camera of each session
+func (s *Simulation) updateInterest() {
+	s.mu.Lock()
+	sessions := slices.Clone(s.sessions)
+	s.mu.Unlock()
+
+	for _, session := range sessions {
+		session.UpdateInterest()
+	}
+}
+
+// Free tombstones received by all sessions of a scene, or kept for more than
+// TOMBSTONE_RETENTION
+func (s *Simulation) pruneTombstones() {
+	acked := make(map[*world]uint32, len(s.worlds))
+	for _, w := range s.worlds {
+		acked[w] = math.MaxUint32
+	}
+	s.mu.Lock()
+	for _, session := range s.sessions {
+		w, sessionAcked := session.acknowledged()
+		if a, ok := acked[w]; ok {
+			acked[w] = min(a, sessionAcked)
+		}
+	}
+	s.mu.Unlock()
+
+	// State is committed once per tick
+	retention := uint32(TOMBSTONE_RETENTION / s.step)
+	for w, acked := range acked {
+		var expired uint32
+		if generation := w.state.Generation(); generation > retention {
+			expired = generation - retention
+		}
+		w.state.PruneTombstones(acked, expired)
+	}
+}
+
+// Write scene in its state. Objects which cannot be written are skipped
+// and the errors are returned.
+func (s *Simulation) saveState(w *world) error {
+	var errs []error
+
+	errs = append(errs,
+		w.state.UpdateTexture(s.rm.Palette),
+		w.state.UpdateTextureGroup(s.rm.Diffuse),
+		w.state.UpdateTextureGroup(s.rm.Specular),
+	)
+
+	for _, obj := range w.scene.OldNodes {
+		w.state.DeleteSceneObjectInstance(obj)
+		errs = append(errs, w.state.WriteSceneObjectInstanceDeleted(obj))
+	}
+
+	for _, obj := range w.scene.Objects() {
+		if err := w.state.UpdateSceneObject(obj.Object); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, w.state.WriteSceneObjectInstance(obj))
+	}
+
+	w.state.Compact()
+	w.state.Commit()
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("scene %s: %w", w.scene.Name, err)
+	}
+	return nil
 }
@@ -0,0 +1,298 @@
+package simulation
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/pb"
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+)
+
+func TestFixedTimestep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := scene.NewManualClock(time.Unix(0, 0))
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 100, Clock: clock})
+	sim.Start(ctx)
+
+	waitTick := func(tick uint32) {
+		deadline := time.Now().Add(time.Second)
+		for sim.Tick() < tick && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	clock.Advance(3 * 10 * time.Millisecond)
+	waitTick(3)
+	if tick := sim.Tick(); tick != 3 {
+		t.Errorf("expected 3 ticks, got %d", tick)
+	}
+
+	// Ticks only run when the clock moves
+	time.Sleep(50 * time.Millisecond)
+	if tick := sim.Tick(); tick != 3 {
+		t.Errorf("expected no tick without clock moving, got %d", tick)
+	}
+
+	// Late ticks are dropped
+	clock.Advance(time.Second)
+	waitTick(3 + MAX_CATCHUP_TICKS)
+	time.Sleep(50 * time.Millisecond)
+	if tick := sim.Tick(); tick != 3+MAX_CATCHUP_TICKS {
+		t.Errorf("expected %d ticks, got %d", 3+MAX_CATCHUP_TICKS, tick)
+	}
+}
+
+func TestTimeControl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Unix(0, 0)
+	clock := scene.NewManualClock(start)
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 100, Clock: clock})
+	scn := scene.NewScene(scene.SceneOptions{Name: "test", Clock: clock})
+	sim.AddScene(scn)
+	sim.Start(ctx)
+
+	// Scene is added on next iteration of the main loop
+	for sim.Pause("test") != nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	tick := func(n int) {
+		target := sim.Tick() + uint32(n)
+		clock.Advance(time.Duration(n) * 10 * time.Millisecond)
+		for sim.Tick() < target {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	tick(3)
+	if d := scn.Since(start); d != 0 {
+		t.Errorf("expected paused scene to not move, got %v", d)
+	}
+
+	if err := sim.Step("test", 2); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	tick(3)
+	if d := scn.Since(start); d != 20*time.Millisecond {
+		t.Errorf("expected scene to move by 2 steps, got %v", d)
+	}
+
+	if err := sim.SetTimeScale("test", .5); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	sim.Resume("test")
+	tick(2)
+	if d := scn.Since(start); d != 30*time.Millisecond {
+		t.Errorf("expected scene to move by half steps, got %v", d)
+	}
+
+	if err := sim.Pause("unknown"); !errors.Is(err, ErrSceneNotFound) {
+		t.Errorf("expected ErrSceneNotFound, got %v", err)
+	}
+	if err := sim.SetTimeScale("test", 0); !errors.Is(err, ErrInvalidTimeScale) {
+		t.Errorf("expected ErrInvalidTimeScale, got %v", err)
+	}
+}
+
+func TestPausedInput(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var pressed int
+	start := time.Unix(0, 0)
+	clock := scene.NewManualClock(start)
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 100, Clock: clock})
+	scn := scene.NewScene(scene.SceneOptions{
+		Name:   "test",
+		Clock:  clock,
+		Camera: &scene.CameraSettings{Projection: scene.Perspective},
+		CameraController: &scene.SceneObjectController{
+			Input: func(self *scene.Node, event *pb.InputEvent) {
+				pressed++
+			},
+		},
+	})
+	sim.AddScene(scn)
+	sim.Start(ctx)
+
+	if err := sim.Pause("test"); err != nil {
+		t.Fatal(err)
+	}
+	session, _, err := sim.OpenSession("player", "", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More events than the queue of the scene holds, over a few ticks
+	done := make(chan error)
+	go func() {
+		for range 12 {
+			events := make([]*pb.InputEvent, 100)
+			for i := range events {
+				events[i] = &pb.InputEvent{Pressed: true}
+			}
+			if err := sim.ReceiveInputs("player", events); err != nil {
+				done <- err
+				return
+			}
+			sim.RunTicks(1)
+		}
+		done <- sim.Resume("test")
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected input of a paused scene to not block the main loop")
+	}
+
+	sim.RunTicks(1)
+	if pressed != 1200 {
+		t.Errorf("expected 1200 events handled, got %d", pressed)
+	}
+	if !slices.Contains(scn.Objects(), session.Root) {
+		t.Errorf("expected camera spawned in paused scene")
+	}
+	if d := scn.Since(start); d != 10*time.Millisecond {
+		t.Errorf("expected scene to only move once resumed, got %v", d)
+	}
+}
+
+func TestRunTicks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := scene.NewManualClock(time.Unix(0, 0))
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 100, Clock: clock})
+	scn := scene.NewScene(scene.SceneOptions{Name: "test", Clock: clock})
+	sim.AddScene(scn)
+	sim.Start(ctx)
+
+	stats := sim.RunTicks(10)
+	if len(stats) != 10 || stats[9].Tick != 10 || sim.Tick() != 10 {
+		t.Fatalf("expected 10 ticks run, got %d", sim.Tick())
+	}
+	if len(stats[0].Scenes) != 1 || stats[0].Scenes[0].Name != "test" {
+		t.Errorf("expected statistics of scene test, got %v", stats[0].Scenes)
+	}
+	if d := scn.Since(time.Unix(0, 0)); d != 100*time.Millisecond {
+		t.Errorf("expected scene to move by 10 steps without clock, got %v", d)
+	}
+}
+
+func TestReceiveInputs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var codes []string
+	clock := scene.NewManualClock(time.Unix(0, 0))
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 100, Clock: clock})
+	sim.AddScene(scene.NewScene(scene.SceneOptions{
+		Name:   "test",
+		Clock:  clock,
+		Camera: &scene.CameraSettings{Projection: scene.Perspective},
+		CameraController: &scene.SceneObjectController{
+			Input: func(self *scene.Node, event *pb.InputEvent) {
+				codes = append(codes, event.Code)
+			},
+		},
+	}))
+	sim.Start(ctx)
+
+	if _, _, err := sim.OpenSession("player", "", "test"); err != nil {
+		t.Fatal(err)
+	}
+	sim.RunTicks(1)
+
+	events := []*pb.InputEvent{{Code: "KeyA"}, {Code: "KeyB"}, {Code: "KeyC"}}
+	if err := sim.ReceiveInputs("player", events); err != nil {
+		t.Fatal(err)
+	}
+	sim.RunTicks(1)
+
+	if !slices.Equal(codes, []string{"KeyA", "KeyB", "KeyC"}) {
+		t.Errorf("expected events in order, got %v", codes)
+	}
+	if err := sim.ReceiveInputs("nobody", events); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestMoveSessionFromController(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := scene.NewManualClock(time.Unix(0, 0))
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 100, Clock: clock})
+
+	// Door moving the session to the other level on first update, from
+	// another goroutine as the move waits for the main loop
+	moved := make(chan error, 1)
+	door := scene.NewObject(scene.SceneObjectArgs{
+		Update: func(self *scene.Node, deltaTime time.Duration) {
+			if self.Data["opened"] == nil {
+				self.Data["opened"] = true
+				go func() { moved <- sim.MoveSession("player", "level2") }()
+			}
+		},
+	})
+
+	camera := &scene.CameraSettings{Projection: scene.Perspective}
+	level1 := scene.NewScene(scene.SceneOptions{Name: "level1", Clock: clock, Camera: camera})
+	level2 := scene.NewScene(scene.SceneOptions{Name: "level2", Clock: clock, Camera: camera})
+	sim.AddScene(level1)
+	sim.AddScene(level2)
+	sim.Start(ctx)
+
+	session, _, err := sim.OpenSession("player", "", "level1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	level1.Spawn(door, scene.SpawnArgs{})
+	sim.RunTicks(2)
+
+	if err := <-moved; err != nil {
+		t.Fatalf("expected session to move, got %v", err)
+	}
+	if name := session.Scene().Name; name != "level2" {
+		t.Errorf("expected session in level2, got %s", name)
+	}
+}
+
+func TestRunStopped(t *testing.T) {
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{})
+	// Task may still be run by the loop while it stops
+	sim.AddScene(scene.NewScene(scene.SceneOptions{Name: "test", Camera: &scene.CameraSettings{Projection: scene.Perspective}}))
+
+	if _, _, err := sim.OpenSession("player", "", ""); !errors.Is(err, ErrStopped) {
+		t.Errorf("expected ErrStopped before start, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sim.Start(ctx)
+	cancel()
+
+	done := make(chan error)
+	go func() {
+		_, _, err := sim.OpenSession("player", "", "")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil && !errors.Is(err, ErrStopped) {
+			t.Errorf("expected ErrStopped once stopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected stopped simulation to not block")
+	}
+}
@@ -0,0 +1,177 @@
+package simulation
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/geotry/stago/scene"
+)
+
+// Version of the snapshot format, incremented when snapshot or
+// scene.SceneSnapshot change
+const SNAPSHOT_VERSION = 2
+
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+type snapshotHeader struct {
+	Version      uint32
+	SceneVersion uint32
+}
+
+type snapshot struct {
+	Scenes   []*scene.SceneSnapshot
+	Worlds   []worldSnapshot
+	Sessions []sessionSnapshot
+}
+
+// Time control of a scene
+type worldSnapshot struct {
+	Scene     string
+	Paused    bool
+	Steps     int
+	TimeScale float64
+}
+
+type sessionSnapshot struct {
+	Id    string
+	Scene string
+	// Id of the camera node of the session
+	Camera uint32
+}
+
+// Write a snapshot of all scenes and the cameras of sessions in w.
+// The snapshot is taken between two ticks of the main loop.
+func (s *Simulation) Snapshot(w io.Writer) error {
+	return s.run(func() error {
+		return s.writeSnapshot(w)
+	})
+}
+
+func (s *Simulation) writeSnapshot(w io.Writer) error {
+	snap := snapshot{}
+	for _, w := range s.worlds {
+		scn, err := w.scene.Snapshot()
+		if err != nil {
+			return fmt.Errorf("snapshot scene %s: %w", w.scene.Name, err)
+		}
+		snap.Scenes = append(snap.Scenes, scn)
+		snap.Worlds = append(snap.Worlds, worldSnapshot{Scene: scn.Name, Paused: w.paused, Steps: w.steps, TimeScale: w.timeScale})
+	}
+
+	s.mu.Lock()
+	for _, session := range s.sessions {
+		scn, root := session.Scene(), session.root()
+		snap.Sessions = append(snap.Sessions, sessionSnapshot{Id: session.Id, Scene: scn.Name, Camera: root.Id})
+	}
+	s.mu.Unlock()
+
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{Version: SNAPSHOT_VERSION, SceneVersion: scene.SnapshotVersion}); err != nil {
+		return fmt.Errorf("snapshot header: %w", err)
+	}
+	if err := enc.Encode(snap); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	return nil
+}
+
+// Restore scenes and their time control from a snapshot written by
+// Snapshot(). Scenes are matched by name and must have registered the
+// scene objects of their nodes, no scene is restored otherwise.
+// Sessions get their camera back, or a new camera if they are not in the
+// snapshot, and receive a keyframe.
+func (s *Simulation) Restore(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("restore header: %w", err)
+	}
+	if header.Version != SNAPSHOT_VERSION || header.SceneVersion != scene.SnapshotVersion {
+		return fmt.Errorf("restore version %d (scene %d): %w", header.Version, header.SceneVersion, ErrSnapshotVersion)
+	}
+
+	var snap snapshot
+	if err := dec.Decode(&snap); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	return s.run(func() error {
+		return s.restore(&snap)
+	})
+}
+
+// Restore the scenes of a snapshot, once all are validated
+func (s *Simulation) restore(snap *snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, scn := range snap.Scenes {
+		w := s.findWorld(scn.Name)
+		if w == nil {
+			return fmt.Errorf("restore scene %q: %w", scn.Name, ErrSceneNotFound)
+		}
+		if err := w.scene.CheckSnapshot(scn); err != nil {
+			return fmt.Errorf("restore scene %s: %w", scn.Name, err)
+		}
+	}
+	for _, ws := range snap.Worlds {
+		if ws.TimeScale <= 0 || math.IsInf(ws.TimeScale, 0) || math.IsNaN(ws.TimeScale) {
+			return fmt.Errorf("restore scene %s: time scale %v: %w", ws.Scene, ws.TimeScale, ErrInvalidTimeScale)
+		}
+	}
+
+	for _, scn := range snap.Scenes {
+		w := s.findWorld(scn.Name)
+		if err := w.scene.Restore(scn); err != nil {
+			return fmt.Errorf("restore scene %s: %w", scn.Name, err)
+		}
+
+		// Node ids are reused, start from an empty state
+		restored := &world{}
+		*restored = *w
+		restored.state = NewState()
+		for _, ws := range snap.Worlds {
+			if ws.Scene == scn.Name {
+				restored.paused, restored.steps, restored.timeScale = ws.Paused, ws.Steps, ws.TimeScale
+			}
+		}
+		s.worlds[slices.Index(s.worlds, w)] = restored
+
+		// Cameras of sessions which are not opened anymore
+		cameras := make(map[uint32]bool)
+		for _, ss := range snap.Sessions {
+			if ss.Scene == scn.Name {
+				cameras[ss.Camera] = true
+			}
+		}
+
+		for _, session := range s.sessions {
+			if session.currentWorld() != w {
+				continue
+			}
+			var root *scene.Node
+			for _, ss := range snap.Sessions {
+				if ss.Id == session.Id && ss.Scene == scn.Name {
+					root = w.scene.Node(ss.Camera)
+				}
+			}
+			if root != nil {
+				delete(cameras, root.Id)
+			}
+			session.restore(restored, root)
+		}
+
+		for id := range cameras {
+			if node := w.scene.Node(id); node != nil {
+				node.Destroy()
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/compute"
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scn := scene.NewScene(scene.SceneOptions{Name: "test", Camera: &scene.CameraSettings{Projection: scene.Perspective}})
+	object := scene.NewObject(scene.SceneObjectArgs{Name: "object"})
+	scn.Register(object)
+
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{Clock: scene.NewManualClock(time.Unix(0, 0))})
+	sim.AddScene(scn)
+	sim.Start(ctx)
+
+	node := scn.Spawn(object, scene.SpawnArgs{Position: compute.Point{X: 1}})
+
+	session, _, err := sim.OpenSession("test", "", "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	camera := session.root()
+	// Nodes are added on next update
+	sim.RunTicks(1)
+	sim.run(func() error {
+		camera.Camera.SetFov(1)
+		return nil
+	})
+	sim.SetTimeScale("test", 2)
+	sim.Pause("test")
+	sim.RunTicks(1)
+	now := scn.Now()
+
+	var buf bytes.Buffer
+	if err := sim.Snapshot(&buf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	sim.Resume("test")
+	sim.SetTimeScale("test", 1)
+	sim.RunTicks(1)
+	sim.run(func() error {
+		node.Transform.Position.X = 2
+		return nil
+	})
+	if err := sim.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if restored := scn.Node(node.Id); restored == nil || restored == node || restored.Transform.Position.X != 1 {
+		t.Errorf("expected node to be restored at its position")
+	}
+	if root := session.root(); root.Id != camera.Id || root == camera || root.Camera.Fov != 1 {
+		t.Errorf("expected session to get its restored camera")
+	}
+
+	var paused bool
+	var timeScale float64
+	sim.run(func() error {
+		sim.mu.Lock()
+		defer sim.mu.Unlock()
+		w := sim.findWorld("test")
+		paused, timeScale = w.paused, w.timeScale
+		return nil
+	})
+	if !paused || timeScale != 2 || !scn.Now().Equal(now) {
+		t.Errorf("expected time of the scene to be restored, got paused=%v time_scale=%v", paused, timeScale)
+	}
+
+	var invalid bytes.Buffer
+	gob.NewEncoder(&invalid).Encode(snapshotHeader{Version: SNAPSHOT_VERSION + 1})
+	if err := sim.Restore(&invalid); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("expected ErrSnapshotVersion, got %v", err)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"maps"
 	"math"
+	"slices"
 	"sync"
 
 	"github.com/geotry/stago/encoding"
@@ -25,20 +27,32 @@ type State struct {
 	sceneObjectInstances        map[uint32]*encoding.Block
 	sceneObjectInstancesDeleted map[uint32]*encoding.Block
 
+	// Versions of texture groups and scene objects written in state
+	textureVersions     map[int]int
+	sceneObjectVersions map[int32]int
+
+	// Generation of the last committed state, see Commit()
+	generation uint32
+	// Generation of each block when its content last changed
+	generations map[*encoding.Block]uint32
+	// Highest generation of tombstones pruned before being acknowledged
+	// by all sessions, see PruneTombstones()
+	pruned uint32
+
 	mu sync.RWMutex
 }
 
 // List of blocks
-type BlockType uint8
+type BlockType = encoding.BlockType
 
 const (
-	TextureBlock BlockType = iota
-	CameraBlock
-	SceneObjectBlock
-	SceneObjectInstanceBlock
-	LightBlock
-	LightDeletedBlock
-	SceneObjectInstanceDeletedBlock
+	TextureBlock                    = encoding.TextureBlock
+	CameraBlock                     = encoding.CameraBlock
+	SceneObjectBlock                = encoding.SceneObjectBlock
+	SceneObjectInstanceBlock        = encoding.SceneObjectInstanceBlock
+	LightBlock                      = encoding.LightBlock
+	LightDeletedBlock               = encoding.LightDeletedBlock
+	SceneObjectInstanceDeletedBlock = encoding.SceneObjectInstanceDeletedBlock
 )
 
 const (
@@ -49,11 +63,18 @@ const (
 	TiB
 )
 
-const BufferSize = 1 * MiB
+// Initial and maximum size of the state buffer
+const (
+	BufferSize    = 1 * MiB
+	MaxBufferSize = 64 * MiB
+)
 
 func NewState() *State {
+	buffer := encoding.NewBlockBuffer(BufferSize)
+	buffer.SetMaxCapacity(MaxBufferSize)
+
 	return &State{
-		buffer:                      encoding.NewBlockBuffer(BufferSize),
+		buffer:                      buffer,
 		textures:                    make(map[int]*encoding.Block),
 		cameras:                     make(map[uint32]*encoding.Block),
 		lights:                      make(map[uint32]*encoding.Block),
@@ -61,10 +82,13 @@ func NewState() *State {
 		sceneObjects:                make(map[int32]*encoding.Block),
 		sceneObjectInstances:        make(map[uint32]*encoding.Block),
 		sceneObjectInstancesDeleted: make(map[uint32]*encoding.Block),
+		textureVersions:             make(map[int]int),
+		sceneObjectVersions:         make(map[int32]int),
+		generations:                 make(map[*encoding.Block]uint32),
 	}
 }
 
-func (s *State) WriteTextureGroup(group *rendering.TextureGroup) {
+func (s *State) WriteTextureGroup(group *rendering.TextureGroup) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -76,17 +100,29 @@ func (s *State) WriteTextureGroup(group *rendering.TextureGroup) {
 		buf = s.buffer
 	}
 
-	depth := len(group.Textures)
+	block := encoding.Texture{
+		Id:     uint8(group.Id),
+		Width:  uint16(group.Width),
+		Height: uint16(group.Height),
+		Depth:  uint8(len(group.Textures)),
+		Format: uint8(group.Model),
+		Role:   uint8(group.Role),
+		Pixels: texturePixels(group),
+	}
+	block.Write(buf)
 
-	buf.NewBlock(uint8(TextureBlock))
-	buf.PutUint8(uint8(group.Id))
-	buf.PutUint16(uint16(group.Width))
-	buf.PutUint16(uint16(group.Height))
-	buf.PutUint8(uint8(depth))
-	buf.PutUint8(uint8(group.Model))
-	buf.PutUint8(uint8(group.Role))
+	if err := endBlock(s, s.textures, group.Id, buf); err != nil {
+		return fmt.Errorf("texture group %d: %w", group.Id, err)
+	}
+	s.textureVersions[group.Id] = group.Version
+	return nil
+}
+
+// Return pixels of all textures of the group, each texture
+// being written in a canvas of the group size
+func texturePixels(group *rendering.TextureGroup) []uint8 {
+	pixels := make([]uint8, 0, len(group.Textures)*group.Width*group.Height*group.PixelSize)
 
-	buf.NewArray()
 	for _, texture := range group.Textures {
 		textureWidth := texture.Width
 		textureHeight := len(texture.Pixels) / group.PixelSize / textureWidth
@@ -99,59 +135,54 @@ func (s *State) WriteTextureGroup(group *rendering.TextureGroup) {
 				switch group.Model {
 				case rendering.ALPHA:
 					if group.Role == rendering.Diffuse {
-						buf.PutUint8(255)
+						pixels = append(pixels, 255)
 					} else {
-						buf.PutUint8(0)
+						pixels = append(pixels, 0)
 					}
 				case rendering.RGB:
-					buf.PutUint8(0)
-					buf.PutUint8(0)
-					buf.PutUint8(0)
+					pixels = append(pixels, 0, 0, 0)
 				case rendering.RGBA:
-					buf.PutUint8(0)
-					buf.PutUint8(0)
-					buf.PutUint8(0)
-					buf.PutUint8(0)
+					pixels = append(pixels, 0, 0, 0, 0)
 				}
 			} else {
 				for p := range group.PixelSize {
-					buf.PutUint8(texture.Pixels[i+p-(width-textureWidth*group.PixelSize)*y])
+					pixels = append(pixels, texture.Pixels[i+p-(width-textureWidth*group.PixelSize)*y])
 				}
 			}
 		}
 	}
-	buf.EndArray()
 
-	if s.textures[group.Id] == nil {
-		s.textures[group.Id] = buf.EndBlock()
-	}
+	return pixels
 }
 
-func (s *State) WriteTextureGroupOnce(group *rendering.TextureGroup) {
+// Write texture group if it is missing or has changed since last write
+func (s *State) UpdateTextureGroup(group *rendering.TextureGroup) error {
 	s.mu.RLock()
-	missing := s.textures[group.Id] == nil
+	changed := s.textures[group.Id] == nil || s.textureVersions[group.Id] != group.Version
 	s.mu.RUnlock()
-	if missing {
-		s.WriteTextureGroup(group)
+	if changed {
+		return s.WriteTextureGroup(group)
 	}
+	return nil
 }
 
-func (s *State) WriteTextureOnce(texture *rendering.Texture) {
-	s.mu.RLock()
-	missing := s.textures[texture.Group.Id] == nil
-	s.mu.RUnlock()
-	if missing {
-		s.WriteTextureGroup(texture.Group)
-	}
+// Write group of the texture if it is missing or has changed since last write
+func (s *State) UpdateTexture(texture *rendering.Texture) error {
+	return s.UpdateTextureGroup(texture.Group)
 }
 
-func (s *State) WriteSceneObjectOnce(obj *scene.SceneObject) {
-	if s.sceneObjects[obj.Id] == nil {
-		s.WriteSceneObject(obj)
+// Write scene object if it is missing or has changed since last write
+func (s *State) UpdateSceneObject(obj *scene.SceneObject) error {
+	s.mu.RLock()
+	changed := s.sceneObjects[obj.Id] == nil || s.sceneObjectVersions[obj.Id] != obj.Version
+	s.mu.RUnlock()
+	if changed {
+		return s.WriteSceneObject(obj)
 	}
+	return nil
 }
 
-func (s *State) WriteSceneObject(obj *scene.SceneObject) {
+func (s *State) WriteSceneObject(obj *scene.SceneObject) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	var buf encoding.WritableBlock
@@ -164,37 +195,28 @@ func (s *State) WriteSceneObject(obj *scene.SceneObject) {
 
 	// Encode vertex data (geometry, uv mapping, normals...) in a single block
 	// It should be sent upfront like textures, then use ObjectID to reference it in other blocks
-	buf.NewBlock(uint8(SceneObjectBlock))
-	// Encode Object ID so webgl can index buffer position with ID and update partial buffer
-	// without rewriting the whole buffer from zero
-	// ObjectID is the SceneObject.Id, not Node.Id
-	buf.PutUint32(uint32(obj.Id))
+	block := encoding.SceneObject{
+		// Encode Object ID so webgl can index buffer position with ID and update partial buffer
+		// without rewriting the whole buffer from zero
+		// ObjectID is the SceneObject.Id, not Node.Id
+		Id:     uint32(obj.Id),
+		Opaque: true,
+		Space:  uint8(obj.Space),
+	}
 
 	if obj.Material != nil {
-		buf.PutUint8(uint8(obj.Material.Diffuse.Index))
-		buf.PutUint8(uint8(obj.Material.Specular.Index))
-		buf.PutFloat32(float32(obj.Material.Shininess))
-		if obj.Material.Opaque {
-			buf.PutUint8(1)
-		} else {
-			buf.PutUint8(0)
-		}
-	} else {
-		buf.PutUint8(0)
-		buf.PutUint8(0)
-		buf.PutFloat32(0)
-		buf.PutUint8(1)
+		block.DiffuseIndex = uint8(obj.Material.Diffuse.Index)
+		block.SpecularIndex = uint8(obj.Material.Specular.Index)
+		block.Shininess = float32(obj.Material.Shininess)
+		block.Opaque = obj.Material.Opaque
 	}
 
-	buf.PutUint8(uint8(obj.Space))
-
 	// Todo: send interleaved float32 like buffer
 
-	buf.NewArray()
+	block.Vertices = make([]float32, 0, len(obj.Shape.Geometry)*3)
 	for _, p := range obj.Shape.Geometry {
-		buf.PutVector3Float32(float32(p.X), float32(p.Y), float32(p.Z))
+		block.Vertices = append(block.Vertices, float32(p.X), float32(p.Y), float32(p.Z))
 	}
-	buf.EndArray()
 
 	// UV Mapping
 	// Use texture size / dimension to stretch correctly
@@ -204,29 +226,31 @@ func (s *State) WriteSceneObject(obj *scene.SceneObject) {
 		rx = float32(float64(obj.Material.Diffuse.Width) / float64(obj.Material.Diffuse.Group.Width))
 		ry = float32(float64(obj.Material.Diffuse.Height) / float64(obj.Material.Diffuse.Group.Height))
 	}
-	buf.NewArray()
+	block.UV = make([]float32, 0, len(obj.Shape.Texture)*2)
 	for _, p := range obj.Shape.Texture {
-		buf.PutVector2Float32(float32(p.X)*rx, float32(p.Y)*ry)
+		block.UV = append(block.UV, float32(p.X)*rx, float32(p.Y)*ry)
 	}
-	buf.EndArray()
 
 	// Normals
-	buf.NewArray()
+	block.Normals = make([]float32, 0, len(obj.Shape.Normals)*3)
 	for _, p := range obj.Shape.Normals {
-		buf.PutVector3Float32(float32(p.X), float32(p.Y), float32(p.Z))
+		block.Normals = append(block.Normals, float32(p.X), float32(p.Y), float32(p.Z))
 	}
-	buf.EndArray()
 
-	if s.sceneObjects[obj.Id] == nil {
-		s.sceneObjects[obj.Id] = buf.EndBlock()
+	block.Write(buf)
+
+	if err := endBlock(s, s.sceneObjects, obj.Id, buf); err != nil {
+		return fmt.Errorf("scene object %d: %w", obj.Id, err)
 	}
+	s.sceneObjectVersions[obj.Id] = obj.Version
+	return nil
 }
 
 func (s *State) ReadSceneObject(obj *scene.SceneObject) *encoding.Block {
 	return s.sceneObjects[obj.Id]
 }
 
-func (s *State) writeCamera(obj *scene.Node) {
+func (s *State) writeCamera(obj *scene.Node) error {
 	var buf encoding.WritableBlock
 
 	if s.cameras[obj.Id] != nil {
@@ -235,61 +259,74 @@ func (s *State) writeCamera(obj *scene.Node) {
 		buf = s.buffer
 	}
 
-	buf.NewBlock(uint8(CameraBlock))
-	buf.PutUint16(uint16(obj.Id))
-	buf.PutMatrix(obj.Camera.ViewMatrix())
-	buf.PutMatrix(obj.Camera.ProjectionMatrix())
+	block := encoding.Camera{
+		Id:               uint16(obj.Id),
+		ViewMatrix:       obj.Camera.ViewMatrix(),
+		ProjectionMatrix: obj.Camera.ProjectionMatrix(),
+	}
+	block.Write(buf)
 
-	if s.cameras[obj.Id] == nil {
-		s.cameras[obj.Id] = buf.EndBlock()
+	if err := endBlock(s, s.cameras, obj.Id, buf); err != nil {
+		return fmt.Errorf("camera %d: %w", obj.Id, err)
 	}
+	return nil
 }
 
-func (s *State) writeLight(obj *scene.Node) {
+func (s *State) writeLight(obj *scene.Node) error {
 	var buf encoding.WritableBlock
 
 	if s.lights[obj.Id] != nil {
 		buf = s.lights[obj.Id]
 	} else {
+		// Node id is reused, the light is not deleted anymore
+		freeBlock(s, s.lightsDeleted, obj.Id)
 		buf = s.buffer
 	}
 
-	buf.NewBlock(uint8(LightBlock))
-	buf.PutUint16(uint16(obj.Id))
 	lightType := obj.Light.Type()
-	buf.PutUint8(uint8(lightType))
-
 	ambient, diffuse, specular := obj.Light.AmbientColor(), obj.Light.DiffuseColor(), obj.Light.SpecularColor()
-	buf.PutVector3Float32(float32(ambient.X), float32(ambient.Y), float32(ambient.Z))
-	buf.PutVector3Float32(float32(diffuse.X), float32(diffuse.Y), float32(diffuse.Z))
-	buf.PutVector3Float32(float32(specular.X), float32(specular.Y), float32(specular.Z))
 	pos := obj.Transform.WorldPosition()
-	buf.PutVector3Float32(float32(pos.X), float32(pos.Y), float32(pos.Z))
+
+	block := encoding.Light{
+		Id:        uint16(obj.Id),
+		Type:      uint8(lightType),
+		AmbientR:  float32(ambient.X),
+		AmbientG:  float32(ambient.Y),
+		AmbientB:  float32(ambient.Z),
+		DiffuseR:  float32(diffuse.X),
+		DiffuseG:  float32(diffuse.Y),
+		DiffuseB:  float32(diffuse.Z),
+		SpecularR: float32(specular.X),
+		SpecularG: float32(specular.Y),
+		SpecularB: float32(specular.Z),
+		PosX:      float32(pos.X),
+		PosY:      float32(pos.Y),
+		PosZ:      float32(pos.Z),
+	}
 
 	switch lightType {
 	case scene.Directional:
 		light := obj.Light.(*scene.DirectionalLight)
-		buf.PutVector3Float32(float32(light.Direction.X), float32(light.Direction.Y), float32(light.Direction.Z))
-		buf.PutFloat32(0)
-		buf.PutFloat32(0)
+		block.DirectionX, block.DirectionY, block.DirectionZ = float32(light.Direction.X), float32(light.Direction.Y), float32(light.Direction.Z)
 	case scene.Point:
 		light := obj.Light.(*scene.PointLight)
-		buf.PutVector3Float32(0, 0, 0)
-		buf.PutFloat32(float32(light.Radius))
-		buf.PutFloat32(0)
+		block.Radius = float32(light.Radius)
 	case scene.Spot:
 		light := obj.Light.(*scene.SpotLight)
-		buf.PutVector3Float32(float32(light.Direction.X), float32(light.Direction.Y), float32(light.Direction.Z))
-		buf.PutFloat32(float32(light.CutOff))
-		buf.PutFloat32(float32(light.OuterCutOff))
+		block.DirectionX, block.DirectionY, block.DirectionZ = float32(light.Direction.X), float32(light.Direction.Y), float32(light.Direction.Z)
+		block.Radius = float32(light.CutOff)
+		block.OuterCutOff = float32(light.OuterCutOff)
 	}
 
-	if s.lights[obj.Id] == nil {
-		s.lights[obj.Id] = buf.EndBlock()
+	block.Write(buf)
+
+	if err := endBlock(s, s.lights, obj.Id, buf); err != nil {
+		return fmt.Errorf("light %d: %w", obj.Id, err)
 	}
+	return nil
 }
 
-func (s *State) WriteSceneObjectInstanceDeleted(obj *scene.Node) {
+func (s *State) WriteSceneObjectInstanceDeleted(obj *scene.Node) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -298,46 +335,185 @@ func (s *State) WriteSceneObjectInstanceDeleted(obj *scene.Node) {
 	if obj.Camera != nil {
 		// s.writeCamera(obj)
 	} else if obj.Light != nil {
-		buf.NewBlock(uint8(LightDeletedBlock))
-		buf.PutUint16(uint16(obj.Id))
-		buf.PutUint8(uint8(obj.Light.Type()))
-		s.lightsDeleted[obj.Id] = buf.EndBlock()
+		freeBlock(s, s.lightsDeleted, obj.Id)
+		block := encoding.LightDeleted{Id: uint16(obj.Id), Type: uint8(obj.Light.Type())}
+		block.Write(buf)
+		if err := endBlock(s, s.lightsDeleted, obj.Id, buf); err != nil {
+			return fmt.Errorf("deleted light %d: %w", obj.Id, err)
+		}
 	} else {
-		buf.NewBlock(uint8(SceneObjectInstanceDeletedBlock))
-		buf.PutUint16(uint16(obj.Id))
-		buf.PutUint32(uint32(obj.Object.Id))
-		s.sceneObjectInstancesDeleted[obj.Id] = buf.EndBlock()
+		freeBlock(s, s.sceneObjectInstancesDeleted, obj.Id)
+		block := encoding.SceneObjectInstanceDeleted{Id: uint16(obj.Id), ObjectId: uint32(obj.Object.Id)}
+		block.Write(buf)
+		if err := endBlock(s, s.sceneObjectInstancesDeleted, obj.Id, buf); err != nil {
+			return fmt.Errorf("deleted scene object instance %d: %w", obj.Id, err)
+		}
+	}
+	return nil
+}
+
+// Remove the block of a node from state and release its space in buffer
+func (s *State) DeleteSceneObjectInstance(obj *scene.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if obj.Camera != nil {
+		freeBlock(s, s.cameras, obj.Id)
+	} else if obj.Light != nil {
+		freeBlock(s, s.lights, obj.Id)
+	} else {
+		freeBlock(s, s.sceneObjectInstances, obj.Id)
+	}
+}
+
+// Reclaim space of freed blocks. Blocks indexed in state remain valid.
+func (s *State) Compact() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buffer.Compact()
+}
+
+// Size of the state buffer in bytes
+func (s *State) Size() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.buffer.Offset()
+}
+
+// End the block written in buf and index it. A block which could not be
+// written is removed from index.
+func endBlock[K comparable](s *State, index map[K]*encoding.Block, id K, buf encoding.WritableBlock) error {
+	block := buf.EndBlock()
+	if block == nil {
+		if b := index[id]; b != nil {
+			delete(s.generations, b)
+		}
+		delete(index, id)
+		return buf.Err()
+	}
+	index[id] = block
+	// Blocks written before commit belong to the next generation
+	if block.Changed() {
+		s.generations[block] = s.generation + 1
+	}
+	return nil
+}
+
+func freeBlock[K comparable](s *State, index map[K]*encoding.Block, id K) {
+	if b := index[id]; b != nil {
+		b.Free()
+		delete(s.generations, b)
+		delete(index, id)
+	}
+}
+
+// Free tombstones of deleted lights and instances acknowledged by all sessions
+// (generation lower or equal to acked), or written before generation expired.
+// Returns the number of tombstones freed.
+func (s *State) PruneTombstones(acked uint32, expired uint32) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return pruneTombstones(s, s.lightsDeleted, acked, expired) +
+		pruneTombstones(s, s.sceneObjectInstancesDeleted, acked, expired)
+}
+
+func pruneTombstones[K comparable](s *State, index map[K]*encoding.Block, acked uint32, expired uint32) int {
+	count := 0
+	for id, b := range index {
+		generation := s.generations[b]
+		if generation > acked && generation >= expired {
+			continue
+		}
+		if generation > acked {
+			s.pruned = max(s.pruned, generation)
+		}
+		freeBlock(s, index, id)
+		count++
+	}
+	return count
+}
+
+// Highest generation of tombstones pruned before being acknowledged by all
+// sessions. Sessions behind it may have missed deleted nodes.
+func (s *State) PrunedGeneration() uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.pruned
+}
+
+// Mark blocks written since last commit as a new generation
+// and returns it.
+func (s *State) Commit() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation++
+	return s.generation
+}
+
+// Generation of the last committed state
+func (s *State) Generation() uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.generation
+}
+
+// Copy blocks changed after generation since and returns the number of bytes copied
+func copyBlocks[K comparable](s *State, buf []byte, blocks map[K]*encoding.Block, since uint32) int {
+	return copyBlocksFunc(s, buf, blocks, func(id K, generation uint32) bool { return generation > since })
+}
+
+// Copy blocks for which include returns true and returns the number of bytes copied
+func copyBlocksFunc[K comparable](s *State, buf []byte, blocks map[K]*encoding.Block, include func(id K, generation uint32) bool) int {
+	offset := 0
+	for id, b := range blocks {
+		if include(id, s.generations[b]) {
+			offset += b.Copy(buf[offset:])
+		}
 	}
+	return offset
 }
 
-func (s *State) WriteSceneObjectInstance(obj *scene.Node) {
+func (s *State) WriteSceneObjectInstance(obj *scene.Node) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if obj.Camera != nil {
-		s.writeCamera(obj)
+		return s.writeCamera(obj)
 	} else if obj.Light != nil {
-		s.writeLight(obj)
+		return s.writeLight(obj)
 	} else {
 		var buf encoding.WritableBlock
 
 		if s.sceneObjectInstances[obj.Id] != nil {
 			buf = s.sceneObjectInstances[obj.Id]
 		} else {
+			// Node id is reused, the instance is not deleted anymore
+			freeBlock(s, s.sceneObjectInstancesDeleted, obj.Id)
 			buf = s.buffer
 		}
 
 		// Fallback to generic block
-		buf.NewBlock(uint8(SceneObjectInstanceBlock))
-		buf.PutUint16(uint16(obj.Id))
-		buf.PutUint32(uint32(obj.Object.Id))
-		buf.PutMatrix(obj.Transform.Model())
-		buf.PutVector3Float32(float32(obj.Tint.R)/float32(obj.Tint.A), float32(obj.Tint.G)/float32(obj.Tint.A), float32(obj.Tint.B)/float32(obj.Tint.A))
-
-		if s.sceneObjectInstances[obj.Id] == nil {
-			s.sceneObjectInstances[obj.Id] = buf.EndBlock()
+		block := encoding.SceneObjectInstance{
+			Id:       uint16(obj.Id),
+			ObjectId: uint32(obj.Object.Id),
+			Model:    obj.Transform.Model(),
+			TintR:    float32(obj.Tint.R) / float32(obj.Tint.A),
+			TintG:    float32(obj.Tint.G) / float32(obj.Tint.A),
+			TintB:    float32(obj.Tint.B) / float32(obj.Tint.A),
+		}
+		block.Write(buf)
+
+		if err := endBlock(s, s.sceneObjectInstances, obj.Id, buf); err != nil {
+			return fmt.Errorf("scene object instance %d: %w", obj.Id, err)
 		}
 	}
+	return nil
 }
 
 func (s *State) ReadSceneObjectInstance(obj *scene.Node) *encoding.Block {
@@ -355,143 +531,183 @@ func (s *State) ReadAllSceneObjects() []*encoding.Block {
 	return blocks
 }
 
-func (s *State) CopyTextures(buf []byte) int {
+func (s *State) CopyTextures(buf []byte, since uint32) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	offset := 0
-	for _, b := range s.textures {
-		offset += b.Copy(buf[offset:])
-	}
-	return offset
+	return copyBlocks(s, buf, s.textures, since)
 }
 
-func (s *State) CopySceneObjects(buf []byte) int {
+func (s *State) CopySceneObjects(buf []byte, since uint32) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	offset := 0
-	for _, b := range s.sceneObjects {
-		offset += b.Copy(buf[offset:])
-	}
-	return offset
+	return copyBlocks(s, buf, s.sceneObjects, since)
 }
 
-func (s *State) CopyLights(buf []byte) int {
+func (s *State) CopyLights(buf []byte, since uint32) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	offset := 0
-	for _, b := range s.lights {
-		offset += b.Copy(buf[offset:])
-	}
-	return offset
+	return copyBlocks(s, buf, s.lights, since)
 }
 
-func (s *State) CopyLightsDeleted(buf []byte) int {
+func (s *State) CopyLightsDeleted(buf []byte, since uint32) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	offset := 0
-	for _, b := range s.lightsDeleted {
-		offset += b.Copy(buf[offset:])
-	}
-	return offset
+	return copyBlocks(s, buf, s.lightsDeleted, since)
 }
 
-func (s *State) CopySceneObjectInstancesDeleted(buf []byte) int {
+func (s *State) CopySceneObjectInstancesDeleted(buf []byte, since uint32) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyBlocks(s, buf, s.sceneObjectInstancesDeleted, since)
+}
+
+func (s *State) CopyCamera(buf []byte, id uint32, since uint32) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	offset := 0
-	for _, b := range s.sceneObjectInstancesDeleted {
+	if b := s.cameras[id]; b != nil && s.generations[b] > since {
 		offset += b.Copy(buf[offset:])
 	}
 	return offset
 }
 
-func (s *State) CopyCamera(buf []byte, id uint32) int {
+func (s *State) CopySceneObjectInstances(buf []byte, since uint32) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	offset := 0
-	if s.cameras[id] != nil {
-		offset += s.cameras[id].Copy(buf[offset:])
-	}
-	return offset
+	return copyBlocks(s, buf, s.sceneObjectInstances, since)
 }
 
-func (s *State) CopySceneObjectInstances(buf []byte) int {
+// Copy instances for which include returns true, given the id of the
+// instance and the generation it was last written
+func (s *State) CopySceneObjectInstancesFunc(buf []byte, include func(id uint32, generation uint32) bool) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	offset := 0
-	for _, b := range s.sceneObjectInstances {
-		offset += b.Copy(buf[offset:])
-	}
-	return offset
+	return copyBlocksFunc(s, buf, s.sceneObjectInstances, include)
 }
 
-func (s *State) GetTextureRGBA(id int) (*image.RGBA, error) {
-	if s.textures[id] == nil {
-		return nil, fmt.Errorf("texture with id %v not found", id)
-	}
+// Description of a texture group written in the state
+type TextureInfo struct {
+	Id     int `json:"id"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Number of textures in the group
+	Depth  int                    `json:"depth"`
+	Format rendering.TextureModel `json:"format"`
+	Role   rendering.TextureRole  `json:"role"`
+}
 
-	b := s.textures[id]
-	b.Reset()
+// List texture groups written in the state, ordered by id
+func (s *State) Textures() []TextureInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	_, width, height, depth, model, _ := b.Uint8(), b.Uint16(), b.Uint16(), b.Uint8(), b.Uint8(), b.Uint8()
+	textures := make([]TextureInfo, 0, len(s.textures))
+	for _, id := range slices.Sorted(maps.Keys(s.textures)) {
+		b := s.textures[id]
+
+		// Fields are read at their offset, to not move the offset of the block
+		textures = append(textures, TextureInfo{
+			Id:     id,
+			Width:  int(b.Uint16At(1)),
+			Height: int(b.Uint16At(3)),
+			Depth:  int(b.Uint8At(5)),
+			Format: rendering.TextureModel(b.Uint8At(6)),
+			Role:   rendering.TextureRole(b.Uint8At(7)),
+		})
+	}
+	return textures
+}
 
-	if model != uint8(rendering.RGBA) {
-		return nil, fmt.Errorf("texture %v is not in rgba format", id)
+// Decode a texture group from a copy of its block, read without the lock
+func (s *State) readTexture(id int) (*encoding.Texture, error) {
+	s.mu.RLock()
+	var buf []byte
+	if b := s.textures[id]; b != nil {
+		buf = make([]byte, b.Size())
+		b.Copy(buf)
 	}
+	s.mu.RUnlock()
 
-	pixels := make([]uint8, b.Uint32())
-	for i := range len(pixels) {
-		pixels[i] = b.Uint8()
+	if buf == nil {
+		return nil, fmt.Errorf("texture with id %v not found", id)
 	}
-
-	img := image.NewRGBA(image.Rectangle{image.Pt(0, 0), image.Pt(int(width), int(height)*int(depth))})
-	copy(img.Pix, pixels)
-
-	return img, nil
+	r := encoding.NewBlockReader(buf)
+	if !r.Next() {
+		return nil, fmt.Errorf("texture %v: %w", id, r.Err())
+	}
+	texture, ok := r.Block().(*encoding.Texture)
+	if !ok {
+		return nil, fmt.Errorf("texture %v: %w", id, encoding.ErrMalformedBlock)
+	}
+	return texture, nil
 }
 
-func (s *State) GetTextureGrayScale(id int) (*image.Gray, error) {
-	if s.textures[id] == nil {
+// Return a texture group as an image, with the colors of the palette
+// texture group for diffuse textures
+func (s *State) GetTexture(id int) (image.Image, error) {
+	textures := s.Textures()
+	index := slices.IndexFunc(textures, func(t TextureInfo) bool { return t.Id == id })
+	if index == -1 {
 		return nil, fmt.Errorf("texture with id %v not found", id)
 	}
 
-	b := s.textures[id]
-	b.Reset()
-
-	_, width, height, depth, model, _ := b.Uint8(), b.Uint16(), b.Uint16(), b.Uint8(), b.Uint8(), b.Uint8()
-
-	if model != uint8(rendering.ALPHA) {
-		return nil, fmt.Errorf("texture %v is not in alpha format", id)
+	texture := textures[index]
+	switch {
+	case texture.Format == rendering.RGBA:
+		return s.GetTextureRGBA(id)
+	case texture.Format == rendering.ALPHA && texture.Role == rendering.Diffuse:
+		paletteIndex := slices.IndexFunc(textures, func(t TextureInfo) bool { return t.Role == rendering.TPalette })
+		if paletteIndex == -1 {
+			return nil, fmt.Errorf("texture %v has no palette", id)
+		}
+		palette, err := s.GetTextureRGBA(textures[paletteIndex].Id)
+		if err != nil {
+			return nil, err
+		}
+		return s.GetTexturePaletted(id, palette)
+	case texture.Format == rendering.ALPHA:
+		return s.GetTextureGrayScale(id)
 	}
+	return nil, fmt.Errorf("texture %v has unsupported format %v", id, texture.Format)
+}
 
-	pixels := make([]uint8, b.Uint32())
-	for i := range len(pixels) {
-		pixels[i] = b.Uint8()
+func (s *State) GetTextureRGBA(id int) (*image.RGBA, error) {
+	texture, err := s.readTexture(id)
+	if err != nil {
+		return nil, err
+	}
+	if texture.Format != uint8(rendering.RGBA) {
+		return nil, fmt.Errorf("texture %v is not in rgba format", id)
 	}
 
-	img := image.NewGray(image.Rectangle{image.Pt(0, 0), image.Pt(int(width), int(height)*int(depth))})
-	copy(img.Pix, pixels)
+	img := image.NewRGBA(image.Rectangle{image.Pt(0, 0), image.Pt(int(texture.Width), int(texture.Height)*int(texture.Depth))})
+	copy(img.Pix, texture.Pixels)
 
 	return img, nil
 }
 
-func (s *State) GetTexturePaletted(id int, rgba *image.RGBA) (*image.Paletted, error) {
-	if s.textures[id] == nil {
-		return nil, fmt.Errorf("texture with id %v not found", id)
+func (s *State) GetTextureGrayScale(id int) (*image.Gray, error) {
+	texture, err := s.readTexture(id)
+	if err != nil {
+		return nil, err
+	}
+	if texture.Format != uint8(rendering.ALPHA) {
+		return nil, fmt.Errorf("texture %v is not in alpha format", id)
 	}
 
-	b := s.textures[id]
-	b.Reset()
+	img := image.NewGray(image.Rectangle{image.Pt(0, 0), image.Pt(int(texture.Width), int(texture.Height)*int(texture.Depth))})
+	copy(img.Pix, texture.Pixels)
 
-	_, width, height, depth, model, _ := b.Uint8(), b.Uint16(), b.Uint16(), b.Uint8(), b.Uint8(), b.Uint8()
+	return img, nil
+}
 
-	if model != uint8(rendering.ALPHA) {
-		return nil, fmt.Errorf("texture %v is not in alpha format", id)
+func (s *State) GetTexturePaletted(id int, rgba *image.RGBA) (*image.Paletted, error) {
+	texture, err := s.readTexture(id)
+	if err != nil {
+		return nil, err
 	}
-
-	pixels := make([]uint8, b.Uint32())
-	for i := range len(pixels) {
-		pixels[i] = b.Uint8()
+	if texture.Format != uint8(rendering.ALPHA) {
+		return nil, fmt.Errorf("texture %v is not in alpha format", id)
 	}
 
 	palette := make(color.Palette, len(rgba.Pix)/4)
@@ -502,10 +718,10 @@ func (s *State) GetTexturePaletted(id int, rgba *image.RGBA) (*image.Paletted, e
 	}
 
 	img := image.NewPaletted(
-		image.Rectangle{image.Pt(0, 0), image.Pt(int(width), int(height)*int(depth))},
+		image.Rectangle{image.Pt(0, 0), image.Pt(int(texture.Width), int(texture.Height)*int(texture.Depth))},
 		palette,
 	)
-	copy(img.Pix, pixels)
+	copy(img.Pix, texture.Pixels)
 
 	return img, nil
 }
@@ -0,0 +1,75 @@
+package simulation
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+)
+
+func TestPruneTombstones(t *testing.T) {
+	state := NewState()
+	object := &scene.SceneObject{Id: 1}
+
+	// Deleted at generation 1
+	state.WriteSceneObjectInstanceDeleted(&scene.Node{Id: 1, Object: object})
+	state.Commit()
+	// Deleted at generation 2
+	state.WriteSceneObjectInstanceDeleted(&scene.Node{Id: 2, Object: object})
+	state.Commit()
+
+	buf := make([]byte, 1024)
+	if n := state.CopySceneObjectInstancesDeleted(buf, 1); n == 0 {
+		t.Errorf("expected tombstone written after generation 1 to be copied")
+	}
+
+	if n := state.PruneTombstones(1, 0); n != 1 {
+		t.Errorf("expected 1 acknowledged tombstone to be pruned, got %v", n)
+	}
+	if state.PrunedGeneration() != 0 {
+		t.Errorf("expected no tombstone pruned before acknowledgement, got generation %v", state.PrunedGeneration())
+	}
+
+	if n := state.PruneTombstones(1, 3); n != 1 {
+		t.Errorf("expected 1 expired tombstone to be pruned, got %v", n)
+	}
+	if state.PrunedGeneration() != 2 {
+		t.Errorf("expected pruned generation to be 2, got %v", state.PrunedGeneration())
+	}
+	if n := state.CopySceneObjectInstancesDeleted(buf, 0); n != 0 {
+		t.Errorf("expected no tombstone left, got %v bytes", n)
+	}
+	if state.buffer.BlockCount() != 0 {
+		t.Errorf("expected tombstone blocks to be freed, got %v blocks", state.buffer.BlockCount())
+	}
+}
+
+func TestTexturesConcurrentReads(t *testing.T) {
+	rm := rendering.NewResourceManager()
+	state := NewState()
+	state.UpdateTexture(rm.Palette)
+	state.Commit()
+
+	id := rm.Palette.Group.Id
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				if textures := state.Textures(); len(textures) != 1 || textures[0].Width != 256 {
+					t.Errorf("expected palette listed, got %+v", textures)
+				}
+				if img, err := state.GetTextureRGBA(id); err != nil || img.Bounds().Dx() != 256 {
+					t.Errorf("expected palette image, got error %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := state.GetTextureRGBA(id + 1); err == nil {
+		t.Errorf("expected error for missing texture")
+	}
+}
@@ -0,0 +1,79 @@
+package simulation
+
+import "time"
+
+// Statistics of a tick of the main loop
+type TickStats struct {
+	Tick uint32 `json:"tick"`
+	// Time to update all scenes
+	Update time.Duration `json:"update_ns"`
+	// Time to save the state of all scenes
+	Save     time.Duration `json:"save_ns"`
+	Sessions int           `json:"sessions"`
+	// Number of scenes which failed to be saved
+	SaveErrors int          `json:"save_errors"`
+	Scenes     []SceneStats `json:"scenes"`
+}
+
+// Statistics of a scene after a tick
+type SceneStats struct {
+	Name   string `json:"name"`
+	Paused bool   `json:"paused"`
+	Nodes  int    `json:"nodes"`
+	// Pairs of nodes tested for collision
+	CollisionPairs int `json:"collision_pairs"`
+	// Generation of the state
+	Generation uint32 `json:"generation"`
+	// Bytes used in the state buffer and its capacity
+	BufferSize     int `json:"buffer_size"`
+	BufferCapacity int `json:"buffer_capacity"`
+	Blocks         int `json:"blocks"`
+}
+
+func (w *world) stats() SceneStats {
+	return SceneStats{
+		Name:           w.scene.Name,
+		Paused:         w.paused,
+		Nodes:          len(w.scene.Objects()),
+		CollisionPairs: w.scene.CollisionPairs(),
+		Generation:     w.state.Generation(),
+		BufferSize:     w.state.buffer.Offset(),
+		BufferCapacity: w.state.buffer.Capacity(),
+		Blocks:         w.state.buffer.BlockCount(),
+	}
+}
+
+// Statistics of the frames of a session
+type SessionStats struct {
+	Id            string `json:"id"`
+	Scene         string `json:"scene"`
+	BytesSent     uint64 `json:"bytes_sent"`
+	FramesSent    uint64 `json:"frames_sent"`
+	FramesDropped uint64 `json:"frames_dropped"`
+	Fps           int    `json:"fps"`
+}
+
+// Statistics of the frames of the session
+func (s *Session) Stats() SessionStats {
+	fps, _ := s.Fps()
+	return SessionStats{
+		Id:            s.Id,
+		Scene:         s.Scene().Name,
+		BytesSent:     s.bytesSent.Load(),
+		FramesSent:    s.framesSent.Load(),
+		FramesDropped: s.framesDropped.Load(),
+		Fps:           fps,
+	}
+}
+
+// Statistics of opened sessions
+func (s *Simulation) SessionStats() []SessionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]SessionStats, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		stats = append(stats, session.Stats())
+	}
+	return stats
+}
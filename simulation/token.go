@@ -0,0 +1,40 @@
+package simulation
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// Length of the secrets generated for simulations
+const SECRET_SIZE = 32
+
+func newSecret() []byte {
+	secret := make([]byte, SECRET_SIZE)
+	rand.Read(secret)
+	return secret
+}
+
+// Sign a message with the secret of the simulation
+func (s *Simulation) sign(message string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(message))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify a signature returned by sign()
+func (s *Simulation) verify(message string, signature string) bool {
+	expected, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(message))
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Token to resume the session with ResumeSession()
+func (s *Session) ResumeToken() string {
+	return s.sim.sign("resume:" + s.Id)
+}
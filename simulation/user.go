@@ -0,0 +1,291 @@
+package simulation
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/geotry/stago/compute"
+	"github.com/geotry/stago/scene"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// Storage of the state of users between sessions
+type UserStore interface {
+	// Return the state of a user, or ErrUserNotFound
+	Load(userId string) (*UserState, error)
+	Save(userId string, state *UserState) error
+}
+
+// State of a user when its last session was closed
+type UserState struct {
+	// Name of the scene of the session
+	Scene string `json:"scene"`
+	// Position of the camera of the session
+	Position compute.Vector3 `json:"position"`
+	Camera   *UserCamera     `json:"camera,omitempty"`
+	// Values set with Session.SetValue()
+	Values map[string]string `json:"values,omitempty"`
+}
+
+// Settings of the camera of a user
+type UserCamera struct {
+	Projection     scene.CameraProjection `json:"projection"`
+	Fov            float64                `json:"fov"`
+	Near           float64                `json:"near"`
+	Far            float64                `json:"far"`
+	Scale          float64                `json:"scale"`
+	InterestRadius float64                `json:"interest_radius"`
+	PitchYawRoll   compute.Vector3        `json:"pitch_yaw_roll"`
+}
+
+// Store of users with a JSON file per user in a directory
+type FileUserStore struct {
+	dir string
+}
+
+// Create a store in dir, created on first save
+func NewFileUserStore(dir string) *FileUserStore {
+	return &FileUserStore{dir: dir}
+}
+
+// File of a user, with an encoded name to accept any user id
+func (f *FileUserStore) path(userId string) string {
+	return filepath.Join(f.dir, base64.RawURLEncoding.EncodeToString([]byte(userId))+".json")
+}
+
+func (f *FileUserStore) Load(userId string) (*UserState, error) {
+	data, err := os.ReadFile(f.path(userId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("load user %s: %w", userId, ErrUserNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load user %s: %w", userId, err)
+	}
+
+	var state UserState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("load user %s: %w", userId, err)
+	}
+	return &state, nil
+}
+
+// Write the state of a user in a temporary file renamed once complete
+func (f *FileUserStore) Save(userId string, state *UserState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("save user %s: %w", userId, err)
+	}
+	if err := os.MkdirAll(f.dir, 0o755); err != nil {
+		return fmt.Errorf("save user %s: %w", userId, err)
+	}
+
+	tmp, err := os.CreateTemp(f.dir, "user-*.tmp")
+	if err != nil {
+		return fmt.Errorf("save user %s: %w", userId, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.path(userId))
+	}
+	if err != nil {
+		return fmt.Errorf("save user %s: %w", userId, err)
+	}
+	return nil
+}
+
+// Writes the state of users in a store from another goroutine, so the
+// main loop does not wait for the store. Only the last state of a user
+// waiting to be written is written.
+type userWriter struct {
+	store UserStore
+	// States waiting to be written, by user id
+	pending map[string]*UserState
+	wakeup  chan struct{}
+
+	mu sync.Mutex
+}
+
+func newUserWriter(store UserStore) *userWriter {
+	return &userWriter{
+		store:   store,
+		pending: make(map[string]*UserState),
+		wakeup:  make(chan struct{}, 1),
+	}
+}
+
+// Queue the state of a user to be written
+func (w *userWriter) save(userId string, state *UserState) {
+	w.mu.Lock()
+	w.pending[userId] = state
+	w.mu.Unlock()
+
+	select {
+	case w.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Return the state of a user waiting to be written, or the state in the store
+func (w *userWriter) load(userId string) (*UserState, error) {
+	w.mu.Lock()
+	state := w.pending[userId]
+	w.mu.Unlock()
+
+	if state != nil {
+		return state, nil
+	}
+	return w.store.Load(userId)
+}
+
+// Write queued states until ctx is done, and the states queued before
+func (w *userWriter) run(ctx context.Context) {
+	for {
+		select {
+		case <-w.wakeup:
+			w.flush()
+		case <-ctx.Done():
+			w.flush()
+			return
+		}
+	}
+}
+
+// Write the states waiting to be written. A state is waiting until
+// it is written, to be loaded meanwhile.
+func (w *userWriter) flush() {
+	w.mu.Lock()
+	pending := maps.Clone(w.pending)
+	w.mu.Unlock()
+
+	for userId, state := range pending {
+		if err := w.store.Save(userId, state); err != nil {
+			log.Printf("user_id=%s error=%v", userId, err)
+		}
+		w.mu.Lock()
+		if w.pending[userId] == state {
+			delete(w.pending, userId)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Load the state of a user, nil if the user has no state. Called out of
+// the main loop, as the store may be slow.
+func (s *Simulation) loadUser(userId string) *UserState {
+	if s.users == nil || userId == "" {
+		return nil
+	}
+	state, err := s.users.load(userId)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			log.Printf("user_id=%s error=%v", userId, err)
+		}
+		return nil
+	}
+	return state
+}
+
+// Queue the state of the user of a session to be saved
+func (s *Simulation) saveUser(session *Session) {
+	if s.users == nil || session.UserId == "" {
+		return
+	}
+	s.users.save(session.UserId, session.userState())
+}
+
+// Queue the state of the users of opened sessions to be saved, to not
+// lose them if the server stops before their sessions are closed
+func (s *Simulation) saveUsers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if session.Count > 0 {
+			s.saveUser(session)
+		}
+	}
+}
+
+// State of the user of the session
+func (s *Session) userState() *UserState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := &UserState{
+		Scene:    s.world.scene.Name,
+		Position: s.Root.Transform.Position,
+		Values:   maps.Clone(s.values),
+	}
+	if c := s.Root.Camera; c != nil {
+		state.Camera = &UserCamera{
+			Projection:     c.Projection,
+			Fov:            c.Fov,
+			Near:           c.Near,
+			Far:            c.Far,
+			Scale:          c.Scale,
+			InterestRadius: c.InterestRadius,
+			PitchYawRoll:   c.PitchYawRoll(),
+		}
+	}
+	return state
+}
+
+// Apply the state of the user to the session. The camera is moved once
+// spawned, after its controller is initialized.
+func (s *Session) restoreUser(state *UserState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state.Values != nil {
+		s.values = maps.Clone(state.Values)
+	}
+
+	root := s.Root
+	if c := state.Camera; c != nil && root.Camera != nil {
+		root.Camera.Projection, root.Camera.Scale = c.Projection, c.Scale
+		root.Camera.Near, root.Camera.Far = c.Near, c.Far
+		root.Camera.InterestRadius = c.InterestRadius
+		root.Camera.SetFov(c.Fov)
+	}
+	s.world.scene.Schedule(func() {
+		root.Transform.Position = state.Position
+		if c := state.Camera; c != nil && root.Camera != nil {
+			root.Camera.SetPitchYawRoll(c.PitchYawRoll)
+		}
+	})
+}
+
+// Value saved with the state of the user of the session
+func (s *Session) Value(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Set a value saved with the state of the user of the session
+func (s *Session) SetValue(key string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.values == nil {
+		s.values = make(map[string]string)
+	}
+	s.values[key] = value
+}
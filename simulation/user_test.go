@@ -0,0 +1,157 @@
+package simulation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/geotry/stago/compute"
+	"github.com/geotry/stago/rendering"
+	"github.com/geotry/stago/scene"
+)
+
+func TestUserState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	users := NewFileUserStore(t.TempDir())
+	if _, err := users.Load("../user"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	clock := scene.NewManualClock(time.Unix(0, 0))
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{Clock: clock, GracePeriod: -1, Users: users})
+	for _, name := range []string{"a", "b"} {
+		sim.AddScene(scene.NewScene(scene.SceneOptions{Name: name, Camera: &scene.CameraSettings{Projection: scene.Perspective}}))
+	}
+	sim.Start(ctx)
+
+	session, _, err := sim.OpenSession("first", "../user", "b")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	sim.RunTicks(1)
+	if user := session.Root.User(); user != "../user" {
+		t.Errorf("expected camera of user, got %q", user)
+	}
+	session.Root.Transform.Position = compute.Vector3{X: 1, Y: 2, Z: 3}
+	session.Root.Camera.SetFov(1)
+	session.SetValue("color", "red")
+	sim.CloseSession("first")
+
+	session, _, err = sim.OpenSession("second", "../user", "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	sim.RunTicks(1)
+	if name := session.Scene().Name; name != "b" {
+		t.Errorf("expected user in its last scene b, got %s", name)
+	}
+	if p := session.Root.Transform.Position; p != (compute.Vector3{X: 1, Y: 2, Z: 3}) {
+		t.Errorf("expected camera restored at its last position, got %v", p)
+	}
+	if session.Root.Camera.Fov != 1 {
+		t.Errorf("expected camera settings restored, got fov %v", session.Root.Camera.Fov)
+	}
+	if value, ok := session.Value("color"); !ok || value != "red" {
+		t.Errorf("expected value restored, got %q", value)
+	}
+}
+
+func TestUserSavedPeriodically(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	users := NewFileUserStore(t.TempDir())
+	clock := scene.NewManualClock(time.Unix(0, 0))
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{TickRate: 10, Clock: clock, Users: users, UserSaveInterval: time.Second})
+	sim.AddScene(scene.NewScene(scene.SceneOptions{
+		Name:   "test",
+		Camera: &scene.CameraSettings{Projection: scene.Perspective},
+		CameraController: &scene.SceneObjectController{
+			Update: func(self *scene.Node, deltaTime time.Duration) {
+				if values := self.Values(); values != nil {
+					values.SetValue("visited", "yes")
+				}
+			},
+		},
+	}))
+	sim.Start(ctx)
+
+	if _, _, err := sim.OpenSession("test", "user", ""); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	sim.RunTicks(5)
+	if _, err := users.Load("user"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected user not saved before the interval, got %v", err)
+	}
+
+	// User is written by another goroutine
+	sim.RunTicks(5)
+	deadline := time.Now().Add(time.Second)
+	for {
+		state, err := users.Load("user")
+		if err == nil {
+			if state.Values["visited"] != "yes" {
+				t.Errorf("expected value set by controller to be saved, got %v", state.Values)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected user of opened session to be saved, got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Store waiting for release before loading users
+type slowUserStore struct {
+	UserStore
+	release chan struct{}
+}
+
+func (s *slowUserStore) Load(userId string) (*UserState, error) {
+	<-s.release
+	return s.UserStore.Load(userId)
+}
+
+func TestUserLoadedOutOfLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	users := &slowUserStore{UserStore: NewFileUserStore(t.TempDir()), release: make(chan struct{})}
+	users.UserStore.Save("user", &UserState{Scene: "test", Position: compute.Vector3{X: 1}})
+
+	clock := scene.NewManualClock(time.Unix(0, 0))
+	sim := NewSimulation(rendering.NewResourceManager(), SimulationOptions{Clock: clock, Users: users})
+	sim.AddScene(scene.NewScene(scene.SceneOptions{Name: "test", Camera: &scene.CameraSettings{Projection: scene.Perspective}}))
+	sim.Start(ctx)
+
+	opened := make(chan *Session)
+	go func() {
+		session, _, err := sim.OpenSession("test", "user", "")
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		opened <- session
+	}()
+
+	ticked := make(chan struct{})
+	go func() {
+		sim.RunTicks(1)
+		close(ticked)
+	}()
+	select {
+	case <-ticked:
+	case <-time.After(time.Second):
+		t.Fatal("expected ticks to run while the user is loaded")
+	}
+
+	close(users.release)
+	session := <-opened
+	sim.RunTicks(1)
+	if p := session.Root.Transform.Position; p != (compute.Vector3{X: 1}) {
+		t.Errorf("expected camera restored once the user is loaded, got %v", p)
+	}
+}
@@ -1,8 +1,19 @@
+import { Block, ProtocolVersion, schema } from "./schema.js";
 
 /**
  * @typedef {{[BlockTypeSymbol]: number}} GenericBuffer
  */
 
+/**
+ * @typedef {{
+ *  version: number,
+ *  tick: number,
+ *  sequence: number,
+ *  timestamp: number,
+ *  keyframe: boolean,
+ * }} FrameHeaderBuffer
+ */
+
 /**
  * @typedef {{
  *  id: number,
@@ -55,6 +66,12 @@
  * }} SceneNodeDeletedBuffer
  */
 
+/**
+ * @typedef {{
+ *  id: number,
+ * }} SceneNodeLeftBuffer
+ */
+
 /**
  * @typedef {{
  *  id: number,
@@ -86,94 +103,20 @@
  * }} SceneLightDeletedBuffer
  */
 
-const Block = Object.freeze({
-  TEXTURE: 0,
-  CAMERA: 1,
-  SCENE_OBJECT: 2,
-  SCENE_OBJECT_INSTANCE: 3,
-  SCENE_OBJECT_INSTANCE_DELETED: 6,
-  LIGHT: 4,
-  LIGHT_DELETED: 5,
-});
-
-const schema = {
-  [Block.TEXTURE]: {
-    id: "uint8",
-    width: "uint16",
-    height: "uint16",
-    depth: "uint8",
-    format: "uint8",
-    role: "uint8",
-    pixels: "uint8[]",
-  },
-  [Block.CAMERA]: {
-    id: "uint16",
-    viewMatrix: "float32[]",
-    projectionMatrix: "float32[]",
-  },
-  // [Block.CAMERA_PROJECTION]: {
-  //   cameraId: "uint16",
-  //   projectionMatrix: "float32[]",
-  // },
-  [Block.SCENE_OBJECT]: {
-    id: "uint32",
-    diffuseIndex: "uint8",
-    specularIndex: "uint8",
-    shininess: "float32",
-    opaque: "boolean",
-    space: "uint8", // 0: World, 1: Screen
-    vertices: "float32[]",
-    uv: "float32[]",
-    normals: "float32[]",
-  },
-  [Block.SCENE_OBJECT_INSTANCE]: {
-    id: "uint16",
-    objectId: "uint32",
-    model: "float32[]",
-    tintR: "float32",
-    tintG: "float32",
-    tintB: "float32",
-  },
-  [Block.SCENE_OBJECT_INSTANCE_DELETED]: {
-    id: "uint16",
-    objectId: "uint32",
-  },
-  [Block.LIGHT]: {
-    id: "uint16",
-    // 0: directional
-    // 1: point
-    // 2: spot
-    type: "uint8",
-    ambientR: "float32",
-    ambientG: "float32",
-    ambientB: "float32",
-    diffuseR: "float32",
-    diffuseG: "float32",
-    diffuseB: "float32",
-    specularR: "float32",
-    specularG: "float32",
-    specularB: "float32",
-    posX: "float32",
-    posY: "float32",
-    posZ: "float32",
-    directionX: "float32",
-    directionY: "float32",
-    directionZ: "float32",
-    radius: "float32",
-    outerCutOff: "float32",
-  },
-  [Block.LIGHT_DELETED]: {
-    id: "uint16",
-    type: "uint8",
-  }
-};
-
 const BlockTypeSymbol = Symbol();
 
 const sceneObjectBlocksEntries = Object.fromEntries(
   Object.keys(schema).map(type => [type, Object.entries(schema[type])])
 );
 
+/**
+ * @param {GenericBuffer} buffer 
+ * @return {buffer is FrameHeaderBuffer}
+ */
+export const assertFrameHeader = (buffer) => {
+  return buffer[BlockTypeSymbol] === Block.FRAME_HEADER;
+};
+
 /**
  * @param {GenericBuffer} buffer 
  * @return {buffer is TextureBuffer}
@@ -214,6 +157,14 @@ export const assertSceneNodeDeleted = (buffer) => {
   return buffer[BlockTypeSymbol] === Block.SCENE_OBJECT_INSTANCE_DELETED;
 };
 
+/**
+ * @param {GenericBuffer} buffer 
+ * @return {buffer is SceneNodeLeftBuffer}
+ */
+export const assertSceneNodeLeft = (buffer) => {
+  return buffer[BlockTypeSymbol] === Block.SCENE_OBJECT_INSTANCE_LEFT;
+};
+
 /**
  * @param {GenericBuffer} buffer 
  * @return {buffer is SceneLightBuffer}
@@ -233,6 +184,7 @@ export const assertSceneLightDeleted = (buffer) => {
 
 /**
  * Decode a buffer message from server and return blocks.
+ * The first block is always the frame header.
  *
  * @param {ArrayBuffer} buffer
  * @returns {Generator<GenericBuffer>}
@@ -244,6 +196,7 @@ export function* decodeBuffer(buffer) {
 
   const view = new DataView(buffer);
   let offset = 0;
+  let isFirstBlock = true;
 
   while (offset < buffer.byteLength) {
     const blockType = view.getUint8(offset);
@@ -297,6 +250,10 @@ export function* decodeBuffer(buffer) {
               value = view.getFloat32(offset, false);
               offset += 4;
               break;
+            case "float64":
+              value = view.getFloat64(offset, false);
+              offset += 8;
+              break;
             case "float32[]": {
               // Read next block to get array size
               const byteSize = view.getUint32(offset, false);
@@ -321,6 +278,16 @@ export function* decodeBuffer(buffer) {
     // Add block type to discriminate it with assert*()
     block[BlockTypeSymbol] = blockType;
 
+    if (isFirstBlock) {
+      if (!assertFrameHeader(block)) {
+        throw new Error(`Frame does not start with a header (block ${blockType})`);
+      }
+      if (block.version !== ProtocolVersion) {
+        throw new Error(`Frame has protocol version ${block.version}, expected ${ProtocolVersion}`);
+      }
+      isFirstBlock = false;
+    }
+
     yield block;
   }
 };
@@ -48,12 +48,18 @@ const main = () => {
         break;
       }
 
+      case "userToken": {
+        localStorage.setItem("userToken", e.data[1]);
+        break;
+      }
+
       case "stats": {
         const frameTimeAvg = e.data[1].toFixed(0);
         const frameTimeMin = e.data[2].toFixed(0);
         const frameTimeMax = e.data[3].toFixed(0);
         const fps = e.data[4].toFixed(0);
         const glRenderTime = e.data[5];
+        const rtt = e.data[8].toFixed(0);
 
         let bandwidthDown = e.data[4] * e.data[6];
         if (bandwidthDown < 1024) {
@@ -73,7 +79,7 @@ const main = () => {
           bandwidthUp = `${(bandwidthUp / 1024 / 1024).toFixed(2)}mb`;
         }
 
-        document.querySelector("#stats").textContent = `fps: ${fps} | min: ${frameTimeMin}ms | max: ${frameTimeMax}ms | avg: ${frameTimeAvg}ms | render: ${glRenderTime}ms | ↓ ${bandwidthDown}/s | ↑ ${bandwidthUp}/s`;
+        document.querySelector("#stats").textContent = `fps: ${fps} | min: ${frameTimeMin}ms | max: ${frameTimeMax}ms | avg: ${frameTimeAvg}ms | render: ${glRenderTime}ms | ↓ ${bandwidthDown}/s | ↑ ${bandwidthUp}/s | rtt: ${rtt}ms`;
         break;
       }
     }
@@ -84,7 +90,10 @@ const main = () => {
   offscreen.width = canvas.clientWidth * devicePixelRatio;
   offscreen.height = canvas.clientHeight * devicePixelRatio;
 
-  worker.postMessage(["setup", offscreen], [offscreen]);
+  // Token of the last session, identifying the user to restore its state
+  const userToken = localStorage.getItem("userToken");
+
+  worker.postMessage(["setup", offscreen, userToken], [offscreen]);
 
   setInterval(() => {
     worker.postMessage(["stats"]);
@@ -181,7 +190,24 @@ const setupDOMInputEvents = (worker) => {
     code.startsWith("Enter") ||
     code === "Delete"
 
+  // Debug hotkeys to freeze the scene and update it tick by tick
+  let paused = false;
+  const timeScales = { "BracketLeft": 0.25, "BracketRight": 4, "Backslash": 1 };
+
   window.addEventListener("keydown", e => {
+    if (e.code === "Pause") {
+      paused = !paused;
+      worker.postMessage(["timeControl", paused ? { pause: true } : { resume: true }]);
+      return;
+    }
+    if (e.code === "Period" && paused) {
+      worker.postMessage(["timeControl", { step: 1 }]);
+      return;
+    }
+    if (timeScales[e.code] !== undefined) {
+      worker.postMessage(["timeControl", { time_scale: timeScales[e.code] }]);
+      return;
+    }
     if (filterKey(e.code)) {
       worker.postMessage(["keydown", e.code]);
     }
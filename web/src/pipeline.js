@@ -1,5 +1,5 @@
 const { createScene } = require("./scene.js");
-const { decodeBuffer, assertSceneLight, assertTexture, assertSceneLightDeleted, assertCamera, assertSceneNodeDeleted, assertSceneObject, assertSceneNode, TextureBuffer } = require("./decoder.js");
+const { decodeBuffer, assertFrameHeader, assertSceneLight, assertTexture, assertSceneLightDeleted, assertCamera, assertSceneNodeDeleted, assertSceneNodeLeft, assertSceneObject, assertSceneNode, TextureBuffer } = require("./decoder.js");
 const { mat4, vec3 } = require("wgpu-matrix");
 
 /**
@@ -49,6 +49,8 @@ export const createWebGPUPipeline = (context, device, format, config) => {
   const options = {};
 
   let frame = 0;
+  // Sequence number of the last frame received from server
+  let sequence = 0;
   let renderTime = 0;
   let deltaTime = 0;
 
@@ -160,6 +162,7 @@ export const createWebGPUPipeline = (context, device, format, config) => {
           console.log("[pipeline] reset");
           scene = createScene();
           frame = 0;
+          sequence = 0;
           renderTime = 0;
         },
         setOption(option, value) {
@@ -199,12 +202,24 @@ export const createWebGPUPipeline = (context, device, format, config) => {
          * Handle a new message from the server.
          *
          * @param {ArrayBuffer} buffer 
+         * @returns {number} Sequence number of the frame
          */
         update(buffer) {
           scene.update();
 
           for (const block of decodeBuffer(buffer)) {
             switch (true) {
+              case assertFrameHeader(block): {
+                if (sequence > 0 && block.sequence > sequence + 1) {
+                  console.warn(`[pipeline] ${block.sequence - sequence - 1} frames dropped (tick=${block.tick})`);
+                }
+                sequence = block.sequence;
+                // Server sends all blocks, discard the current state
+                if (block.keyframe) {
+                  scene = createScene();
+                }
+                break;
+              }
               case assertTexture(block): {
                 if (config.updateTexture) {
                   config.updateTexture(block);
@@ -242,6 +257,10 @@ export const createWebGPUPipeline = (context, device, format, config) => {
                 scene.deleteNode(block.id);
                 break;
               }
+              case assertSceneNodeLeft(block): {
+                scene.leaveNode(block.id);
+                break;
+              }
               case assertSceneLight(block): {
                 // Compute view projection matrix of light
                 let lightViewProjMatrix;
@@ -276,6 +295,8 @@ export const createWebGPUPipeline = (context, device, format, config) => {
               }
             }
           }
+
+          return sequence;
         },
 
         /**
@@ -0,0 +1,96 @@
+const messages = require("./pb/server_pb.js");
+const inputs = require("./pb/input_pb.js");
+
+// Setters of the fields of messages, by name of the field in JSON
+const renderRequestFields = {
+  session_id: "setSessionId",
+  user_id: "setUserId",
+  fps: "setFps",
+  width: "setWidth",
+  height: "setHeight",
+  near: "setNear",
+  far: "setFar",
+  fov: "setFov",
+  schema_hash: "setSchemaHash",
+  keyframe: "setKeyframe",
+  scene: "setScene",
+  resume_token: "setResumeToken",
+};
+
+const timeControlFields = {
+  pause: "setPause",
+  resume: "setResume",
+  step: "setStep",
+  time_scale: "setTimeScale",
+};
+
+const inputEventFields = {
+  session_id: "setSessionId",
+  device: "setDevice",
+  code: "setCode",
+  x: "setX",
+  y: "setY",
+  pressed: "setPressed",
+  released: "setReleased",
+  scrolled: "setScrolled",
+  delta: "setDelta",
+  deltaX: "setDeltax",
+  deltaY: "setDeltay",
+};
+
+/**
+ * Set the fields of a message from an object.
+ *
+ * @template T
+ * @param {T} message
+ * @param {Record<string, string>} fields
+ * @param {Record<string, unknown>} values
+ * @returns {T}
+ */
+const assign = (message, fields, values) => {
+  Object.entries(values).forEach(([key, value]) => {
+    if (value !== undefined && fields[key]) {
+      message[fields[key]](value);
+    }
+  });
+  return message;
+};
+
+/**
+ * @param {Record<string, unknown>} event
+ */
+const encodeInputEvent = (event) => assign(new inputs.InputEvent(), inputEventFields, event);
+
+/**
+ * @param {Record<string, unknown>} request
+ */
+const encodeRenderRequest = (request) => {
+  const message = assign(new messages.RenderRequest(), renderRequestFields, request);
+  if (request.time_control) {
+    message.setTimeControl(assign(new messages.TimeControl(), timeControlFields, request.time_control));
+  }
+  return message;
+};
+
+/**
+ * Encode a message of the "stago" protocol in protobuf.
+ *
+ * @param {{id: number, render?: Record<string, unknown>, input?: Record<string, unknown>, inputs?: {events: Record<string, unknown>[]}, ack?: number, ping?: {time: number}}} message
+ * @returns {Uint8Array}
+ */
+export const encodeClientMessage = (message) => {
+  const msg = new messages.ClientMessage();
+  msg.setId(message.id);
+  if (message.render) {
+    msg.setRender(encodeRenderRequest(message.render));
+  } else if (message.input) {
+    msg.setInput(encodeInputEvent(message.input));
+  } else if (message.inputs) {
+    msg.setInputs(new messages.InputBatch().setEventsList(message.inputs.events.map(encodeInputEvent)));
+  } else if (message.ack) {
+    msg.setAck(message.ack);
+  } else if (message.ping) {
+    msg.setPing(new messages.Ping().setTime(message.ping.time));
+  }
+  return msg.serializeBinary();
+};
@@ -49,6 +49,7 @@
  *  offset: number,
  *  objectOffset: number,
  *  tint: ColorRGBA,
+ *  hidden?: boolean,
  * }} SceneNode
  */
 
@@ -149,8 +150,26 @@ export const createScene = () => {
     } else {
       Object.entries(data).forEach(([key, value]) => {
         object[key] = value;
-        // todo: update vertex offset and count if vertices change
       });
+      const vertexCount = data.vertices ? data.vertices.length / 3 : object.vertexCount;
+      if (vertexCount !== object.vertexCount) {
+        // Vertices of all objects are contiguous, move the next objects
+        let vertexOffset = 0;
+        for (const [_, obj] of objects) {
+          if (obj === object) {
+            obj.vertexCount = vertexCount;
+          }
+          if (obj.vertexOffset !== vertexOffset || obj === object) {
+            obj.vertexOffset = vertexOffset;
+            if (!newObjects.includes(obj)) {
+              newObjects.push(obj);
+            }
+          }
+          vertexOffset += obj.vertexCount;
+        }
+      } else if (!newObjects.includes(object)) {
+        newObjects.push(object);
+      }
     }
   };
 
@@ -171,6 +190,20 @@ export const createScene = () => {
       Object.entries(data).forEach(([key, value]) => {
         node[key] = value;
       });
+      node.hidden = false;
+    }
+  };
+
+  /**
+   * Hide a node which is not relevant to the camera anymore.
+   * The node is shown again on its next update.
+   *
+   * @param {number} id 
+   */
+  const leaveNode = (id) => {
+    const node = nodes.get(id);
+    if (node) {
+      node.hidden = true;
     }
   };
 
@@ -239,7 +272,7 @@ export const createScene = () => {
     if (!nodesByObject.has(object.id)) {
       return [];
     }
-    return Array.from(nodesByObject.get(object.id));
+    return Array.from(nodesByObject.get(object.id)).filter(node => !node.hidden);
   };
 
   const update = () => {
@@ -262,6 +295,7 @@ export const createScene = () => {
     getCamera,
     deleteLight,
     deleteNode,
+    leaveNode,
     getDirectionalLight,
     updateLight,
     updateNode,
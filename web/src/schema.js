@@ -0,0 +1,106 @@
+// Code generated by schemagen from schema.Blocks. DO NOT EDIT.
+
+/**
+ * Hash of the binary layout of blocks, must match the server hash.
+ */
+export const SchemaHash = 0x0e096898;
+
+/**
+ * Version of the frame format, see FrameHeader block.
+ */
+export const ProtocolVersion = 1;
+
+export const Block = Object.freeze({
+  TEXTURE: 0,
+  CAMERA: 1,
+  SCENE_OBJECT: 2,
+  SCENE_OBJECT_INSTANCE: 3,
+  LIGHT: 4,
+  LIGHT_DELETED: 5,
+  SCENE_OBJECT_INSTANCE_DELETED: 6,
+  FRAME_HEADER: 7,
+  SCENE_OBJECT_INSTANCE_LEFT: 8,
+});
+
+export const schema = {
+  [Block.TEXTURE]: {
+    id: "uint8",
+    width: "uint16",
+    height: "uint16",
+    depth: "uint8",
+    format: "uint8",
+    role: "uint8",
+    pixels: "uint8[]",
+  },
+  [Block.CAMERA]: {
+    id: "uint16",
+    viewMatrix: "float32[]",
+    projectionMatrix: "float32[]",
+  },
+  [Block.SCENE_OBJECT]: {
+    id: "uint32",
+    diffuseIndex: "uint8",
+    specularIndex: "uint8",
+    shininess: "float32",
+    opaque: "boolean",
+    // 0: World, 1: Screen
+    space: "uint8",
+    vertices: "float32[]",
+    uv: "float32[]",
+    normals: "float32[]",
+  },
+  [Block.SCENE_OBJECT_INSTANCE]: {
+    id: "uint16",
+    objectId: "uint32",
+    model: "float32[]",
+    tintR: "float32",
+    tintG: "float32",
+    tintB: "float32",
+  },
+  [Block.LIGHT]: {
+    id: "uint16",
+    // 0: directional, 1: point, 2: spot
+    type: "uint8",
+    ambientR: "float32",
+    ambientG: "float32",
+    ambientB: "float32",
+    diffuseR: "float32",
+    diffuseG: "float32",
+    diffuseB: "float32",
+    specularR: "float32",
+    specularG: "float32",
+    specularB: "float32",
+    posX: "float32",
+    posY: "float32",
+    posZ: "float32",
+    directionX: "float32",
+    directionY: "float32",
+    directionZ: "float32",
+    // Radius of point lights, cut-off of spot lights
+    radius: "float32",
+    outerCutOff: "float32",
+  },
+  [Block.LIGHT_DELETED]: {
+    id: "uint16",
+    type: "uint8",
+  },
+  [Block.SCENE_OBJECT_INSTANCE_DELETED]: {
+    id: "uint16",
+    objectId: "uint32",
+  },
+  [Block.FRAME_HEADER]: {
+    version: "uint16",
+    // Tick of the simulation when the frame was rendered
+    tick: "uint32",
+    // Sequence number of the frame in the session, starting at 1
+    sequence: "uint32",
+    // Server time in milliseconds since epoch
+    timestamp: "float64",
+    // Frame contains all blocks, previous state of the client must be discarded
+    keyframe: "boolean",
+  },
+  [Block.SCENE_OBJECT_INSTANCE_LEFT]: {
+    // Instance is not relevant to the session anymore, it is sent again when it enters
+    id: "uint16",
+  },
+};
@@ -66,9 +66,10 @@ export const createContext = async (canvas, ctx) => {
     },
     /**
      * @param {ArrayBuffer} buffer 
+     * @returns {number} Sequence number of the frame
      */
     handle(buffer) {
-      pipeline.update(buffer);
+      return pipeline.update(buffer);
     },
     render() {
       pipeline.render();
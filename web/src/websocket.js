@@ -1,17 +1,45 @@
 const webgl = require("./webgl.js");
+const { SchemaHash } = require("./schema.js");
+const { encodeClientMessage } = require("./protocol.js");
 
 const endpoint = "ws://localhost:9090";
 
-const sessionId = (Math.random() + 1).toString(36).substring(7);
+// Issues the token of the session, proxied to the server by webpack
+const tokenEndpoint = "/token";
+
+// Token of the session, binding the connection to the session and its role
+let token = "";
+
+// Token of a previous session, identifying the user to the server
+let userToken = "";
+let onUserToken = (/** @type {string} */ token) => {};
 
 /**
  * @type {WebSocket}
  */
-let renderWs, inputWs;
+let ws;
+
+// Identifier of the last message sent, returned in errors of the server
+let messageId = 0;
+
+// Messages are sent in protobuf when the server accepts "stago.proto"
+let binary = false;
+
+// Input events are sent at once after this delay in milliseconds
+const inputBatchDelay = 8;
+let pendingInputs = [];
+let inputTimer;
+
+// Interval between two pings measuring the round trip time
+const pingInterval = 1000;
+let pingTimer;
+
+// Token returned by server to resume the session after a reconnection
+let resumeToken = "";
 
 const options = {
-  session_id: sessionId,
   fps: 60,
+  schema_hash: SchemaHash,
 };
 
 export const RenderStatistics = {
@@ -22,6 +50,7 @@ export const RenderStatistics = {
   render: 0,
   bytesDownAvg: 0,
   bytesUpAvg: 0,
+  rtt: 0,
 };
 
 let frame = 0;
@@ -30,25 +59,117 @@ const frameDownByteLength = Array(60 * 5).fill(0);
 const frameUpByteLength = Array(60 * 5).fill(0);
 
 /**
- * Configure webgl context and setup a new websocket connection to render frames.
+ * Send a message of the "stago" protocol.
+ *
+ * @param {Record<string, unknown>} message
+ * @returns {boolean} false if the connection is not open
+ */
+const send = (message) => {
+  if (ws?.readyState !== WebSocket.OPEN) {
+    return false;
+  }
+  const data = binary
+    ? encodeClientMessage({ id: ++messageId, ...message })
+    : JSON.stringify({ id: ++messageId, ...message });
+  frameUpByteLength[frame % frameUpByteLength.length] += data.length;
+  ws.send(data);
+  return true;
+};
+
+/**
+ * Queue an input event, sent with the events received until the batch delay.
+ *
+ * @param {Record<string, unknown>} event
+ */
+const sendInput = (event) => {
+  if (ws?.readyState !== WebSocket.OPEN) {
+    return;
+  }
+  pendingInputs.push(event);
+  if (inputTimer === undefined) {
+    inputTimer = setTimeout(flushInputs, inputBatchDelay);
+  }
+};
+
+const flushInputs = () => {
+  const events = pendingInputs;
+  pendingInputs = [];
+  inputTimer = undefined;
+  if (events.length === 1) {
+    send({ input: events[0] });
+  } else if (events.length > 1) {
+    send({ inputs: { events } });
+  }
+};
+
+/**
+ * Handle a text message of the server.
+ *
+ * @param {{render?: {resumeToken?: string}, pong?: {time: number}, error?: {id?: number, code?: string, message: string}}} message
+ */
+const receive = (message) => {
+  if (message.render?.resumeToken) {
+    resumeToken = message.render.resumeToken;
+  } else if (message.pong) {
+    RenderStatistics.rtt = performance.now() - message.pong.time;
+  } else if (message.error) {
+    console.error(`[ws] message ${message.error.id ?? 0} failed: ${message.error.code ?? "INTERNAL"} ${message.error.message}`);
+  }
+};
+
+/**
+ * Request the token of a new session.
+ */
+const requestToken = async () => {
+  /** @type {Record<string, string>} */
+  const headers = { "Content-Type": "application/json" };
+  if (userToken) {
+    headers["Authorization"] = `Bearer ${userToken}`;
+  }
+  const res = await fetch(tokenEndpoint, { method: "POST", headers, body: "{}" });
+  if (!res.ok) {
+    throw new Error(`[ws] token request failed: ${res.status} ${await res.text()}`);
+  }
+  const session = await res.json();
+  token = session.token;
+  userToken = session.token;
+  onUserToken(session.token);
+  options.session_id = session.session_id;
+  console.log(`[ws] session_id=${session.session_id} user_id=${session.user_id} role=${session.role}`);
+};
+
+/**
+ * Configure webgl context and setup a new websocket connection carrying
+ * render options, input events and frames of the session.
  * 
  * @param {Awaited<ReturnType<webgl.createContext>>} ctx
  * @param {boolean} reconnecting
  * @returns 
  */
-export const createRenderWebSocket = (ctx, reconnecting) => {
-  closeRenderWebSocket();
+export const createWebSocket = async (ctx, reconnecting) => {
+  closeWebSocket();
+
+  if (!token) {
+    await requestToken();
+  }
 
   frame = 0;
   let messageIndex = 0;
   let time = new Date().getTime();
 
   return new Promise((resolve) => {
-    // Use "render" protocol to receive frames in binary data
-    const ws = new WebSocket(endpoint, ["render"]);
-    ws.binaryType = "arraybuffer";
+    // Use "stago" protocol to send typed messages and receive frames in binary data,
+    // with messages in protobuf if the server accepts it
+    const socket = new WebSocket(`${endpoint}?token=${encodeURIComponent(token)}`, ["stago.proto", "stago"]);
+    socket.binaryType = "arraybuffer";
+
+    socket.onmessage = event => {
+      // Text messages are typed messages, frames are binary
+      if (typeof event.data === "string") {
+        receive(JSON.parse(event.data));
+        return;
+      }
 
-    ws.onmessage = event => {
       messageIndex++;
 
       frameTimes[frame % frameTimes.length] = new Date().getTime();
@@ -57,9 +178,14 @@ export const createRenderWebSocket = (ctx, reconnecting) => {
 
       const beforeRender = new Date().getTime();
 
-      ctx.handle(event.data, frame);
+      const sequence = ctx.handle(event.data, frame);
       ctx.render(frame);
 
+      // Acknowledge frame so the next ones only contain changes
+      if (sequence > 0) {
+        send({ ack: sequence });
+      }
+
       const now = new Date().getTime();
 
       // Compute statistics every second
@@ -106,32 +232,74 @@ export const createRenderWebSocket = (ctx, reconnecting) => {
       }
     };
 
-    ws.onclose = event => {
+    socket.onclose = event => {
+      clearInterval(pingTimer);
+      clearTimeout(inputTimer);
+      inputTimer = undefined;
+      pendingInputs = [];
       if (event.code === 1006) {
-        setTimeout(() => resolve(createRenderWebSocket(ctx, true)), 1000);
+        setTimeout(() => resolve(createWebSocket(ctx, true)), 1000);
+      } else if (event.code === 1008 && event.reason === "invalid session token") {
+        // Token expired or server restarted, open a new session
+        console.warn(`[ws] ${event.reason}`);
+        token = "";
+        resumeToken = "";
+        setTimeout(() => resolve(createWebSocket(ctx, true)), 1000);
+      } else if (event.code === 1008 && resumeToken) {
+        // Session cannot be resumed, open a new one
+        console.warn(`[ws] session not resumed: ${event.reason}`);
+        resumeToken = "";
+        setTimeout(() => resolve(createWebSocket(ctx, true)), 1000);
+      } else if (event.code === 1008) {
+        console.error(`[ws] connection refused by server: ${event.reason}`);
       } else {
-        console.log("[ws:render] connection closed");
+        console.log("[ws] connection closed");
       }
     };
 
-    ws.onopen = event => {
+    socket.onopen = event => {
       if (reconnecting) {
         ctx.reset();
       }
-      renderWs = ws;
-      console.log("[ws:render] connection open");
-      sendRenderOptions();
+      ws = socket;
+      binary = socket.protocol === "stago.proto";
+      console.log(`[ws] connection open protocol=${socket.protocol}`);
+      // Client state is empty, request all blocks
+      send({ render: { ...options, keyframe: true, resume_token: resumeToken || undefined } });
+      pingTimer = setInterval(() => send({ ping: { time: performance.now() } }), pingInterval);
       resolve();
     };
   });
 };
 
-export const closeRenderWebSocket = () => {
-  if (renderWs?.readyState === WebSocket.OPEN || renderWs?.readyState === WebSocket.CONNECTING) {
-    renderWs.close(1000);
+export const closeWebSocket = () => {
+  if (ws?.readyState === WebSocket.OPEN || ws?.readyState === WebSocket.CONNECTING) {
+    ws.close(1000);
   }
 };
 
+/**
+ * Control the time of the scene of the session.
+ *
+ * @param {{pause?: boolean, resume?: boolean, step?: number, time_scale?: number}} control 
+ */
+export const sendTimeControl = (control) => {
+  send({ render: { time_control: control } });
+};
+
+/**
+ * Identify the user with the token of a previous session, before the
+ * connection is open. Tokens of new sessions are passed to onToken, to
+ * be kept for the next session.
+ *
+ * @param {string | null} lastToken
+ * @param {(token: string) => void} onToken
+ */
+export const setUserToken = (lastToken, onToken) => {
+  userToken = lastToken ?? "";
+  onUserToken = onToken;
+};
+
 /**
  * 
  * @param {Record<string, unknown>} newOptions 
@@ -142,37 +310,7 @@ export const sendRenderOptions = (newOptions) => {
       options[key] = value;
     });
   }
-  if (renderWs?.readyState === WebSocket.OPEN) {
-    renderWs.send(JSON.stringify(options));
-  }
-};
-
-/**
- * @param {boolean} reconnecting
- * @returns {Promise<WebSocket>}
- */
-export const createInputWebsocket = (reconnecting) => {
-  if (inputWs?.readyState === WebSocket.OPEN || inputWs?.readyState === WebSocket.CONNECTING) {
-    inputWs.close(1000);
-  }
-
-  return new Promise((resolve) => {
-    const ws = new WebSocket(endpoint, ["input"]);
-
-    ws.onopen = event => {
-      console.log("[ws:input] connection open");
-      inputWs = ws;
-      resolve(ws);
-    };
-
-    ws.onclose = (event) => {
-      if (event.code === 1006) {
-        setTimeout(() => resolve(createInputWebsocket(true)), 1000);
-      } else {
-        console.log("[ws:input] connection closed");
-      }
-    };
-  })
+  send({ render: options });
 };
 
 /**
@@ -181,12 +319,7 @@ export const createInputWebsocket = (reconnecting) => {
  * @param {number} y 
  */
 export const sendMouseMoveEvent = (x, y, dx, dy) => {
-  // TODO: Send binary data
-  if (inputWs?.readyState === WebSocket.OPEN && renderWs?.readyState === WebSocket.OPEN) {
-    const message = JSON.stringify({ session_id: sessionId, device: 0, x, y, deltaX: dx, deltaY: dy });
-    frameUpByteLength[frame % frameDownByteLength.length] += message.length;
-    inputWs.send(message);
-  }
+  sendInput({ device: 0, x, y, deltaX: dx, deltaY: dy });
 };
 
 /**
@@ -195,12 +328,7 @@ export const sendMouseMoveEvent = (x, y, dx, dy) => {
  * @param {number} y 
  */
 export const sendMouseDragEvent = (x, y) => {
-  // TODO: Send binary data
-  if (inputWs?.readyState === WebSocket.OPEN && renderWs?.readyState === WebSocket.OPEN) {
-    const message = JSON.stringify({ session_id: sessionId, device: 0, pressed: true, x, y });
-    frameUpByteLength[frame % frameDownByteLength.length] += message.length;
-    inputWs.send(message);
-  }
+  sendInput({ device: 0, pressed: true, x, y });
 };
 
 /**
@@ -209,12 +337,7 @@ export const sendMouseDragEvent = (x, y) => {
  * @param {number} y 
  */
 export const sendMouseClickEvent = (x, y) => {
-  // TODO: Send binary data
-  if (inputWs?.readyState === WebSocket.OPEN && renderWs?.readyState === WebSocket.OPEN) {
-    const message = JSON.stringify({ session_id: sessionId, device: 0, pressed: true, released: true, x, y });
-    frameUpByteLength[frame % frameDownByteLength.length] += message.length;
-    inputWs.send(message);
-  }
+  sendInput({ device: 0, pressed: true, released: true, x, y });
 };
 
 /**
@@ -224,12 +347,7 @@ export const sendMouseClickEvent = (x, y) => {
  * @param {number} deltaY
  */
 export const sendScrollEvent = (x, y, deltaY) => {
-  // TODO: Send binary data
-  if (inputWs?.readyState === WebSocket.OPEN && renderWs?.readyState === WebSocket.OPEN) {
-    const message = JSON.stringify({ session_id: sessionId, device: 0, x, y, scrolled: true, delta: deltaY });
-    frameUpByteLength[frame % frameDownByteLength.length] += message.length;
-    inputWs.send(message);
-  }
+  sendInput({ device: 0, x, y, scrolled: true, delta: deltaY });
 };
 
 /**
@@ -237,12 +355,7 @@ export const sendScrollEvent = (x, y, deltaY) => {
  * @param {string} key
  */
 export const sendKeydownEvent = (key) => {
-  // TODO: Send binary data
-  if (inputWs?.readyState === WebSocket.OPEN && renderWs?.readyState === WebSocket.OPEN) {
-    const message = JSON.stringify({ session_id: sessionId, device: 1, code: key, pressed: true });
-    frameUpByteLength[frame % frameDownByteLength.length] += message.length;
-    inputWs.send(message);
-  }
+  sendInput({ device: 1, code: key, pressed: true });
 };
 
 /**
@@ -250,10 +363,5 @@ export const sendKeydownEvent = (key) => {
  * @param {string} key
  */
 export const sendKeyupEvent = (key) => {
-  // TODO: Send binary data
-  if (inputWs?.readyState === WebSocket.OPEN && renderWs?.readyState === WebSocket.OPEN) {
-    const message = JSON.stringify({ session_id: sessionId, device: 1, code: key, pressed: false });
-    frameUpByteLength[frame % frameDownByteLength.length] += message.length;
-    inputWs.send(message);
-  }
+  sendInput({ device: 1, code: key, pressed: false });
 };
\ No newline at end of file
@@ -24,10 +24,10 @@ self.onmessage = (e) => {
     case "setup": {
       /** @type {OffscreenCanvas} */
       const canvas = data[0];
+      websocket.setUserToken(data[1], (token) => self.postMessage(["userToken", token]));
       (async () => {
         renderContext = await webgl.createContext(canvas, "webgpu");
-        await websocket.createInputWebsocket();
-        await websocket.createRenderWebSocket(renderContext);
+        await websocket.createWebSocket(renderContext);
         websocket.sendRenderOptions({ width: canvas.width, height: canvas.height });
       })().then(() => {
         self.postMessage(["ready"]);
@@ -62,8 +62,13 @@ self.onmessage = (e) => {
       break;
     }
 
+    case "timeControl": {
+      websocket.sendTimeControl(data[0]);
+      break;
+    }
+
     case "stop": {
-      websocket.closeRenderWebSocket();
+      websocket.closeWebSocket();
       break;
     }
 
@@ -32,5 +32,8 @@ module.exports = {
     compress: true,
     hot: true,
     port: 8080,
+    proxy: [
+      { context: ["/token"], target: "http://localhost:9090" },
+    ],
   }
 };
\ No newline at end of file